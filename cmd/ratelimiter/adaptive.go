@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"RateLimiterService/pkg/ratelimiter"
+	"RateLimiterService/pkg/service"
+)
+
+const (
+	feedbackPath      = "/api/v1/rate-limit/feedback"
+	adminAdaptivePath = "/api/v1/admin/adaptive"
+)
+
+// FeedbackRequest reports the outcome of one call to the protected backend
+type FeedbackRequest struct {
+	Error     bool    `json:"error"`
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+}
+
+type FeedbackResponse struct {
+	Rate int64 `json:"rate"`
+}
+
+type AdaptiveResponse struct {
+	Rate      int64  `json:"rate"`
+	Min       int64  `json:"min"`
+	Max       int64  `json:"max"`
+	Good      uint64 `json:"good"`
+	Bad       uint64 `json:"bad"`
+	Increases uint64 `json:"increases"`
+	Decreases uint64 `json:"decreases"`
+}
+
+// loadAdaptiveConfig reads ADAPTIVE_* variables. Adaptive limiting is off
+// unless ADAPTIVE is true, and then needs ADAPTIVE_FEEDBACK_TOKEN: the rate
+// is shared by every key, so anyone who could report errors could throttle
+// them all.
+func loadAdaptiveConfig(algorithm string) (*ratelimiter.AIMDOptions, error) {
+	if on, _ := strconv.ParseBool(os.Getenv("ADAPTIVE")); !on {
+		return nil, nil
+	}
+	if algorithm != "tokenbucket" {
+		return nil, fmt.Errorf("ADAPTIVE needs ALGORITHM=tokenbucket, not %q", algorithm)
+	}
+	if os.Getenv("STORE_BACKEND") == "redis" {
+		return nil, fmt.Errorf("ADAPTIVE is not supported with STORE_BACKEND=redis")
+	}
+	if os.Getenv("ADAPTIVE_FEEDBACK_TOKEN") == "" {
+		return nil, fmt.Errorf("ADAPTIVE needs ADAPTIVE_FEEDBACK_TOKEN")
+	}
+	opts := &ratelimiter.AIMDOptions{
+		Interval: envSeconds("ADAPTIVE_INTERVAL_SECONDS", 1),
+	}
+	for name, dst := range map[string]*int64{
+		"ADAPTIVE_MIN_RATE": &opts.Min,
+		"ADAPTIVE_MAX_RATE": &opts.Max,
+		"ADAPTIVE_INCREASE": &opts.Increase,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	var err error
+	if opts.Decrease, err = envFraction("ADAPTIVE_DECREASE", 0.5); err != nil {
+		return nil, err
+	}
+	if opts.Decrease <= 0 || opts.Decrease >= 1 {
+		return nil, fmt.Errorf("ADAPTIVE_DECREASE must be between 0 and 1, got %v", opts.Decrease)
+	}
+	if opts.ErrorRate, err = envFraction("ADAPTIVE_ERROR_RATE", 0.05); err != nil {
+		return nil, err
+	}
+	if opts.ErrorRate <= 0 || opts.ErrorRate > 1 {
+		return nil, fmt.Errorf("ADAPTIVE_ERROR_RATE must be above 0 and at most 1, got %v", opts.ErrorRate)
+	}
+	if v := os.Getenv("ADAPTIVE_LATENCY_MS"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 {
+			return nil, fmt.Errorf("invalid ADAPTIVE_LATENCY_MS %q", v)
+		}
+		opts.Latency = time.Duration(ms) * time.Millisecond
+	}
+	return opts, nil
+}
+
+// registerAdaptiveHandlers serves the feedback endpoint clients report
+// backend outcomes to, and the admin view of the effective rate on admin.
+// With adaptive limiting on, feedback needs token as a bearer token.
+func registerAdaptiveHandlers(mux, admin *http.ServeMux, svc *service.RateLimitService, token string) {
+	feedback := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req FeedbackRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.LatencyMs < 0 {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		err := svc.Feedback(ratelimiter.Feedback{
+			Error:   req.Error,
+			Latency: time.Duration(req.LatencyMs * float64(time.Millisecond)),
+		})
+		if err != nil {
+			http.Error(w, "Adaptive limiting is disabled", http.StatusNotFound)
+			return
+		}
+		a, _ := svc.Adaptive()
+		writeJSON(w, http.StatusOK, FeedbackResponse{Rate: a.Rate()})
+	}
+	if _, ok := svc.Adaptive(); ok {
+		feedback = requireBearer(token, feedback)
+	}
+	mux.HandleFunc(feedbackPath, feedback)
+
+	admin.HandleFunc(adminAdaptivePath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a, ok := svc.Adaptive()
+		if !ok {
+			http.Error(w, "Adaptive limiting is disabled", http.StatusNotFound)
+			return
+		}
+		st, opts := a.Stats(), a.Options()
+		writeJSON(w, http.StatusOK, AdaptiveResponse{
+			Rate:      st.Rate,
+			Min:       opts.Min,
+			Max:       opts.Max,
+			Good:      st.Good,
+			Bad:       st.Bad,
+			Increases: st.Increases,
+			Decreases: st.Decreases,
+		})
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"RateLimiterService/pkg/ratelimiter"
+	"RateLimiterService/pkg/service"
+	"RateLimiterService/pkg/sketch"
+)
+
+const (
+	adminPrefix   = "/api/v1/admin/"
+	adminKeysPath = "/api/v1/admin/keys"
+	adminTopPath  = "/api/v1/admin/top"
+)
+
+// defaultKeysPageSize caps key listings when the caller gives no limit
+const defaultKeysPageSize = 100
+
+type KeyStateResponse struct {
+	Key        string   `json:"key"`
+	Type       string   `json:"type"`
+	Tokens     *int64   `json:"tokens,omitempty"`
+	LastRefill string   `json:"last_refill,omitempty"`
+	Timestamps []string `json:"timestamps,omitempty"`
+	TAT        string   `json:"tat,omitempty"`
+	// Per-node counts for crdtwindow's current and previous windows
+	Counts     map[string]uint64 `json:"counts,omitempty"`
+	PrevCounts map[string]uint64 `json:"prev_counts,omitempty"`
+}
+
+// TopKeysResponse lists the keys checked and denied most often. Counts may
+// be overestimated by up to each entry's error.
+type TopKeysResponse struct {
+	TotalRequests uint64           `json:"total_requests"`
+	TotalDenied   uint64           `json:"total_denied"`
+	Requests      []sketch.Counted `json:"requests"`
+	Denied        []sketch.Counted `json:"denied"`
+}
+
+type KeyListResponse struct {
+	Keys       []string `json:"keys"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// resetter drops a key's state: the service itself, or the cluster resetting
+// it on every node holding a copy
+type resetter interface {
+	Reset(key string) bool
+}
+
+// requireAdmin serves the admin API to requests bearing token. Without a
+// token the API is disabled, as it can read and reset any key's state.
+func requireAdmin(token string, admin http.Handler) http.Handler {
+	if token == "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Admin API is disabled", http.StatusNotFound)
+		})
+	}
+	return requireBearer(token, admin.ServeHTTP)
+}
+
+func registerAdminHandlers(mux *http.ServeMux, svc *service.RateLimitService, reset resetter) {
+	mux.HandleFunc(adminKeysPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		limit := defaultKeysPageSize
+		if l := q.Get("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		keys, next := svc.Keys(q.Get("prefix"), q.Get("cursor"), limit)
+		if keys == nil {
+			keys = []string{}
+		}
+		writeJSON(w, http.StatusOK, KeyListResponse{Keys: keys, NextCursor: next})
+	})
+
+	mux.HandleFunc(adminKeysPath+"/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, adminKeysPath+"/")
+		if key == "" {
+			http.Error(w, "Missing key", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			val, ok := svc.State(key)
+			if !ok {
+				http.Error(w, "Key not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, keyStateResponse(key, val))
+		case http.MethodDelete:
+			if !reset.Reset(key) {
+				http.Error(w, "Key not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	registerTopHandler(mux, svc)
+}
+
+// registerTopHandler serves the heavy hitters: GET lists them, DELETE starts
+// counting afresh, e.g. once an incident is over
+func registerTopHandler(mux *http.ServeMux, svc *service.RateLimitService) {
+	mux.HandleFunc(adminTopPath, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			limit := math.MaxInt
+			if l := r.URL.Query().Get("limit"); l != "" {
+				n, err := strconv.Atoi(l)
+				if err != nil || n <= 0 {
+					http.Error(w, "Invalid limit", http.StatusBadRequest)
+					return
+				}
+				limit = n
+			}
+			hh, ok := svc.HeavyHitters(limit)
+			if !ok {
+				http.Error(w, "Heavy hitter tracking is disabled", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, TopKeysResponse{
+				TotalRequests: hh.TotalRequests,
+				TotalDenied:   hh.TotalDenied,
+				Requests:      hh.Requests,
+				Denied:        hh.Denied,
+			})
+		case http.MethodDelete:
+			svc.ResetHeavyHitters()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func keyStateResponse(key string, val interface{}) KeyStateResponse {
+	resp := KeyStateResponse{Key: key}
+	switch state := val.(type) {
+	case ratelimiter.TokenBucketState:
+		resp.Type = "tokenbucket"
+		tokens := state.Tokens
+		resp.Tokens = &tokens
+		resp.LastRefill = state.LastTime.Format(time.RFC3339Nano)
+	case ratelimiter.SlidingWindowState:
+		resp.Type = "slidingwindow"
+		resp.Timestamps = make([]string, len(state.Requests))
+		for i, t := range state.Requests {
+			resp.Timestamps[i] = t.Format(time.RFC3339Nano)
+		}
+	case ratelimiter.GCRAState:
+		resp.Type = "gcra"
+		resp.TAT = state.TAT.Format(time.RFC3339Nano)
+	case ratelimiter.CRDTWindowState:
+		resp.Type = "crdtwindow"
+		resp.Counts = state.Curr
+		resp.PrevCounts = state.Prev
+	default:
+		resp.Type = "unknown"
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// requireBearer rejects requests without "Authorization: Bearer <token>".
+// An empty token rejects everything.
+func requireBearer(token string, next http.HandlerFunc) http.HandlerFunc {
+	want := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
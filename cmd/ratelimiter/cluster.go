@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"RateLimiterService/pkg/cluster"
+	"RateLimiterService/pkg/service"
+)
+
+const adminClusterPath = "/api/v1/admin/cluster"
+
+// clusterConfig is read from CLUSTER_* variables. Cluster mode is off unless
+// CLUSTER_ADVERTISE_ADDR is set.
+type clusterConfig struct {
+	Self      cluster.Node
+	Peers     []cluster.Node
+	DNS       string                // host resolved for peers instead of a static list
+	Gossip    cluster.GossipOptions // used if it has seeds
+	Interval  time.Duration
+	Secret    string
+	Timeout   time.Duration
+	MaxOffset time.Duration // how far ahead a peer's clock may run
+	// Replicas is how many nodes besides the owner keep each key
+	Replicas    int
+	Replication cluster.ReplicationMode
+}
+
+func loadClusterConfig() (*clusterConfig, error) {
+	addr := os.Getenv("CLUSTER_ADVERTISE_ADDR")
+	if addr == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid CLUSTER_ADVERTISE_ADDR %q", addr)
+	}
+	// Internal endpoints share the public listener, and can rewrite any
+	// key's state or the membership
+	secret := os.Getenv("CLUSTER_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("CLUSTER_SECRET is required in cluster mode")
+	}
+	id := os.Getenv("CLUSTER_NODE_ID")
+	if id == "" {
+		id = addr
+	}
+	peers, err := cluster.ParsePeers(os.Getenv("CLUSTER_PEERS"))
+	if err != nil {
+		return nil, fmt.Errorf("CLUSTER_PEERS: %w", err)
+	}
+	seeds, err := cluster.ParsePeers(os.Getenv("CLUSTER_SEEDS"))
+	if err != nil {
+		return nil, fmt.Errorf("CLUSTER_SEEDS: %w", err)
+	}
+	var replicas int
+	if v := os.Getenv("CLUSTER_REPLICAS"); v != "" {
+		replicas, err = strconv.Atoi(v)
+		if err != nil || replicas < 0 {
+			return nil, fmt.Errorf("invalid CLUSTER_REPLICAS %q", v)
+		}
+	}
+	mode, err := cluster.ParseReplicationMode(os.Getenv("CLUSTER_REPLICATION"))
+	if err != nil {
+		return nil, fmt.Errorf("CLUSTER_REPLICATION: %w", err)
+	}
+	var seedAddrs []string
+	for _, s := range seeds {
+		seedAddrs = append(seedAddrs, s.Addr)
+	}
+	return &clusterConfig{
+		Self:  cluster.Node{ID: id, Addr: addr},
+		Peers: peers,
+		DNS:   os.Getenv("CLUSTER_DNS"),
+		Gossip: cluster.GossipOptions{
+			Seeds:            seedAddrs,
+			Interval:         envSeconds("CLUSTER_GOSSIP_INTERVAL_SECONDS", 1),
+			SuspicionTimeout: envSeconds("CLUSTER_SUSPICION_TIMEOUT_SECONDS", 5),
+		},
+		Interval:  envSeconds("CLUSTER_DISCOVERY_INTERVAL_SECONDS", 10),
+		Secret:    secret,
+		Timeout:   envSeconds("CLUSTER_RPC_TIMEOUT_SECONDS", 1),
+		MaxOffset: envSeconds("CLUSTER_MAX_CLOCK_OFFSET_SECONDS", 5),
+
+		Replicas:    replicas,
+		Replication: mode,
+	}, nil
+}
+
+// discovery returns how to look peers up, or nil for a static list
+func (cc *clusterConfig) discovery() cluster.Discovery {
+	if cc.DNS == "" {
+		return nil
+	}
+	u, _ := url.Parse(cc.Self.Addr)
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	return cluster.DNSPeers(cc.DNS, port)
+}
+
+func newCluster(cc *clusterConfig, svc *service.RateLimitService, logger *slog.Logger) (*cluster.Cluster, error) {
+	return cluster.New(svc, cluster.Options{
+		Self:    cc.Self,
+		Peers:   cc.Peers,
+		Timeout: cc.Timeout,
+		Secret:  cc.Secret,
+		Logger:  logger,
+
+		Replicas:    cc.Replicas,
+		Replication: cc.Replication,
+	})
+}
+
+type ClusterResponse struct {
+	Self    string         `json:"self"`
+	Members []cluster.Node `json:"members"`
+	// Gossip is every node gossip knows about, including suspect, dead and
+	// departed ones, which aren't on the ring
+	Gossip []cluster.Member `json:"gossip,omitempty"`
+}
+
+// registerClusterHandlers serves the internal endpoints, and the cluster's
+// view on admin. g is nil without gossip membership.
+func registerClusterHandlers(mux, admin *http.ServeMux, c *cluster.Cluster, g *cluster.Gossip) {
+	mux.Handle(cluster.InternalPath, c.Handler())
+	admin.HandleFunc(adminClusterPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		resp := ClusterResponse{Self: c.Self().ID, Members: c.Members()}
+		if g != nil {
+			resp.Gossip = g.Members()
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"RateLimiterService/pkg/middleware"
+	"RateLimiterService/pkg/service"
+)
+
+// loadConcurrencyConfig reads CONCURRENCY_* variables. The concurrency limit
+// is off unless CONCURRENCY_LIMIT names an algorithm.
+func loadConcurrencyConfig() (*service.Concurrency, error) {
+	algorithm := os.Getenv("CONCURRENCY_LIMIT")
+	switch algorithm {
+	case "":
+		return nil, nil
+	case "gradient", "vegas":
+	default:
+		return nil, fmt.Errorf("invalid CONCURRENCY_LIMIT %q", algorithm)
+	}
+	cc := &service.Concurrency{
+		Algorithm: algorithm,
+		Window:    envMillis("CONCURRENCY_WINDOW_MS", 1000),
+	}
+	for name, dst := range map[string]*int{
+		"CONCURRENCY_INITIAL": &cc.Initial,
+		"CONCURRENCY_MIN":     &cc.Min,
+		"CONCURRENCY_MAX":     &cc.Max,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	return cc, nil
+}
+
+// limitConcurrency puts next behind the service's concurrency limit, if it
+// has one
+func limitConcurrency(svc *service.RateLimitService, next http.Handler) http.Handler {
+	if _, ok := svc.Concurrency(); !ok {
+		return next
+	}
+	return middleware.Concurrency(svc, next)
+}
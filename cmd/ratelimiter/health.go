@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// healthChecks is a named set of probes served as a single endpoint
+type healthChecks struct {
+	mu     sync.RWMutex
+	names  []string
+	checks map[string]func() error
+}
+
+func newHealthChecks() *healthChecks {
+	return &healthChecks{checks: make(map[string]func() error)}
+}
+
+func (h *healthChecks) add(name string, check func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// ServeHTTP runs every check and answers 503 if any fail
+func (h *healthChecks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	resp := HealthResponse{Status: "ok", Checks: make(map[string]string, len(h.names))}
+	status := http.StatusOK
+	for _, name := range h.names {
+		if err := h.checks[name](); err != nil {
+			resp.Checks[name] = err.Error()
+			resp.Status = "fail"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+	writeJSON(w, status, resp)
+}
+
+// readyFlag is a check that fails with err until set is called
+type readyFlag struct {
+	ready atomic.Bool
+	err   error
+}
+
+func newReadyFlag(reason string) *readyFlag {
+	return &readyFlag{err: errors.New(reason)}
+}
+
+func (f *readyFlag) set() {
+	f.ready.Store(true)
+}
+
+func (f *readyFlag) check() error {
+	if f.ready.Load() {
+		return nil
+	}
+	return f.err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"RateLimiterService/pkg/audit"
+)
+
+// newLogger builds the process logger from LOG_LEVEL and LOG_FORMAT
+func newLogger(w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if lvl := os.Getenv("LOG_LEVEL"); lvl != "" {
+		if err := level.UnmarshalText([]byte(lvl)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q", lvl)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q", format)
+	}
+}
+
+// newAuditLogger opens the decision audit trail if AUDIT_LOG_PATH is set,
+// reporting failed rotations to logger. It returns a nil logger and closer
+// when auditing is disabled.
+func newAuditLogger(logger *slog.Logger) (*audit.Logger, io.Closer, error) {
+	path := os.Getenv("AUDIT_LOG_PATH")
+	if path == "" {
+		return nil, nil, nil
+	}
+
+	sampleRate := 1.0
+	if v := os.Getenv("AUDIT_SAMPLE_RATE"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r < 0 || r > 1 {
+			return nil, nil, fmt.Errorf("invalid AUDIT_SAMPLE_RATE %q", v)
+		}
+		sampleRate = r
+	}
+	maxSizeMB := 100
+	if v := os.Getenv("AUDIT_MAX_SIZE_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("invalid AUDIT_MAX_SIZE_MB %q", v)
+		}
+		maxSizeMB = n
+	}
+	maxBackups := 5
+	if v := os.Getenv("AUDIT_MAX_BACKUPS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("invalid AUDIT_MAX_BACKUPS %q", v)
+		}
+		maxBackups = n
+	}
+
+	f, err := audit.OpenRotatingFile(path, int64(maxSizeMB)<<20, maxBackups)
+	if err != nil {
+		return nil, nil, err
+	}
+	f.SetOnError(func(err error) {
+		logger.Error("audit log rotation failed, writing on past the size limit", "error", err)
+	})
+	return audit.NewLogger(f, sampleRate), f, nil
+}
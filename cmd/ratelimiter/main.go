@@ -1,27 +1,265 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
+	"RateLimiterService/pkg/audit"
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/cluster"
+	"RateLimiterService/pkg/ratelimiter"
 	"RateLimiterService/pkg/service"
 )
 
 type CheckRequest struct {
 	Key string `json:"key"`
+	// Priority is "critical", "default" or "batch"; empty means default
+	Priority ratelimiter.Priority `json:"priority,omitempty"`
+}
+
+// checker decides checks: the service itself, or the cluster routing each
+// key to its owner
+type checker interface {
+	CheckRateLimitPriority(key string, p ratelimiter.Priority) service.Decision
 }
 
 type CheckResponse struct {
-	Allowed   bool   `json:"allowed"`
-	Remaining int64  `json:"remaining,omitempty"`
-	ResetAt   string `json:"reset_at,omitempty"`
+	Allowed   bool                 `json:"allowed"`
+	Remaining int64                `json:"remaining,omitempty"`
+	Priority  ratelimiter.Priority `json:"priority,omitempty"`
+	ResetAt   string               `json:"reset_at,omitempty"`
 }
 
 func main() {
+	logger, err := newLogger(os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	if err := run(logger); err != nil {
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(logger *slog.Logger) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	auditLog, auditFile, err := newAuditLogger(logger)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	if auditFile != nil {
+		defer auditFile.Close()
+	}
+
+	cc, err := loadClusterConfig()
+	if err != nil {
+		return err
+	}
+	if cc != nil {
+		config.Clock = clock.NewHLC(cc.Self.ID, clock.RealClock{}, cc.MaxOffset)
+		config.Node = cc.Self.ID
+	}
+
+	hooks := newHookMetrics()
+	config.Skew = &ratelimiter.SkewGuard{
+		MaxForward: envSeconds("MAX_CLOCK_JUMP_SECONDS", 0),
+		OnJump:     hooks.observeClockJump,
+	}
+	st, err := openStore(config, hooks.observeEviction)
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	svc, err := service.NewRateLimitServiceWithStore(config, st)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := svc.Close(); err != nil {
+			logger.Error("failed to close store", "error", err)
+		}
+	}()
+	m := newServiceMetrics(svc, hooks)
+	if on, _ := strconv.ParseBool(os.Getenv("TOP_KEYS_METRICS")); on {
+		m.registerTopKeys(svc)
+	}
+
+	mux := http.NewServeMux()
+	admin := http.NewServeMux()
+	var check checker = svc
+	var reset resetter = svc
+	var peers *cluster.Cluster
+	var gossip *cluster.Gossip
+	var counters *cluster.CounterSync
+	if cc != nil {
+		peers, err = newCluster(cc, svc, logger)
+		if err != nil {
+			return err
+		}
+		if len(cc.Gossip.Seeds) > 0 {
+			gossip = cluster.NewGossip(peers, cc.Gossip)
+		}
+		if r, ok := svc.Replicated(); ok {
+			counters = cluster.NewCounterSync(peers, r, envMillis("CRDT_SYNC_INTERVAL_MS", 1000))
+		}
+		check, reset = peers, peers
+		registerClusterHandlers(mux, admin, peers, gossip)
+		m.registerCluster(peers)
+	}
+	mux.Handle("/api/v1/rate-limit/check", limitConcurrency(svc, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req CheckRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		key := req.Key
+		if key == "" {
+			key = r.RemoteAddr
+		}
+
+		start := time.Now()
+		decision := check.CheckRateLimitPriority(key, req.Priority)
+		elapsed := time.Since(start)
+		m.observeCheck(svc.Policy(), decision.Allowed, elapsed)
+		if auditLog != nil {
+			auditLog.Record(audit.Decision{
+				Key:       key,
+				Policy:    svc.Policy(),
+				Priority:  decision.Priority.String(),
+				Allowed:   decision.Allowed,
+				Remaining: decision.Remaining,
+				Latency:   elapsed,
+			})
+		}
+		logger.Debug("rate limit check", "key", key, "priority", decision.Priority, "allowed", decision.Allowed, "remaining", decision.Remaining)
+
+		resp := CheckResponse{Allowed: decision.Allowed, Remaining: decision.Remaining, Priority: decision.Priority}
+		status := http.StatusOK
+		if !decision.Allowed {
+			status = http.StatusTooManyRequests
+			// For simplicity, no reset_at
+		}
+		writeJSON(w, status, resp)
+	})))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Probes must not go through the check endpoint: that would spend real tokens
+	liveness := newHealthChecks()
+	liveness.add("store", svc.Live)
+	readiness := newHealthChecks()
+	readiness.add("store", svc.Ready)
+	readiness.add("shutdown", func() error {
+		if ctx.Err() != nil {
+			return errors.New("shutting down")
+		}
+		return nil
+	})
+
+	snap := newSnapshotter(svc, logger)
+	if snap != nil {
+		readiness.add("snapshot", snap.restored.check)
+		go func() {
+			// Don't save until the old snapshot has been read back in
+			snap.restore()
+			snap.run(ctx)
+		}()
+	}
+
+	gossipDone := make(chan struct{})
+	switch {
+	case gossip != nil:
+		go func() {
+			defer close(gossipDone)
+			gossip.Run(ctx)
+		}()
+	case peers != nil && cc.discovery() != nil:
+		go peers.Watch(ctx, cc.discovery(), cc.Interval)
+	}
+	if counters != nil {
+		go counters.Run(ctx)
+	}
+
+	registerAdminHandlers(admin, svc, reset)
+	registerAdaptiveHandlers(mux, admin, svc, os.Getenv("ADAPTIVE_FEEDBACK_TOKEN"))
+	mux.Handle(adminPrefix, requireAdmin(os.Getenv("ADMIN_TOKEN"), admin))
+	mux.Handle("/metrics", m.registry.Handler())
+	mux.Handle("/healthz", liveness)
+	mux.Handle("/readyz", readiness)
+
+	sc := loadServerConfig()
+	srv := &http.Server{
+		Addr:         sc.Addr,
+		Handler:      mux,
+		ReadTimeout:  sc.ReadTimeout,
+		WriteTimeout: sc.WriteTimeout,
+		IdleTimeout:  sc.IdleTimeout,
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+
+	logger.Info("starting server", "addr", sc.Addr, "algorithm", config.Algorithm, "policy", svc.Policy(), "audit", auditLog != nil, "cluster", cc != nil)
+	if err := serve(ctx, srv, sc.ShutdownTimeout, logger); err != nil {
+		return err
+	}
+	if peers != nil {
+		if gossip != nil {
+			<-gossipDone
+		}
+		if counters != nil {
+			// Send what the others haven't seen yet
+			counters.Push(context.Background())
+		}
+		// Hand our keys to the nodes that inherit them, then tell the rest
+		if err := peers.Leave(peers.Self().ID); err != nil {
+			logger.Error("failed to hand off state", "error", err)
+		}
+		if gossip != nil {
+			gossip.Leave(context.Background())
+		}
+	}
+	if snap != nil && snap.restored.check() == nil {
+		snap.save()
+	}
+	logger.Info("server stopped")
+	return nil
+}
+
+// envFraction reads a non-negative float, falling back to def when unset
+func envFraction(name string, def float64) (float64, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || !(f >= 0) {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return f, nil
+}
+
+// loadConfig parses the limiter configuration from environment variables
+func loadConfig() (service.Config, error) {
 	algorithm := os.Getenv("ALGORITHM")
 	if algorithm == "" {
 		algorithm = "tokenbucket"
@@ -39,14 +277,25 @@ func main() {
 	maxKeys, _ := strconv.Atoi(maxKeysStr)
 	// default 0 (unlimited)
 
+	var maxBytes int64
+	if v := os.Getenv("MAX_MEMORY_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return service.Config{}, fmt.Errorf("invalid MAX_MEMORY_BYTES %q", v)
+		}
+		maxBytes = n
+	}
+
 	config := service.Config{
+		Policy:    os.Getenv("POLICY_NAME"),
 		Algorithm: algorithm,
 		TTL:       ttl,
 		MaxKeys:   maxKeys,
+		MaxBytes:  maxBytes,
 	}
 
 	switch algorithm {
-	case "tokenbucket":
+	case "tokenbucket", "gcra":
 		capacityStr := os.Getenv("CAPACITY")
 		capacity, _ := strconv.ParseInt(capacityStr, 10, 64)
 		if capacity == 0 {
@@ -59,7 +308,7 @@ func main() {
 		}
 		config.Capacity = capacity
 		config.Rate = rate
-	case "slidingwindow":
+	case "slidingwindow", "crdtwindow", "countmin":
 		windowSizeStr := os.Getenv("WINDOW_SIZE_SECONDS")
 		windowSizeSec, _ := strconv.Atoi(windowSizeStr)
 		if windowSizeSec == 0 {
@@ -72,45 +321,65 @@ func main() {
 		}
 		config.WindowSize = time.Duration(windowSizeSec) * time.Second
 		config.MaxRequests = maxRequests
+		if algorithm == "crdtwindow" {
+			if os.Getenv("STORE_BACKEND") == "redis" {
+				return config, fmt.Errorf("ALGORITHM=crdtwindow is not supported with STORE_BACKEND=redis")
+			}
+			budget, err := envFraction("CRDT_ERROR_BUDGET", 0.1)
+			if err != nil {
+				return config, err
+			}
+			config.ErrorBudget = budget
+		}
 	default:
-		fmt.Println("Invalid algorithm")
-		os.Exit(1)
+		return config, fmt.Errorf("invalid algorithm %q", algorithm)
 	}
 
-	svc := service.NewRateLimitService(config)
+	adaptive, err := loadAdaptiveConfig(algorithm)
+	if err != nil {
+		return config, err
+	}
+	config.Adaptive = adaptive
 
-	http.HandleFunc("/api/v1/rate-limit/check", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	if config.Reserved, err = loadReservedConfig(algorithm); err != nil {
+		return config, err
+	}
+
+	if config.Concurrency, err = loadConcurrencyConfig(); err != nil {
+		return config, err
+	}
+
+	if v := os.Getenv("TOP_KEYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return config, fmt.Errorf("invalid TOP_KEYS %q", v)
 		}
+		config.TopK = n
+	}
 
-		var req CheckRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
+	if config.SketchEpsilon, err = envFraction("SKETCH_EPSILON", 0.001); err != nil {
+		return config, err
+	}
+	if config.SketchEpsilon <= 0 || config.SketchEpsilon >= 1 {
+		return config, fmt.Errorf("SKETCH_EPSILON must be between 0 and 1, got %v", config.SketchEpsilon)
+	}
+	if config.SketchDelta, err = envFraction("SKETCH_DELTA", 0.01); err != nil {
+		return config, err
+	}
+	if config.SketchDelta <= 0 || config.SketchDelta >= 1 {
+		return config, fmt.Errorf("SKETCH_DELTA must be between 0 and 1, got %v", config.SketchDelta)
+	}
+	if v := os.Getenv("PREFILTER_MAX_REQUESTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return config, fmt.Errorf("invalid PREFILTER_MAX_REQUESTS %q", v)
 		}
-		key := req.Key
-		if key == "" {
-			key = r.RemoteAddr
+		config.PreFilter = &service.PreFilter{
+			WindowSize:  envSeconds("PREFILTER_WINDOW_SECONDS", 1),
+			MaxRequests: n,
 		}
+	}
 
-		decision := svc.CheckRateLimit(key)
-		resp := CheckResponse{Allowed: decision.Allowed, Remaining: decision.Remaining}
-		if decision.Allowed {
-			w.WriteHeader(http.StatusOK)
-		} else {
-			w.WriteHeader(http.StatusTooManyRequests)
-			// For simplicity, no reset_at
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
-	})
+	return config, nil
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	fmt.Printf("Starting server on port %s with %s\n", port, algorithm)
-	http.ListenAndServe(":"+port, nil)
-}
\ No newline at end of file
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"RateLimiterService/pkg/cluster"
+	"RateLimiterService/pkg/metrics"
+	"RateLimiterService/pkg/ratelimiter"
+	"RateLimiterService/pkg/service"
+	"RateLimiterService/pkg/store"
+)
+
+// serviceMetrics holds the collectors updated on the request path
+type serviceMetrics struct {
+	registry  *metrics.Registry
+	decisions *metrics.CounterVec
+	latency   *metrics.HistogramVec
+}
+
+// evictedIdleBuckets span a busy key dropped seconds after use to one left
+// idle for a day, in seconds
+var evictedIdleBuckets = []float64{1, 10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600}
+
+// hookMetrics are fed by callbacks handed to the store and the algorithms,
+// so they exist before the service does
+type hookMetrics struct {
+	evictedIdle *metrics.HistogramVec
+	clockJumps  *metrics.CounterVec
+}
+
+func newHookMetrics() *hookMetrics {
+	return &hookMetrics{
+		evictedIdle: metrics.NewHistogramVec("ratelimiter_store_evicted_idle_seconds",
+			"How long entries had been idle when the store dropped them, by reason.",
+			evictedIdleBuckets, "reason"),
+		clockJumps: metrics.NewCounterVec("ratelimiter_clock_jumps_total",
+			"Clock jumps clamped by the algorithms, by direction.", "direction"),
+	}
+}
+
+// observeEviction is the store's OnEvict callback. Short idle times under the
+// capacity reason mean the key limit is too small for the working set.
+func (m *hookMetrics) observeEviction(e store.Entry, reason store.EvictReason) {
+	m.evictedIdle.WithLabelValues(reason.String()).Observe(time.Since(e.LastAccess).Seconds())
+}
+
+// observeClockJump is the SkewGuard's OnJump callback
+func (m *hookMetrics) observeClockJump(dir ratelimiter.JumpDirection, by time.Duration) {
+	m.clockJumps.WithLabelValues(dir.String()).Inc()
+}
+
+func newServiceMetrics(svc *service.RateLimitService, hooks *hookMetrics) *serviceMetrics {
+	m := &serviceMetrics{
+		registry: metrics.NewRegistry(),
+		decisions: metrics.NewCounterVec("ratelimiter_decisions_total",
+			"Rate limit decisions by policy and outcome.", "policy", "outcome"),
+		latency: metrics.NewHistogramVec("ratelimiter_check_duration_seconds",
+			"Time spent deciding a rate limit check.", metrics.DefaultLatencyBuckets, "policy"),
+	}
+	m.registry.Register(m.decisions, m.latency, hooks.evictedIdle, hooks.clockJumps)
+
+	if _, ok := svc.StoreStats(); ok {
+		m.registry.Register(
+			metrics.NewGaugeFunc("ratelimiter_store_keys",
+				"Number of keys currently held by the store.", func() float64 {
+					stats, _ := svc.StoreStats()
+					return float64(stats.Keys)
+				}),
+			metrics.NewGaugeFunc("ratelimiter_store_bytes",
+				"Estimated memory held by store entries, in bytes.", func() float64 {
+					stats, _ := svc.StoreStats()
+					return float64(stats.Bytes)
+				}),
+			metrics.NewCounterFunc("ratelimiter_store_evictions_total",
+				"Keys evicted to stay within the store's key or memory limit.", func() float64 {
+					stats, _ := svc.StoreStats()
+					return float64(stats.Evictions)
+				}),
+			metrics.NewCounterFunc("ratelimiter_store_expirations_total",
+				"Keys removed by TTL cleanup.", func() float64 {
+					stats, _ := svc.StoreStats()
+					return float64(stats.Expirations)
+				}),
+			metrics.NewCounterFunc("ratelimiter_store_compaction_failures_total",
+				"Failed compactions of the wal store's log.", func() float64 {
+					stats, _ := svc.StoreStats()
+					return float64(stats.CompactionFailures)
+				}),
+		)
+	}
+	if a, ok := svc.Adaptive(); ok {
+		m.registry.Register(
+			metrics.NewGaugeFunc("ratelimiter_adaptive_rate",
+				"Effective token bucket rate set by adaptive limiting, in tokens per second.", func() float64 {
+					return float64(a.Rate())
+				}),
+			metrics.NewCounterFunc("ratelimiter_adaptive_feedback_good_total",
+				"Healthy backend outcomes reported to the feedback endpoint.", func() float64 {
+					return float64(a.Stats().Good)
+				}),
+			metrics.NewCounterFunc("ratelimiter_adaptive_feedback_bad_total",
+				"Backend errors and slow responses reported to the feedback endpoint.", func() float64 {
+					return float64(a.Stats().Bad)
+				}),
+			metrics.NewCounterFunc("ratelimiter_adaptive_decreases_total",
+				"Intervals in which adaptive limiting cut the rate.", func() float64 {
+					return float64(a.Stats().Decreases)
+				}),
+		)
+	}
+	if l, ok := svc.Concurrency(); ok {
+		m.registry.Register(
+			metrics.NewGaugeFunc("ratelimiter_concurrency_limit",
+				"Checks allowed in flight at once, adapted to their latency.", func() float64 {
+					return float64(l.Limit())
+				}),
+			metrics.NewGaugeFunc("ratelimiter_concurrency_inflight",
+				"Checks in flight under the concurrency limit.", func() float64 {
+					return float64(l.Stats().InFlight)
+				}),
+			metrics.NewCounterFunc("ratelimiter_concurrency_rejected_total",
+				"Checks shed with 503 because the concurrency limit was reached.", func() float64 {
+					return float64(l.Stats().Rejected)
+				}),
+		)
+	}
+	if pf, ok := svc.PreFilter(); ok {
+		m.registry.Register(
+			metrics.NewCounterFunc("ratelimiter_prefilter_denied_total",
+				"Checks denied by the sketch pre-filter before reaching the store.", func() float64 {
+					return float64(pf.Denied())
+				}),
+			metrics.NewGaugeFunc("ratelimiter_prefilter_bytes",
+				"Memory held by the pre-filter's sketches, in bytes. Fixed at startup.", func() float64 {
+					return float64(pf.Size())
+				}),
+		)
+	}
+	return m
+}
+
+// registerTopKeys exports the heavy hitters by key. Keys are client input:
+// as label values they reach whoever scrapes metrics, and a flood of
+// spoofed keys churns series, so this is opt-in.
+func (m *serviceMetrics) registerTopKeys(svc *service.RateLimitService) {
+	if _, ok := svc.HeavyHitters(0); !ok {
+		return
+	}
+	top := func(denied bool) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			hh, _ := svc.HeavyHitters(math.MaxInt)
+			keys := hh.Requests
+			if denied {
+				keys = hh.Denied
+			}
+			samples := make([]metrics.Sample, len(keys))
+			for i, c := range keys {
+				samples[i] = metrics.Sample{Labels: []string{c.Key}, Value: float64(c.Count)}
+			}
+			return samples
+		}
+	}
+	m.registry.Register(
+		metrics.NewGaugeVecFunc("ratelimiter_top_key_requests",
+			"Checks for the most checked keys since tracking was last reset, an upper bound.", top(false), "key"),
+		metrics.NewGaugeVecFunc("ratelimiter_top_key_denied",
+			"Denials for the most denied keys since tracking was last reset, an upper bound.", top(true), "key"),
+	)
+}
+
+// registerCluster exports the cluster's internal traffic counters
+func (m *serviceMetrics) registerCluster(c *cluster.Cluster) {
+	m.registry.Register(
+		metrics.NewGaugeFunc("ratelimiter_cluster_members",
+			"Nodes on this node's hash ring, itself included.", func() float64 {
+				return float64(len(c.Members()))
+			}),
+		metrics.NewCounterFunc("ratelimiter_cluster_forwarded_total",
+			"Checks forwarded to the node owning their key.", func() float64 {
+				return float64(c.Stats().Forwarded)
+			}),
+		metrics.NewCounterFunc("ratelimiter_cluster_forward_errors_total",
+			"Forwarded checks that failed and were decided locally.", func() float64 {
+				return float64(c.Stats().ForwardErrors)
+			}),
+		metrics.NewCounterFunc("ratelimiter_cluster_served_total",
+			"Checks decided on behalf of other nodes.", func() float64 {
+				return float64(c.Stats().Served)
+			}),
+		metrics.NewCounterFunc("ratelimiter_cluster_handed_off_total",
+			"Entries sent to their new owner after a membership change.", func() float64 {
+				return float64(c.Stats().HandedOff)
+			}),
+		metrics.NewCounterFunc("ratelimiter_cluster_replicated_total",
+			"Entries copied to the replicas of keys this node owns.", func() float64 {
+				return float64(c.Stats().Replicated)
+			}),
+		metrics.NewCounterFunc("ratelimiter_cluster_replication_errors_total",
+			"Entries that failed to reach a replica.", func() float64 {
+				return float64(c.Stats().ReplicationErrors)
+			}),
+		metrics.NewCounterFunc("ratelimiter_cluster_failed_over_total",
+			"Checks decided by a replica because the key's owner was unreachable.", func() float64 {
+				return float64(c.Stats().FailedOver)
+			}),
+		metrics.NewCounterFunc("ratelimiter_cluster_promoted_total",
+			"Keys this node took over as a replica of their failed owner.", func() float64 {
+				return float64(c.Stats().Promoted)
+			}),
+		metrics.NewCounterFunc("ratelimiter_cluster_deltas_sent_total",
+			"Counter deltas delivered to other nodes.", func() float64 {
+				return float64(c.Stats().DeltasSent)
+			}),
+		metrics.NewCounterFunc("ratelimiter_cluster_deltas_received_total",
+			"Counter deltas merged from other nodes.", func() float64 {
+				return float64(c.Stats().DeltasReceived)
+			}),
+	)
+}
+
+func (m *serviceMetrics) observeCheck(policy string, allowed bool, elapsed time.Duration) {
+	outcome := "denied"
+	if allowed {
+		outcome = "allowed"
+	}
+	m.decisions.WithLabelValues(policy, outcome).Inc()
+	m.latency.WithLabelValues(policy).Observe(elapsed.Seconds())
+}
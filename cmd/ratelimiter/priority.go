@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"RateLimiterService/pkg/ratelimiter"
+)
+
+// loadReservedConfig reads RESERVED_CRITICAL and RESERVED_DEFAULT, the
+// fractions of each key's capacity held back for those priorities. Nothing
+// is reserved unless one is set.
+func loadReservedConfig(algorithm string) (map[ratelimiter.Priority]float64, error) {
+	reserved := make(map[ratelimiter.Priority]float64)
+	var total float64
+	for name, p := range map[string]ratelimiter.Priority{
+		"RESERVED_CRITICAL": ratelimiter.PriorityCritical,
+		"RESERVED_DEFAULT":  ratelimiter.PriorityDefault,
+	} {
+		f, err := envFraction(name, 0)
+		if err != nil {
+			return nil, err
+		}
+		if f > 0 {
+			reserved[p] = f
+			total += f
+		}
+	}
+	if len(reserved) == 0 {
+		return nil, nil
+	}
+	if total > 1 {
+		return nil, fmt.Errorf("RESERVED_CRITICAL and RESERVED_DEFAULT add up to more than the whole capacity")
+	}
+	if algorithm != "tokenbucket" {
+		return nil, fmt.Errorf("RESERVED_* needs ALGORITHM=tokenbucket, not %q", algorithm)
+	}
+	if os.Getenv("STORE_BACKEND") == "redis" {
+		return nil, fmt.Errorf("RESERVED_* is not supported with STORE_BACKEND=redis")
+	}
+	return reserved, nil
+}
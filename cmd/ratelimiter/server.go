@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// serverConfig holds HTTP server timeouts
+type serverConfig struct {
+	Addr            string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration // how long in-flight requests get to drain
+}
+
+func loadServerConfig() serverConfig {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return serverConfig{
+		Addr:            ":" + port,
+		ReadTimeout:     envSeconds("READ_TIMEOUT_SECONDS", 5),
+		WriteTimeout:    envSeconds("WRITE_TIMEOUT_SECONDS", 10),
+		IdleTimeout:     envSeconds("IDLE_TIMEOUT_SECONDS", 60),
+		ShutdownTimeout: envSeconds("SHUTDOWN_TIMEOUT_SECONDS", 15),
+	}
+}
+
+// envSeconds reads a whole number of seconds, falling back to def when unset or invalid
+func envSeconds(name string, def int) time.Duration {
+	sec, _ := strconv.Atoi(os.Getenv(name))
+	if sec <= 0 {
+		sec = def
+	}
+	return time.Duration(sec) * time.Second
+}
+
+// envMillis reads a whole number of milliseconds, falling back to def when unset or invalid
+func envMillis(name string, def int) time.Duration {
+	ms, _ := strconv.Atoi(os.Getenv(name))
+	if ms <= 0 {
+		ms = def
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// serve runs srv until ctx is cancelled, then stops accepting connections and
+// waits up to shutdownTimeout for in-flight requests to finish
+func serve(ctx context.Context, srv *http.Server, shutdownTimeout time.Duration, logger *slog.Logger) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/service"
+)
+
+// snapshotter periodically saves limiter state to disk and restores it at startup
+type snapshotter struct {
+	path     string
+	interval time.Duration
+	clock    clock.Clock
+	svc      *service.RateLimitService
+	logger   *slog.Logger
+	restored *readyFlag
+}
+
+// newSnapshotter returns nil when SNAPSHOT_PATH is unset
+func newSnapshotter(svc *service.RateLimitService, logger *slog.Logger) *snapshotter {
+	path := os.Getenv("SNAPSHOT_PATH")
+	if path == "" {
+		return nil
+	}
+	return &snapshotter{
+		path:     path,
+		interval: envSeconds("SNAPSHOT_INTERVAL_SECONDS", 60),
+		clock:    clock.RealClock{},
+		svc:      svc,
+		logger:   logger,
+		restored: newReadyFlag("snapshot restore in progress"),
+	}
+}
+
+// restore loads the last snapshot and marks the service ready
+func (s *snapshotter) restore() {
+	start := s.clock.Now()
+	stats, err := s.svc.RestoreSnapshot(s.path)
+	if err != nil {
+		// Serving with empty state beats not serving at all
+		s.logger.Error("snapshot restore failed", "path", s.path, "error", err)
+	} else {
+		s.logger.Info("snapshot restored", "path", s.path, "keys", stats.Loaded,
+			"expired", stats.Expired, "downtime", stats.Downtime, "took", s.clock.Since(start))
+	}
+	s.restored.set()
+}
+
+// run saves a snapshot every interval until ctx is cancelled
+func (s *snapshotter) run(ctx context.Context) {
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			s.save()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *snapshotter) save() {
+	start := s.clock.Now()
+	n, err := s.svc.SaveSnapshot(s.path)
+	if err != nil {
+		s.logger.Error("snapshot failed", "path", s.path, "error", err)
+		return
+	}
+	s.logger.Debug("snapshot saved", "path", s.path, "keys", n, "took", s.clock.Since(start))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/resp"
+	"RateLimiterService/pkg/service"
+	"RateLimiterService/pkg/store"
+)
+
+// openStore builds the store backend selected by STORE_BACKEND. onEvict is
+// called for entries the in-process backends drop.
+func openStore(config service.Config, onEvict func(store.Entry, store.EvictReason)) (store.Store, error) {
+	eviction, err := store.ParseEvictionPolicy(os.Getenv("EVICTION_POLICY"))
+	if err != nil {
+		return nil, err
+	}
+	opts := store.Options{
+		TTL:      config.TTL,
+		MaxKeys:  config.MaxKeys,
+		MaxBytes: config.MaxBytes,
+		Eviction: eviction,
+		OnEvict:  onEvict,
+		Clock:    config.Clock,
+	}
+	if hlc, ok := config.Clock.(*clock.HLC); ok {
+		// Version writes so state handed between cluster nodes merges correctly
+		opts.HLC = hlc
+	}
+
+	switch backend := os.Getenv("STORE_BACKEND"); backend {
+	case "", "memory":
+		return store.NewInMemoryStoreWithOptions(opts), nil
+	case "sharded":
+		shards := 64
+		if v := os.Getenv("STORE_SHARDS"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid STORE_SHARDS %q", v)
+			}
+			shards = n
+		}
+		if opts.MaxKeys > 0 && opts.MaxKeys < shards && eviction != store.EvictTTL {
+			return nil, fmt.Errorf("MAX_KEYS %d is less than STORE_SHARDS %d; each shard needs room for a key", opts.MaxKeys, shards)
+		}
+		return store.NewShardedStoreWithOptions(shards, opts), nil
+	case "wal":
+		dir := os.Getenv("WAL_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("WAL_DIR is required for the wal store")
+		}
+		syncPolicy, err := store.ParseSyncPolicy(os.Getenv("WAL_SYNC"))
+		if err != nil {
+			return nil, err
+		}
+		compactBytes, _ := strconv.ParseInt(os.Getenv("WAL_COMPACT_BYTES"), 10, 64)
+		if compactBytes == 0 {
+			compactBytes = 64 << 20
+		}
+		return store.OpenWALStore(store.WALOptions{
+			Dir:          dir,
+			TTL:          config.TTL,
+			MaxKeys:      config.MaxKeys,
+			MaxBytes:     config.MaxBytes,
+			Eviction:     eviction,
+			OnEvict:      onEvict,
+			Clock:        opts.Clock,
+			HLC:          opts.HLC,
+			Sync:         syncPolicy,
+			SyncEvery:    envSeconds("WAL_SYNC_INTERVAL_SECONDS", 1),
+			CompactBytes: compactBytes,
+			CompactEvery: envSeconds("WAL_COMPACT_INTERVAL_SECONDS", 300),
+		})
+	case "redis":
+		addr := os.Getenv("REDIS_ADDR")
+		if addr == "" {
+			addr = "localhost:6379"
+		}
+		db, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+		prefix, ok := os.LookupEnv("REDIS_KEY_PREFIX")
+		if !ok {
+			prefix = "ratelimit:"
+		}
+		failOpen := true
+		if v := os.Getenv("REDIS_FAIL_OPEN"); v != "" {
+			on, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid REDIS_FAIL_OPEN %q", v)
+			}
+			failOpen = on
+		}
+		client := resp.NewClient(addr, resp.Options{Password: os.Getenv("REDIS_PASSWORD"), DB: db})
+		rs := store.NewRedisStore(client, prefix, config.TTL)
+		rs.SetFailOpen(failOpen)
+		return rs, nil
+	default:
+		return nil, fmt.Errorf("invalid STORE_BACKEND %q", backend)
+	}
+}
@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// Decision is one rate limit outcome recorded in the audit trail
+type Decision struct {
+	Key       string
+	Policy    string
+	Priority  string
+	Allowed   bool
+	Remaining int64
+	Latency   time.Duration
+}
+
+// Logger writes sampled decisions as JSON lines.
+//
+// Sampling is by key rather than by request: a key is either always or never
+// audited for a given rate, so the trail for a sampled customer is complete.
+type Logger struct {
+	log       *slog.Logger
+	threshold uint64
+}
+
+// NewLogger records decisions to w for roughly sampleRate of all keys.
+func NewLogger(w io.Writer, sampleRate float64) *Logger {
+	l := &Logger{log: slog.New(slog.NewJSONHandler(w, nil))}
+	switch {
+	case sampleRate >= 1:
+		l.threshold = math.MaxUint64
+	case sampleRate > 0:
+		l.threshold = uint64(sampleRate * math.MaxUint64)
+	}
+	return l
+}
+
+// Sampled reports whether decisions for key are recorded
+func (l *Logger) Sampled(key string) bool {
+	if l.threshold == math.MaxUint64 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return mix64(h.Sum64()) < l.threshold
+}
+
+// Record writes d if its key is sampled
+func (l *Logger) Record(d Decision) {
+	if !l.Sampled(d.Key) {
+		return
+	}
+	outcome := "denied"
+	if d.Allowed {
+		outcome = "allowed"
+	}
+	l.log.LogAttrs(context.Background(), slog.LevelInfo, "decision",
+		slog.String("key", d.Key),
+		slog.String("policy", d.Policy),
+		slog.String("priority", d.Priority),
+		slog.String("outcome", outcome),
+		slog.Int64("remaining", d.Remaining),
+		slog.Duration("latency", d.Latency),
+	)
+}
+
+// mix64 spreads FNV output over the high bits, which are otherwise nearly
+// constant for short keys sharing a prefix
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
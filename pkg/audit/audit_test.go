@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRecordsSampledDecisions(t *testing.T) {
+	var b strings.Builder
+	l := NewLogger(&b, 1)
+	l.Record(Decision{Key: "user1", Policy: "tokenbucket", Priority: "batch", Allowed: false, Remaining: 0})
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(b.String()), &entry); err != nil {
+		t.Fatalf("Invalid JSON line %q: %v", b.String(), err)
+	}
+	if entry["key"] != "user1" || entry["policy"] != "tokenbucket" || entry["priority"] != "batch" || entry["outcome"] != "denied" {
+		t.Errorf("Unexpected entry %v", entry)
+	}
+
+	b.Reset()
+	NewLogger(&b, 0).Record(Decision{Key: "user1"})
+	if b.Len() != 0 {
+		t.Errorf("Expected nothing recorded at rate 0, got %q", b.String())
+	}
+}
+
+func TestLoggerSamplesByKey(t *testing.T) {
+	l := NewLogger(&strings.Builder{}, 0.5)
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if l.Sampled(key) != l.Sampled(key) {
+			t.Fatalf("Sampling not stable for %s", key)
+		}
+		if l.Sampled(key) {
+			sampled++
+		}
+	}
+	if sampled < 400 || sampled > 600 {
+		t.Errorf("Expected about half of keys sampled, got %d", sampled)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	rf, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(rf, "line-%d\n", i) // 7 bytes each, so one line per file
+	}
+	rf.Close()
+
+	for name, want := range map[string]string{
+		path:        "line-4",
+		path + ".1": "line-3",
+		path + ".2": "line-2",
+	} {
+		if got := firstLine(t, name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected at most 2 backups, stat err %v", err)
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	// A non-empty directory where the backup goes makes every rollover fail
+	if err := os.MkdirAll(filepath.Join(path+".1", "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := OpenRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	var reported []error
+	rf.SetOnError(func(err error) { reported = append(reported, err) })
+
+	for i := 0; i < 3; i++ {
+		if _, err := fmt.Fprintf(rf, "line-%d\n", i); err != nil {
+			t.Fatalf("Write %d: %v", i, err)
+		}
+	}
+	if len(reported) != 1 {
+		t.Fatalf("Expected the failure reported once, got %v", reported)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "line-0\nline-1\nline-2\n" {
+		t.Errorf("Expected every line kept at %s, got %q", path, got)
+	}
+
+	// Once the obstacle is gone the next rollover succeeds, and a later
+	// failure is reported again
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(rf, "line-3\n")
+	if got := firstLine(t, path+".1"); got != "line-0" {
+		t.Errorf("%s.1: got %q, want %q", path, got, "line-0")
+	}
+	if err := os.MkdirAll(filepath.Join(path+".1.tmp", "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path + ".1")
+	if err := os.Rename(path+".1.tmp", path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(rf, "line-4\n")
+	if len(reported) != 2 {
+		t.Errorf("Expected a new failure reported after a success, got %v", reported)
+	}
+}
+
+func firstLine(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Scan()
+	return s.Text()
+}
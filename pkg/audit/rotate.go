@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an append-only file that rolls over once it reaches
+// maxBytes. Rolled files are kept as path.1 (newest) through path.N. If a
+// rollover fails, writes carry on at path past maxBytes, and it is retried
+// on the next one.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	onError    func(error)
+	failing    bool // the last rollover failed and has been reported
+}
+
+// OpenRotatingFile opens path for appending. maxBytes <= 0 disables rotation.
+func OpenRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// SetOnError makes rf report a failed rollover to fn, once until one
+// succeeds. Writes go through the Logger, which drops their errors, so this
+// is the only sign of trouble. Call it before the first Write.
+func (rf *RotatingFile) SetOnError(fn func(error)) {
+	rf.onError = fn
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			rf.report(err)
+			// Keep writing to path rather than stop auditing for good
+			if rf.file == nil {
+				if oerr := rf.open(); oerr != nil {
+					return 0, errors.Join(err, oerr)
+				}
+			}
+		} else {
+			rf.failing = false
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) rotate() error {
+	err := rf.file.Close()
+	rf.file = nil
+	if err != nil {
+		return err
+	}
+	if rf.maxBackups <= 0 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return rf.open()
+	}
+	// Drop the oldest, then shift path.N-1 -> path.N, ..., path -> path.1
+	if err := os.Remove(rf.backupName(rf.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := rf.maxBackups - 1; i >= 0; i-- {
+		src := rf.backupName(i)
+		if err := os.Rename(src, rf.backupName(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) report(err error) {
+	if !rf.failing && rf.onError != nil {
+		rf.onError(fmt.Errorf("audit: rotating %s: %w", rf.path, err))
+	}
+	rf.failing = true
+}
+
+func (rf *RotatingFile) backupName(i int) string {
+	if i == 0 {
+		return rf.path
+	}
+	return fmt.Sprintf("%s.%d", rf.path, i)
+}
+
+// Close closes the current file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
@@ -2,9 +2,36 @@ package clock
 
 import "time"
 
-// Clock interface for time operations
+// Clock interface for time operations. Code that waits should do so through
+// the clock rather than the time package, so tests can drive it.
 type Clock interface {
 	Now() time.Time
+	// Since is Now().Sub(t)
+	Since(t time.Time) time.Duration
+	// Until is t.Sub(Now())
+	Until(t time.Time) time.Duration
+	// NewTimer fires once on C after d
+	NewTimer(d time.Duration) Timer
+	// NewTicker fires on C every d. Like time.Ticker, ticks are dropped
+	// rather than queued if the receiver falls behind.
+	NewTicker(d time.Duration) Ticker
+	// After is NewTimer(d).C()
+	After(d time.Duration) <-chan time.Time
+	// Sleep blocks until d has passed on this clock
+	Sleep(d time.Duration)
+}
+
+// Timer is the Clock equivalent of time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is the Clock equivalent of time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
 }
 
 // RealClock implements Clock using the system clock
@@ -12,4 +39,39 @@ type RealClock struct{}
 
 func (c RealClock) Now() time.Time {
 	return time.Now()
-}
\ No newline at end of file
+}
+
+func (c RealClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (c RealClock) Until(t time.Time) time.Duration {
+	return time.Until(t)
+}
+
+func (c RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (c RealClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (c RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (c RealClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
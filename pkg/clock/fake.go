@@ -0,0 +1,202 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a Clock that only moves when told to, for deterministic tests.
+// Timers and tickers fire during Advance and Set, in deadline order, each
+// seeing Now() equal to its deadline. A goroutine that waits on the clock
+// (Sleep, After, a ticker loop) should be given time to register first;
+// BlockUntil does that without a real sleep.
+type FakeClock struct {
+	mu      sync.Mutex
+	changed *sync.Cond // broadcast when pending changes
+	now     time.Time
+	pending []*fakeTimer
+}
+
+// NewFakeClock returns a FakeClock reading start
+func NewFakeClock(start time.Time) *FakeClock {
+	c := &FakeClock{now: start}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *FakeClock) Until(t time.Time) time.Duration {
+	return t.Sub(c.Now())
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// Sleep blocks until another goroutine advances the clock by d
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// BlockUntil waits until at least n timers and tickers are pending, i.e.
+// until the goroutines under test have started waiting on the clock
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.pending) < n {
+		c.changed.Wait()
+	}
+}
+
+// Waiters reports how many timers and tickers are pending
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// Advance moves the clock forward by d, firing everything due on the way
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advanceLocked(c.now.Add(d))
+}
+
+// Set moves the clock to t. Moving forward fires everything due on the way.
+// Moving backward models a stepped wall clock: nothing fires, and pending
+// timers keep their remaining duration, as real timers run on the monotonic
+// clock.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.Before(c.now) {
+		step := t.Sub(c.now)
+		for _, p := range c.pending {
+			p.when = p.when.Add(step)
+		}
+		c.now = t
+		return
+	}
+	c.advanceLocked(t)
+}
+
+func (c *FakeClock) advanceLocked(to time.Time) {
+	for {
+		next := c.nextLocked()
+		if next == nil || next.when.After(to) {
+			break
+		}
+		c.now = next.when
+		next.fire()
+	}
+	c.now = to
+}
+
+// nextLocked returns the pending timer with the earliest deadline
+func (c *FakeClock) nextLocked() *fakeTimer {
+	var next *fakeTimer
+	for _, t := range c.pending {
+		if next == nil || t.when.Before(next.when) {
+			next = t
+		}
+	}
+	return next
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return c.schedule(d, 0)
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{c.schedule(d, d)}
+}
+
+func (c *FakeClock) schedule(d, period time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, ch: make(chan time.Time, 1), period: period}
+	t.startLocked(d)
+	return t
+}
+
+// fakeTimer is a FakeClock timer, or the schedule behind a fakeTicker
+type fakeTimer struct {
+	clock  *FakeClock
+	ch     chan time.Time
+	when   time.Time
+	period time.Duration // 0 for one-shot timers
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+// fire sends the deadline and reschedules a ticker; the clock is locked
+func (t *fakeTimer) fire() {
+	select {
+	case t.ch <- t.when:
+	default: // receiver is behind; drop the tick like the time package
+	}
+	if t.period > 0 {
+		t.when = t.when.Add(t.period)
+	} else {
+		t.stopLocked()
+	}
+}
+
+// startLocked schedules the timer d from now. A timer that is already due
+// fires at once, as with the time package.
+func (t *fakeTimer) startLocked(d time.Duration) {
+	t.when = t.clock.now.Add(d)
+	if d <= 0 && t.period == 0 {
+		t.fire()
+		return
+	}
+	t.clock.pending = append(t.clock.pending, t)
+	t.clock.changed.Broadcast()
+}
+
+func (t *fakeTimer) stopLocked() bool {
+	for i, p := range t.clock.pending {
+		if p == t {
+			t.clock.pending = append(t.clock.pending[:i], t.clock.pending[i+1:]...)
+			t.clock.changed.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.stopLocked()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.stopLocked()
+	t.startLocked(d)
+	return active
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
@@ -0,0 +1,123 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Unix(1700000000, 0)
+
+func received(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeClockTimerFiresAtDeadline(t *testing.T) {
+	c := NewFakeClock(epoch)
+	timer := c.NewTimer(time.Second)
+
+	c.Advance(999 * time.Millisecond)
+	if _, ok := received(timer.C()); ok {
+		t.Fatal("Timer fired early")
+	}
+	c.Advance(time.Millisecond)
+	if at, ok := received(timer.C()); !ok || !at.Equal(epoch.Add(time.Second)) {
+		t.Fatalf("Expected fire at deadline, got %v, %v", at, ok)
+	}
+	if timer.Stop() {
+		t.Error("Stop reported an already fired timer as active")
+	}
+
+	if timer.Reset(time.Second) {
+		t.Error("Reset reported an already fired timer as active")
+	}
+	if !timer.Stop() {
+		t.Error("Expected Stop to report the reset timer as active")
+	}
+	c.Advance(time.Hour)
+	if _, ok := received(timer.C()); ok {
+		t.Error("Stopped timer fired")
+	}
+}
+
+func TestFakeClockTickerDropsMissedTicks(t *testing.T) {
+	c := NewFakeClock(epoch)
+	ticker := c.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	c.Advance(5 * time.Second)
+	at, ok := received(ticker.C())
+	if !ok || !at.Equal(epoch.Add(time.Second)) {
+		t.Fatalf("Expected the first tick to be buffered, got %v, %v", at, ok)
+	}
+	if _, ok := received(ticker.C()); ok {
+		t.Error("Expected later ticks to be dropped while the receiver was behind")
+	}
+	c.Advance(time.Second)
+	if at, _ := received(ticker.C()); !at.Equal(epoch.Add(6 * time.Second)) {
+		t.Errorf("Expected tick at 6s, got %v", at)
+	}
+}
+
+func TestFakeClockSetBackwardKeepsTimerDurations(t *testing.T) {
+	c := NewFakeClock(epoch)
+	timer := c.NewTimer(time.Second)
+	c.Set(epoch.Add(-time.Hour))
+	if !c.Now().Equal(epoch.Add(-time.Hour)) {
+		t.Fatalf("Now = %v", c.Now())
+	}
+	if _, ok := received(timer.C()); ok {
+		t.Fatal("Timer fired on a backward step")
+	}
+	c.Advance(999 * time.Millisecond)
+	if _, ok := received(timer.C()); ok {
+		t.Error("Timer fired before its duration elapsed")
+	}
+	c.Advance(time.Millisecond)
+	if _, ok := received(timer.C()); !ok {
+		t.Error("Expected timer to fire once its duration elapsed")
+	}
+}
+
+func TestFakeClockWakesSleepers(t *testing.T) {
+	c := NewFakeClock(epoch)
+	woke := make(chan time.Time)
+	for _, d := range []time.Duration{3 * time.Second, time.Second} {
+		go func(d time.Duration) {
+			c.Sleep(d)
+			woke <- c.Now()
+		}(d)
+	}
+	c.BlockUntil(2)
+
+	c.Advance(2 * time.Second)
+	if at := <-woke; !at.Equal(epoch.Add(2 * time.Second)) {
+		t.Errorf("Expected the 1s sleeper to wake during the advance, at %v", at)
+	}
+	if n := c.Waiters(); n != 1 {
+		t.Fatalf("Expected one sleeper left, got %d", n)
+	}
+	c.Advance(time.Second)
+	<-woke
+	if n := c.Waiters(); n != 0 {
+		t.Errorf("Expected no waiters, got %d", n)
+	}
+}
+
+func TestFakeClockAfterZeroFiresImmediately(t *testing.T) {
+	c := NewFakeClock(epoch)
+	if at, ok := received(c.After(0)); !ok || !at.Equal(epoch) {
+		t.Errorf("After(0) = %v, %v", at, ok)
+	}
+	if got := c.Until(epoch.Add(time.Minute)); got != time.Minute {
+		t.Errorf("Until = %v", got)
+	}
+	c.Advance(time.Minute)
+	if got := c.Since(epoch); got != time.Minute {
+		t.Errorf("Since = %v", got)
+	}
+}
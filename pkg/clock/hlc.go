@@ -0,0 +1,169 @@
+package clock
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock reading: the highest physical time seen
+// (Unix nanoseconds), a counter ordering events within that nanosecond, and
+// the node that issued it. Timestamps are totally ordered, so any two nodes
+// comparing the same pair reach the same answer.
+type Timestamp struct {
+	Wall    int64  `json:"wall"`
+	Logical uint32 `json:"logical,omitempty"`
+	Node    string `json:"node,omitempty"`
+}
+
+// Compare returns -1, 0 or +1 as t is before, equal to or after u. Node
+// breaks ties between concurrent events with equal clock readings.
+func (t Timestamp) Compare(u Timestamp) int {
+	switch {
+	case t.Wall != u.Wall:
+		return cmp(t.Wall < u.Wall)
+	case t.Logical != u.Logical:
+		return cmp(t.Logical < u.Logical)
+	case t.Node != u.Node:
+		return cmp(t.Node < u.Node)
+	}
+	return 0
+}
+
+func cmp(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
+
+// Before reports whether t orders before u
+func (t Timestamp) Before(u Timestamp) bool {
+	return t.Compare(u) < 0
+}
+
+func (t Timestamp) IsZero() bool {
+	return t == Timestamp{}
+}
+
+// Time returns the physical component
+func (t Timestamp) Time() time.Time {
+	return time.Unix(0, t.Wall)
+}
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d.%d@%s", t.Wall, t.Logical, t.Node)
+}
+
+// ErrClockOffset is returned by HLC.Update for a remote timestamp further
+// ahead of the local physical clock than the configured maximum offset
+var ErrClockOffset = errors.New("clock: remote timestamp exceeds max offset")
+
+// HLC is a hybrid logical clock (Kulkarni et al.). Its timestamps follow
+// physical time where clocks agree, never run backwards, and always order an
+// event after anything the node has already seen from its peers, however
+// skewed their clocks are.
+//
+// HLC implements Clock: Now is the wall component of the latest timestamp,
+// so algorithms driven by it never see time go backwards, even past state
+// received from a node whose clock runs ahead. Timers and sleeps use the
+// physical clock.
+type HLC struct {
+	node      string
+	physical  Clock
+	maxOffset time.Duration
+
+	mu   sync.Mutex
+	last Timestamp
+}
+
+// NewHLC returns an HLC for node reading physical. A positive maxOffset makes
+// Update reject timestamps that far ahead, bounding how far one bad clock
+// can drag the others.
+func NewHLC(node string, physical Clock, maxOffset time.Duration) *HLC {
+	return &HLC{node: node, physical: physical, maxOffset: maxOffset, last: Timestamp{Node: node}}
+}
+
+// Node is the ID stamped on this clock's timestamps
+func (h *HLC) Node() string {
+	return h.node
+}
+
+// Tick returns a new timestamp for a local event, such as a write
+func (h *HLC) Tick() Timestamp {
+	pt := h.physical.Now().UnixNano()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if pt > h.last.Wall {
+		h.last.Wall, h.last.Logical = pt, 0
+	} else {
+		h.last.Logical++
+	}
+	return h.last
+}
+
+// Update folds in a timestamp received from another node and returns a
+// timestamp for the receive event, ordered after both
+func (h *HLC) Update(remote Timestamp) (Timestamp, error) {
+	pt := h.physical.Now().UnixNano()
+	if h.maxOffset > 0 && remote.Wall-pt > int64(h.maxOffset) {
+		return Timestamp{}, fmt.Errorf("%w: %s ahead", ErrClockOffset, time.Duration(remote.Wall-pt))
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch wall := max(h.last.Wall, remote.Wall, pt); {
+	case wall == h.last.Wall && wall == remote.Wall:
+		h.last.Logical = max(h.last.Logical, remote.Logical) + 1
+	case wall == h.last.Wall:
+		h.last.Logical++
+	case wall == remote.Wall:
+		h.last.Wall, h.last.Logical = wall, remote.Logical+1
+	default:
+		h.last.Wall, h.last.Logical = wall, 0
+	}
+	return h.last, nil
+}
+
+// Last returns the latest timestamp issued without advancing the clock
+func (h *HLC) Last() Timestamp {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last
+}
+
+// Now returns the physical time, or the clock's high-water mark if that is
+// later. It doesn't consume a logical tick.
+func (h *HLC) Now() time.Time {
+	pt := h.physical.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if pt.UnixNano() < h.last.Wall {
+		return time.Unix(0, h.last.Wall)
+	}
+	return pt
+}
+
+func (h *HLC) Since(t time.Time) time.Duration {
+	return h.Now().Sub(t)
+}
+
+func (h *HLC) Until(t time.Time) time.Duration {
+	return t.Sub(h.Now())
+}
+
+func (h *HLC) NewTimer(d time.Duration) Timer {
+	return h.physical.NewTimer(d)
+}
+
+func (h *HLC) NewTicker(d time.Duration) Ticker {
+	return h.physical.NewTicker(d)
+}
+
+func (h *HLC) After(d time.Duration) <-chan time.Time {
+	return h.physical.After(d)
+}
+
+func (h *HLC) Sleep(d time.Duration) {
+	h.physical.Sleep(d)
+}
@@ -0,0 +1,68 @@
+package clock
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHLCNeverRunsBackwards(t *testing.T) {
+	phys := NewFakeClock(epoch)
+	h := NewHLC("a", phys, 0)
+
+	first := h.Tick()
+	phys.Set(epoch.Add(-time.Minute))
+	second := h.Tick()
+	if !first.Before(second) {
+		t.Fatalf("Expected %v before %v after the physical clock stepped back", first, second)
+	}
+	if second.Wall != first.Wall || second.Logical != first.Logical+1 {
+		t.Errorf("Expected the logical counter to carry the step, got %v", second)
+	}
+	if now := h.Now(); now.Before(first.Time()) {
+		t.Errorf("Now went backwards: %v < %v", now, first.Time())
+	}
+
+	phys.Set(epoch.Add(time.Second))
+	if third := h.Tick(); third.Wall != epoch.Add(time.Second).UnixNano() || third.Logical != 0 {
+		t.Errorf("Expected to follow physical time again, got %v", third)
+	}
+}
+
+func TestHLCUpdateOrdersAfterRemote(t *testing.T) {
+	slow := NewHLC("slow", NewFakeClock(epoch), 0)
+	fast := NewHLC("fast", NewFakeClock(epoch.Add(5*time.Second)), 0)
+
+	sent := fast.Tick()
+	recv, err := slow.Update(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sent.Before(recv) {
+		t.Fatalf("Expected receive %v after send %v", recv, sent)
+	}
+	if next := slow.Tick(); !recv.Before(next) {
+		t.Errorf("Expected later local events after the receive, got %v", next)
+	}
+	if !slow.Now().Equal(sent.Time()) {
+		t.Errorf("Expected Now to hold at the remote wall time, got %v", slow.Now())
+	}
+}
+
+func TestHLCRejectsTimestampsBeyondMaxOffset(t *testing.T) {
+	h := NewHLC("a", NewFakeClock(epoch), time.Second)
+	if _, err := h.Update(Timestamp{Wall: epoch.Add(time.Hour).UnixNano(), Node: "b"}); !errors.Is(err, ErrClockOffset) {
+		t.Fatalf("Expected ErrClockOffset, got %v", err)
+	}
+	if h.Last().Wall > epoch.UnixNano() {
+		t.Error("Rejected timestamp moved the clock")
+	}
+}
+
+func TestTimestampTieBreaksOnNode(t *testing.T) {
+	a := Timestamp{Wall: 1, Logical: 2, Node: "a"}
+	b := Timestamp{Wall: 1, Logical: 2, Node: "b"}
+	if a.Compare(b) != -1 || b.Compare(a) != 1 || a.Compare(a) != 0 {
+		t.Error("Expected node to break ties deterministically")
+	}
+}
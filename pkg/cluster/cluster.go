@@ -0,0 +1,415 @@
+// Package cluster spreads rate limit state across several service instances.
+// Each key is owned by one node, picked by consistent hashing; checks that
+// arrive elsewhere are forwarded to the owner, so every node enforces the same
+// limit without a shared database.
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/ratelimiter"
+	"RateLimiterService/pkg/service"
+	"RateLimiterService/pkg/store"
+)
+
+// Node is a cluster member
+type Node struct {
+	ID string `json:"id"`
+	// Addr is the base URL of the node's HTTP server, e.g. http://10.0.0.7:8080
+	Addr string `json:"addr"`
+}
+
+// Options configures a Cluster
+type Options struct {
+	Self  Node
+	Peers []Node // initial members besides Self
+	// VirtualNodes is the number of ring points per node (DefaultVirtualNodes if 0)
+	VirtualNodes int
+	// Timeout bounds each internal request (1s if 0)
+	Timeout time.Duration
+	// Secret is sent with internal requests and required on incoming ones.
+	// Internal endpoints can overwrite any key's state and the membership,
+	// so it is mandatory.
+	Secret string
+	Client *http.Client // a default client if nil
+	Logger *slog.Logger // slog.Default() if nil
+	Clock  clock.Clock  // paces discovery; the system clock if nil
+	// Replicas is how many nodes after each key's owner on the ring keep a
+	// copy of its state, to take over with if the owner fails (none if 0)
+	Replicas    int
+	Replication ReplicationMode
+}
+
+// Stats counts the cluster's internal traffic
+type Stats struct {
+	Forwarded     uint64 // checks sent to their owner
+	ForwardErrors uint64 // forwards that failed and were decided locally instead
+	Served        uint64 // checks decided on behalf of other nodes
+	HandedOff     uint64 // entries sent to their new owner after a membership change
+	Received      uint64 // entries received from other nodes and kept
+
+	Replicated        uint64 // entries copied to a key's replicas
+	ReplicationErrors uint64 // entries that failed to reach a replica
+	FailedOver        uint64 // checks decided by a replica because the owner was unreachable
+	Promoted          uint64 // keys this node took over from their owner as a replica
+
+	DeltasSent     uint64 // counter deltas delivered to other nodes
+	DeltasReceived uint64 // counter deltas merged from other nodes
+}
+
+// Cluster routes checks to the node owning each key. It implements the same
+// CheckRateLimit as the service it wraps.
+type Cluster struct {
+	self    Node
+	svc     *service.RateLimitService
+	vnodes  int
+	timeout time.Duration
+	secret  string
+	client  *http.Client
+	logger  *slog.Logger
+	clock   clock.Clock
+
+	replicas int
+	mode     ReplicationMode
+	rep      replicator
+
+	// mu serialises membership changes, which may move state between nodes
+	mu       sync.Mutex
+	ring     atomic.Pointer[Ring]
+	members  atomic.Pointer[map[string]Node]
+	gossip   atomic.Pointer[Gossip]
+	counters atomic.Pointer[CounterSync]
+
+	forwarded     atomic.Uint64
+	forwardErrors atomic.Uint64
+	served        atomic.Uint64
+	handedOff     atomic.Uint64
+	received      atomic.Uint64
+
+	replicated        atomic.Uint64
+	replicationErrors atomic.Uint64
+	failedOver        atomic.Uint64
+	promoted          atomic.Uint64
+
+	deltasSent     atomic.Uint64
+	deltasReceived atomic.Uint64
+}
+
+// ErrNoState is returned by New for a service whose store can't export and
+// import entries, such as Redis, which is shared already
+var ErrNoState = errors.New("cluster: store cannot hand state between nodes")
+
+// ErrNoSecret is returned by New without Options.Secret
+var ErrNoSecret = errors.New("cluster: a shared secret is required")
+
+// New returns a cluster of self and opts.Peers, deciding checks for the keys
+// self owns with svc
+func New(svc *service.RateLimitService, opts Options) (*Cluster, error) {
+	if opts.Self.ID == "" || opts.Self.Addr == "" {
+		return nil, errors.New("cluster: self needs an ID and an address")
+	}
+	if opts.Secret == "" {
+		return nil, ErrNoSecret
+	}
+	if err := svc.ExportState(func(store.Entry) bool { return false }); err != nil {
+		return nil, ErrNoState
+	}
+	c := &Cluster{
+		self:    opts.Self,
+		svc:     svc,
+		vnodes:  opts.VirtualNodes,
+		timeout: opts.Timeout,
+		secret:  opts.Secret,
+		client:  opts.Client,
+		logger:  opts.Logger,
+		clock:   opts.Clock,
+
+		replicas: max(opts.Replicas, 0),
+		mode:     opts.Replication,
+	}
+	if c.timeout <= 0 {
+		c.timeout = time.Second
+	}
+	if c.client == nil {
+		c.client = &http.Client{}
+	}
+	if c.logger == nil {
+		c.logger = slog.Default()
+	}
+	if c.clock == nil {
+		c.clock = clock.RealClock{}
+	}
+	members := map[string]Node{opts.Self.ID: opts.Self}
+	for _, p := range opts.Peers {
+		members[p.ID] = p
+	}
+	c.install(members, newRingOf(c.vnodes, members))
+	return c, nil
+}
+
+// Self returns this node
+func (c *Cluster) Self() Node {
+	return c.self
+}
+
+// Members lists the nodes on the ring ordered by ID
+func (c *Cluster) Members() []Node {
+	members := *c.members.Load()
+	out := make([]Node, 0, len(members))
+	for _, n := range members {
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Owner returns the node owning key
+func (c *Cluster) Owner(key string) Node {
+	return (*c.members.Load())[c.ring.Load().Owner(key)]
+}
+
+// Stats returns the cluster's traffic counters
+func (c *Cluster) Stats() Stats {
+	return Stats{
+		Forwarded:     c.forwarded.Load(),
+		ForwardErrors: c.forwardErrors.Load(),
+		Served:        c.served.Load(),
+		HandedOff:     c.handedOff.Load(),
+		Received:      c.received.Load(),
+
+		Replicated:        c.replicated.Load(),
+		ReplicationErrors: c.replicationErrors.Load(),
+		FailedOver:        c.failedOver.Load(),
+		Promoted:          c.promoted.Load(),
+
+		DeltasSent:     c.deltasSent.Load(),
+		DeltasReceived: c.deltasReceived.Load(),
+	}
+}
+
+// CheckRateLimit decides a check on the node owning key. If the owner can't
+// be reached the check goes to its nearest live replica, and failing that is
+// decided locally, so an outage degrades limits to per-node rather than
+// failing requests. With a CounterSync attached, every check is decided
+// locally.
+func (c *Cluster) CheckRateLimit(key string) service.Decision {
+	return c.CheckRateLimitPriority(key, ratelimiter.PriorityDefault)
+}
+
+// CheckRateLimitPriority is CheckRateLimit for a request of priority p
+func (c *Cluster) CheckRateLimitPriority(key string, p ratelimiter.Priority) service.Decision {
+	if c.counters.Load() != nil {
+		return c.svc.CheckRateLimitPriority(key, p)
+	}
+	owner := c.Owner(key)
+	if owner.ID == "" || owner.ID == c.self.ID {
+		return c.decide(key, p)
+	}
+	c.forwarded.Add(1)
+	d, err := c.forward(owner, key, p)
+	if err == nil {
+		return d
+	}
+	c.forwardErrors.Add(1)
+	if d, ok := c.failover(key, p); ok {
+		c.logger.Warn("forwarding check failed, decided by a replica", "owner", owner.ID, "error", err)
+		return d
+	}
+	c.logger.Warn("forwarding check failed, deciding locally", "owner", owner.ID, "error", err)
+	return c.svc.CheckRateLimitPriority(key, p)
+}
+
+// Reset drops key's state on its owner and replicas. The owner leaves a
+// tombstone stamped after its last write and copies it to the replicas, so
+// copies written before the reset, such as replication still in flight or a
+// replica's during a later handoff, can't bring the key back. If the owner
+// can't be reached the nearest live replica does the same, as its copy is
+// the newest left. With a CounterSync attached, every node counts for itself
+// and only this node's state is reset.
+func (c *Cluster) Reset(key string) bool {
+	if c.counters.Load() != nil {
+		return c.svc.Reset(key)
+	}
+	// A copy here, e.g. from a failover, is stale whatever the holders say
+	local := c.svc.Reset(key)
+	members := *c.members.Load()
+	for _, id := range c.holders(c.ring.Load(), key) {
+		if id == c.self.ID {
+			return c.bury(key) || local
+		}
+		existed, err := c.forwardReset(members[id], key)
+		if err == nil {
+			return existed || local
+		}
+		c.logger.Warn("forwarding reset failed", "node", id, "error", err)
+	}
+	return c.bury(key) || local
+}
+
+// bury resets key here and copies the tombstone to its other holders
+func (c *Cluster) bury(key string) bool {
+	tomb, existed := c.svc.Tombstone(key)
+	members := *c.members.Load()
+	for _, id := range c.holders(c.ring.Load(), key) {
+		if id != c.self.ID {
+			c.copyTo(members[id], []store.Entry{tomb}, false)
+		}
+	}
+	return existed
+}
+
+// SetMembers replaces the peers besides self, handing state for keys that
+// change owner to their new node before routing checks there
+func (c *Cluster) SetMembers(peers []Node) error {
+	return c.change(func(members map[string]Node) {
+		for id := range members {
+			if id != c.self.ID {
+				delete(members, id)
+			}
+		}
+		for _, p := range peers {
+			members[p.ID] = p
+		}
+	})
+}
+
+// Join adds or updates a single node
+func (c *Cluster) Join(n Node) error {
+	return c.change(func(members map[string]Node) {
+		members[n.ID] = n
+	})
+}
+
+// Leave removes a node. Removing self hands all of this node's state to the
+// rest of the cluster, and should be the last thing it does.
+func (c *Cluster) Leave(id string) error {
+	return c.change(func(members map[string]Node) {
+		delete(members, id)
+	})
+}
+
+// change applies edit to a copy of the membership and installs the result
+func (c *Cluster) change(edit func(map[string]Node)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cur := *c.members.Load()
+	members := make(map[string]Node, len(cur))
+	for id, n := range cur {
+		members[id] = n
+	}
+	edit(members)
+
+	old := c.ring.Load()
+	next := newRingOf(c.vnodes, members)
+	if slices.Equal(old.Nodes(), next.Nodes()) {
+		// Addresses may still have changed
+		c.members.Store(&members)
+		return nil
+	}
+	var err error
+	if c.counters.Load() == nil {
+		err = c.handoff(old, next, members)
+	}
+	c.install(members, next)
+	c.logger.Info("cluster membership changed", "members", next.Nodes())
+	return err
+}
+
+func (c *Cluster) install(members map[string]Node, r *Ring) {
+	c.ring.Store(r)
+	c.members.Store(&members)
+}
+
+func newRingOf(vnodes int, members map[string]Node) *Ring {
+	r := NewRing(vnodes)
+	ids := make([]string, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+	r.Set(ids)
+	return r
+}
+
+// handoffBatch is how many entries go in one internal request
+const handoffBatch = 500
+
+// handoff moves state to match next before it is installed. Entries self
+// owned under old and doesn't hold under next go to their new owner and are
+// dropped locally once delivered; checks keep updating them here until then,
+// and the few that land while a batch is in flight are lost with it. The new
+// owner may have started the key afresh already, as checks follow the ring
+// it installed, so it combines what it receives with that state, keeping
+// whichever admits less, instead of the newer write winning. Nodes
+// that hold a key under next but didn't under old are sent a copy by the
+// key's old owner, or by its new one if that's a promoted replica; so is a
+// new owner when the old one stays on as a replica. Entries
+// self held under neither ring were written while their owner was
+// unreachable; they're dropped rather than handed on to overwrite the
+// owner's.
+func (c *Cluster) handoff(old, next *Ring, members map[string]Node) error {
+	moves := make(map[string][]store.Entry)
+	copies := make(map[string][]store.Entry)
+	var stale []string
+	err := c.svc.ExportState(func(e store.Entry) bool {
+		was, will := c.holders(old, e.Key), c.holders(next, e.Key)
+		owned := len(was) > 0 && was[0] == c.self.ID
+		switch {
+		case len(will) == 0:
+			return true
+		case will[0] == c.self.ID && !owned && slices.Contains(was, c.self.ID):
+			c.promoted.Add(1)
+			owned = true
+		case !owned && !slices.Contains(will, c.self.ID):
+			stale = append(stale, e.Key)
+			return true
+		}
+		if !owned {
+			return true
+		}
+		for _, id := range will {
+			switch {
+			case id == c.self.ID:
+			case id == will[0] && !slices.Contains(will, c.self.ID):
+				moves[id] = append(moves[id], e)
+			case id == will[0] || !slices.Contains(was, id):
+				copies[id] = append(copies[id], e)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range stale {
+		c.svc.Reset(key)
+	}
+	for id, entries := range copies {
+		c.copyTo(members[id], entries, true)
+	}
+
+	var errs []error
+	for id, entries := range moves {
+		for len(entries) > 0 {
+			n := min(len(entries), handoffBatch)
+			if err := c.send(members[id], entries[:n], true); err != nil {
+				errs = append(errs, fmt.Errorf("hand off to %s: %w", id, err))
+				break
+			}
+			for _, e := range entries[:n] {
+				c.svc.Reset(e.Key)
+			}
+			c.handedOff.Add(uint64(n))
+			entries = entries[n:]
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,310 @@
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/ratelimiter"
+	"RateLimiterService/pkg/service"
+	"RateLimiterService/pkg/store"
+)
+
+// testSecret is the shared secret of test clusters not given one
+const testSecret = "test-secret"
+
+type testNode struct {
+	*Cluster
+	svc    *service.RateLimitService
+	server *httptest.Server
+}
+
+// newTestCluster starts n nodes on loopback sharing a frozen clock, each
+// configured with all the others. Each allows 5 requests per key, refilling
+// one a second.
+func newTestCluster(t *testing.T, n int, secret string) (*clock.FakeClock, []*testNode) {
+	t.Helper()
+	return startTestNodes(t, n, Options{Secret: secret}, true)
+}
+
+// startTestNodes is newTestCluster with the settings in opts, optionally
+// starting each node alone. Nodes share testSecret unless opts has one.
+func startTestNodes(t *testing.T, n int, opts Options, static bool) (*clock.FakeClock, []*testNode) {
+	t.Helper()
+	fc := clock.NewFakeClock(time.Unix(1700000000, 0))
+	if opts.Secret == "" {
+		opts.Secret = testSecret
+	}
+	nodes := make([]*testNode, n)
+	members := make([]Node, n)
+	for i := range nodes {
+		tn := &testNode{}
+		tn.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tn.Handler().ServeHTTP(w, r)
+		}))
+		t.Cleanup(tn.server.Close)
+		nodes[i] = tn
+		members[i] = Node{ID: fmt.Sprintf("n%d", i), Addr: tn.server.URL}
+	}
+	for i, tn := range nodes {
+		tn.svc = newTestService(t, fc, members[i].ID)
+		var peers []Node
+		if static {
+			peers = members
+		}
+		opts.Self, opts.Peers = members[i], peers
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		c, err := New(tn.svc, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tn.Cluster = c
+	}
+	return fc, nodes
+}
+
+func newTestService(t *testing.T, fc *clock.FakeClock, id string) *service.RateLimitService {
+	hlc := clock.NewHLC(id, fc, 0)
+	config := service.Config{Algorithm: "tokenbucket", Capacity: 5, Rate: 1, TTL: time.Hour, Clock: hlc}
+	svc, err := service.NewRateLimitServiceWithStore(config,
+		store.NewInMemoryStoreWithOptions(store.Options{TTL: time.Hour, Clock: hlc, HLC: hlc}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.Close() })
+	return svc
+}
+
+// keyOwnedBy finds a key whose owner is id
+func keyOwnedBy(t *testing.T, c *Cluster, id string) string {
+	t.Helper()
+	for i := 0; i < 10000; i++ {
+		if key := fmt.Sprintf("key-%d", i); c.Owner(key).ID == id {
+			return key
+		}
+	}
+	t.Fatalf("No key owned by %s", id)
+	return ""
+}
+
+func TestClusterEnforcesOneLimitAcrossNodes(t *testing.T) {
+	_, nodes := newTestCluster(t, 3, "")
+	allowed := 0
+	for i := 0; i < 15; i++ {
+		if nodes[i%3].CheckRateLimit("shared").Allowed {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("Expected 5 allowed across the cluster, got %d", allowed)
+	}
+
+	owner := nodes[0].Owner("shared").ID
+	var forwarded, served uint64
+	for _, n := range nodes {
+		st := n.Stats()
+		forwarded += st.Forwarded
+		served += st.Served
+		if _, ok := n.svc.State("shared"); ok != (n.Self().ID == owner) {
+			t.Errorf("Node %s holding state = %v, owner is %s", n.Self().ID, ok, owner)
+		}
+	}
+	if forwarded != 10 || served != 10 {
+		t.Errorf("Expected 10 forwarded and served, got %d and %d", forwarded, served)
+	}
+}
+
+func TestClusterForwardsPriority(t *testing.T) {
+	fc, nodes := newTestCluster(t, 2, "")
+	// Swap in services that hold 2 of the 5 tokens back for critical
+	// requests
+	for _, n := range nodes {
+		config := service.Config{
+			Algorithm: "tokenbucket", Capacity: 5, Rate: 1, TTL: time.Hour, Clock: fc,
+			Reserved: map[ratelimiter.Priority]float64{ratelimiter.PriorityCritical: 0.4},
+		}
+		svc := service.NewRateLimitService(config)
+		t.Cleanup(func() { svc.Close() })
+		n.svc, n.Cluster.svc = svc, svc
+	}
+
+	key := keyOwnedBy(t, nodes[0].Cluster, "n1")
+	for i := 0; i < 3; i++ {
+		if !nodes[0].CheckRateLimitPriority(key, ratelimiter.PriorityBatch).Allowed {
+			t.Fatalf("Batch request %d denied", i)
+		}
+	}
+	if nodes[0].CheckRateLimitPriority(key, ratelimiter.PriorityBatch).Allowed {
+		t.Fatal("Expected batch denied at the owner's reserve")
+	}
+	d := nodes[0].CheckRateLimitPriority(key, ratelimiter.PriorityCritical)
+	if !d.Allowed || d.Priority != ratelimiter.PriorityCritical {
+		t.Errorf("Expected a forwarded critical request allowed, got %+v", d)
+	}
+	if st := nodes[0].Stats(); st.Forwarded != 5 {
+		t.Errorf("Expected every check forwarded, got %d", st.Forwarded)
+	}
+}
+
+func TestClusterJoinHandsOffState(t *testing.T) {
+	_, nodes := newTestCluster(t, 3, "")
+	joining := nodes[2]
+	// The first two nodes don't know about the third yet
+	for _, n := range nodes[:2] {
+		n.SetMembers([]Node{nodes[0].Self(), nodes[1].Self()})
+	}
+	key := keyOwnedBy(t, joining.Cluster, joining.Self().ID)
+	for i := 0; i < 5; i++ {
+		if !nodes[0].CheckRateLimit(key).Allowed {
+			t.Fatalf("Check %d denied before the join", i)
+		}
+	}
+
+	for _, n := range nodes[:2] {
+		if err := n.Join(joining.Self()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if joining.Stats().Received == 0 {
+		t.Fatal("Expected the joining node to receive state")
+	}
+	for _, n := range nodes {
+		if n.CheckRateLimit(key).Allowed {
+			t.Errorf("Node %s allowed %s after the join; its bucket was drained", n.Self().ID, key)
+		}
+		if _, ok := n.svc.State(key); ok != (n == joining) {
+			t.Errorf("Node %s holding state for %s = %v", n.Self().ID, key, ok)
+		}
+	}
+}
+
+func TestClusterHandoffKeepsChecksTheNewOwnerTookFirst(t *testing.T) {
+	fc, nodes := newTestCluster(t, 3, "")
+	joining := nodes[2]
+	for _, n := range nodes[:2] {
+		n.SetMembers([]Node{nodes[0].Self(), nodes[1].Self()})
+	}
+	key := keyOwnedBy(t, joining.Cluster, joining.Self().ID)
+	// The old owner drains the bucket, then the joining node, which already
+	// routes the key to itself, starts it afresh with later writes
+	for i := 0; i < 5; i++ {
+		nodes[0].CheckRateLimit(key)
+	}
+	fc.Advance(time.Millisecond)
+	for i := 0; i < 2; i++ {
+		joining.CheckRateLimit(key)
+	}
+
+	for _, n := range nodes[:2] {
+		if err := n.Join(joining.Self()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The newer write has more tokens left, but the drained bucket must win
+	for _, n := range nodes {
+		if n.CheckRateLimit(key).Allowed {
+			t.Errorf("Node %s allowed %s after the handoff", n.Self().ID, key)
+		}
+	}
+}
+
+func TestClusterLeaveHandsOffState(t *testing.T) {
+	_, nodes := newTestCluster(t, 3, "")
+	leaving := nodes[2]
+	key := keyOwnedBy(t, leaving.Cluster, leaving.Self().ID)
+	for i := 0; i < 5; i++ {
+		nodes[0].CheckRateLimit(key)
+	}
+
+	if err := leaving.Leave(leaving.Self().ID); err != nil {
+		t.Fatal(err)
+	}
+	leaving.server.Close()
+	for _, n := range nodes[:2] {
+		n.Leave(leaving.Self().ID)
+	}
+	if _, ok := leaving.svc.State(key); ok {
+		t.Error("Expected the leaving node to drop state it handed off")
+	}
+	for _, n := range nodes[:2] {
+		if n.CheckRateLimit(key).Allowed {
+			t.Errorf("Node %s allowed %s after its owner left", n.Self().ID, key)
+		}
+		if n.Stats().ForwardErrors != 0 {
+			t.Errorf("Node %s still forwarding to the node that left", n.Self().ID)
+		}
+	}
+}
+
+func TestClusterUnreachableOwnerDecidesLocally(t *testing.T) {
+	_, nodes := newTestCluster(t, 2, "")
+	// Pick a key that stays with n1 when a third node joins below
+	joined := NewRing(0)
+	joined.Set([]string{"n0", "n1", "x"})
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key-%d", i); nodes[0].Owner(k).ID == "n1" && joined.Owner(k) == "n1" {
+			key = k
+		}
+	}
+	nodes[1].server.Close()
+
+	if !nodes[0].CheckRateLimit(key).Allowed {
+		t.Fatal("Expected a local decision while the owner is down")
+	}
+	if st := nodes[0].Stats(); st.Forwarded != 1 || st.ForwardErrors != 1 {
+		t.Errorf("Unexpected stats %+v", st)
+	}
+
+	// The fallback state isn't the owner's and mustn't be handed back to it,
+	// so the next membership change drops it
+	nodes[0].Join(Node{ID: "x", Addr: nodes[1].server.URL})
+	if _, ok := nodes[0].svc.State(key); ok {
+		t.Error("Expected fallback state to be dropped on the next membership change")
+	}
+}
+
+func TestClusterRequiresSecret(t *testing.T) {
+	_, nodes := newTestCluster(t, 2, "s3cret")
+	key := keyOwnedBy(t, nodes[0].Cluster, nodes[1].Self().ID)
+	nodes[0].CheckRateLimit(key)
+	if st := nodes[0].Stats(); st.ForwardErrors != 0 {
+		t.Fatalf("Forward with the shared secret failed: %+v", st)
+	}
+
+	resp, err := http.Post(nodes[1].server.URL+checkPath, "application/json", strings.NewReader(`{"key":"k"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the secret, got %d", resp.StatusCode)
+	}
+}
+
+func TestClusterNeedsSecret(t *testing.T) {
+	svc := newTestService(t, clock.NewFakeClock(time.Unix(1700000000, 0)), "n0")
+	if _, err := New(svc, Options{Self: Node{ID: "n0", Addr: "http://127.0.0.1:1"}}); err != ErrNoSecret {
+		t.Errorf("Expected ErrNoSecret, got %v", err)
+	}
+}
+
+func TestParsePeers(t *testing.T) {
+	nodes, err := ParsePeers("a=http://10.0.0.1:8080, http://10.0.0.2:8080/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Node{{ID: "a", Addr: "http://10.0.0.1:8080"}, {ID: "http://10.0.0.2:8080", Addr: "http://10.0.0.2:8080"}}
+	if len(nodes) != 2 || nodes[0] != want[0] || nodes[1] != want[1] {
+		t.Errorf("ParsePeers = %+v", nodes)
+	}
+	if _, err := ParsePeers("a=10.0.0.1:8080"); err == nil {
+		t.Error("Expected an address without a scheme to be rejected")
+	}
+}
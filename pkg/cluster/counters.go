@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"RateLimiterService/pkg/ratelimiter"
+)
+
+const countersPath = InternalPath + "counters"
+
+type countersMessage struct {
+	Deltas []ratelimiter.CounterDelta `json:"deltas"`
+}
+
+// CounterSync shares a replicated limiter's counts with every other member.
+// Once attached, the cluster decides checks locally instead of forwarding
+// them, and stops moving state between nodes as membership changes: every
+// node holds every key it has seen, and converges by merging.
+type CounterSync struct {
+	c        *Cluster
+	r        ratelimiter.Replicated
+	interval time.Duration
+}
+
+// NewCounterSync attaches r to c. Counts are sent every interval, and sooner
+// when a key exhausts this node's error budget.
+func NewCounterSync(c *Cluster, r ratelimiter.Replicated, interval time.Duration) *CounterSync {
+	s := &CounterSync{c: c, r: r, interval: interval}
+	r.SetNodes(len(c.Members()))
+	c.counters.Store(s)
+	return s
+}
+
+// Run sends deltas until ctx is done
+func (s *CounterSync) Run(ctx context.Context) {
+	ticker := s.c.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		case <-s.r.Flush():
+		}
+		s.Push(ctx)
+	}
+}
+
+// Push sends this node's deltas to every other member now. Deltas a member
+// misses aren't resent until the key is used again here, so an outage can
+// leave that member undercounting this node's requests for the window.
+func (s *CounterSync) Push(ctx context.Context) {
+	members := s.c.Members()
+	s.r.SetNodes(len(members))
+	deltas := s.r.Deltas()
+	if len(deltas) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.c.timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, n := range members {
+		if n.ID == s.c.self.ID {
+			continue
+		}
+		wg.Add(1)
+		go func(n Node) {
+			defer wg.Done()
+			var ack struct{}
+			if err := s.c.call(ctx, n, countersPath, countersMessage{Deltas: deltas}, &ack); err != nil {
+				s.c.logger.Warn("sending counters failed", "peer", n.ID, "error", err)
+				return
+			}
+			s.c.deltasSent.Add(uint64(len(deltas)))
+		}(n)
+	}
+	wg.Wait()
+}
+
+func (s *CounterSync) serve(w http.ResponseWriter, r *http.Request) {
+	var msg countersMessage
+	if !s.c.decode(w, r, &msg) {
+		return
+	}
+	for _, d := range msg.Deltas {
+		s.r.Merge(d)
+	}
+	s.c.deltasReceived.Add(uint64(len(msg.Deltas)))
+	writeJSON(w, struct{}{})
+}
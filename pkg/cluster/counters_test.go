@@ -0,0 +1,131 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/service"
+	"RateLimiterService/pkg/store"
+)
+
+// newCounterCluster starts n nodes sharing a crdtwindow limit of 30 requests
+// per 10s window, with an error budget of budget
+func newCounterCluster(t *testing.T, n int, budget float64) []*CounterSync {
+	t.Helper()
+	fc := clock.NewFakeClock(time.Unix(1700000000, 0))
+	syncs := make([]*CounterSync, n)
+	members := make([]Node, n)
+	handlers := make([]http.Handler, n)
+	for i := range members {
+		i := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+		members[i] = Node{ID: fmt.Sprintf("n%d", i), Addr: server.URL}
+	}
+	for i := range syncs {
+		config := service.Config{Algorithm: "crdtwindow", WindowSize: 10 * time.Second, MaxRequests: 30,
+			Node: members[i].ID, ErrorBudget: budget, TTL: time.Hour, Clock: fc}
+		svc, err := service.NewRateLimitServiceWithStore(config,
+			store.NewInMemoryStoreWithOptions(store.Options{TTL: time.Hour, Clock: fc}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { svc.Close() })
+		c, err := New(svc, Options{
+			Self:   members[i],
+			Peers:  members,
+			Secret: testSecret,
+			Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			Clock:  fc,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, ok := svc.Replicated()
+		if !ok {
+			t.Fatal("Expected crdtwindow to be replicated")
+		}
+		syncs[i] = NewCounterSync(c, r, time.Second)
+		handlers[i] = c.Handler()
+	}
+	return syncs
+}
+
+func TestCounterSyncConvergesOnSharedLimit(t *testing.T) {
+	syncs := newCounterCluster(t, 3, 0)
+	ctx := context.Background()
+
+	allowed := 0
+	for i := 0; i < 30; i++ {
+		if syncs[i%3].c.CheckRateLimit("shared").Allowed {
+			allowed++
+		}
+	}
+	if allowed != 30 {
+		t.Fatalf("Expected all 30 allowed before the limit, got %d", allowed)
+	}
+	for _, s := range syncs {
+		if st := s.c.Stats(); st.Forwarded != 0 {
+			t.Errorf("Node %s forwarded %d checks, want every check decided locally", s.c.Self().ID, st.Forwarded)
+		}
+	}
+
+	// Before syncing, each node has only seen its own 10
+	if !syncs[0].c.CheckRateLimit("shared").Allowed {
+		t.Fatal("Expected a node that hasn't synced to allow more requests")
+	}
+	for _, s := range syncs {
+		s.Push(ctx)
+	}
+	for _, s := range syncs {
+		if s.c.CheckRateLimit("shared").Allowed {
+			t.Errorf("Node %s allowed a request after syncing 31", s.c.Self().ID)
+		}
+	}
+	var sent, received uint64
+	for _, s := range syncs {
+		st := s.c.Stats()
+		sent += st.DeltasSent
+		received += st.DeltasReceived
+	}
+	if sent == 0 || sent != received {
+		t.Errorf("Expected deltas sent and received to match, got %d and %d", sent, received)
+	}
+}
+
+func TestCounterSyncBoundsOvershootByErrorBudget(t *testing.T) {
+	syncs := newCounterCluster(t, 3, 0.3)
+	ctx := context.Background()
+	for _, s := range syncs {
+		s.Push(ctx) // learns the member count
+	}
+
+	allowed := 0
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 10; i++ {
+			if syncs[i%3].c.CheckRateLimit("hot").Allowed {
+				allowed++
+			}
+		}
+		// An early sync whenever a node has used its share
+		for _, s := range syncs {
+			select {
+			case <-s.r.Flush():
+				s.Push(ctx)
+			default:
+			}
+		}
+	}
+	if allowed < 30 || allowed > 39 {
+		t.Errorf("Expected between 30 and 39 allowed with a 30%% budget, got %d", allowed)
+	}
+}
@@ -0,0 +1,79 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Discovery lists the cluster's current members. The result may include self.
+type Discovery func(ctx context.Context) ([]Node, error)
+
+// ParsePeers parses a comma-separated peer list. Each peer is either a base
+// URL, which doubles as its ID, or id=url.
+func ParsePeers(s string) ([]Node, error) {
+	var nodes []Node
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSuffix(strings.TrimSpace(p), "/")
+		if p == "" {
+			continue
+		}
+		id, addr, ok := strings.Cut(p, "=")
+		if !ok {
+			id, addr = p, p
+		}
+		if id == "" || !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+			return nil, fmt.Errorf("invalid peer %q", p)
+		}
+		nodes = append(nodes, Node{ID: id, Addr: addr})
+	}
+	return nodes, nil
+}
+
+// StaticPeers always returns nodes
+func StaticPeers(nodes []Node) Discovery {
+	return func(context.Context) ([]Node, error) {
+		return nodes, nil
+	}
+}
+
+// DNSPeers resolves host, typically a headless Kubernetes service, and
+// returns a node per address listening on port. Each node's ID is its URL,
+// so self must be configured with the same URL.
+func DNSPeers(host, port string) Discovery {
+	return func(ctx context.Context) ([]Node, error) {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(addrs)
+		nodes := make([]Node, 0, len(addrs))
+		for _, a := range addrs {
+			url := "http://" + net.JoinHostPort(a, port)
+			nodes = append(nodes, Node{ID: url, Addr: url})
+		}
+		return nodes, nil
+	}
+}
+
+// Watch polls d every interval until ctx is done, updating the membership
+// whenever it changes. A failed lookup keeps the current members.
+func (c *Cluster) Watch(ctx context.Context, d Discovery, interval time.Duration) {
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if nodes, err := d(ctx); err != nil {
+			c.logger.Warn("peer discovery failed", "error", err)
+		} else if err := c.SetMembers(nodes); err != nil {
+			c.logger.Warn("rebalancing after membership change", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
@@ -0,0 +1,560 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemberState is a node's status as gossip sees it. States are ordered: at
+// equal incarnations a later state overrides an earlier one.
+type MemberState int
+
+const (
+	StateAlive MemberState = iota
+	// StateSuspect means a probe went unanswered, directly and through other
+	// members. The node stays on the ring until it's declared dead, and can
+	// clear the suspicion by gossiping a higher incarnation.
+	StateSuspect
+	StateDead
+	// StateLeft is a node that shut down and said so
+	StateLeft
+)
+
+var memberStateNames = [...]string{"alive", "suspect", "dead", "left"}
+
+func (s MemberState) String() string {
+	if s < 0 || int(s) >= len(memberStateNames) {
+		return fmt.Sprintf("MemberState(%d)", int(s))
+	}
+	return memberStateNames[s]
+}
+
+func (s MemberState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *MemberState) UnmarshalText(b []byte) error {
+	for i, name := range memberStateNames {
+		if string(b) == name {
+			*s = MemberState(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown member state %q", b)
+}
+
+// onRing reports whether a node in this state owns keys
+func (s MemberState) onRing() bool {
+	return s == StateAlive || s == StateSuspect
+}
+
+// Member is what gossip knows about a node
+type Member struct {
+	Node
+	State MemberState `json:"state"`
+	// Incarnation orders claims about the node; only the node itself raises it
+	Incarnation uint64    `json:"incarnation"`
+	Since       time.Time `json:"since"` // when this node last saw the state change
+}
+
+// GossipOptions tunes the membership protocol. Zero values take the defaults.
+type GossipOptions struct {
+	// Seeds are addresses of nodes to join through. Any live member will do.
+	Seeds []string
+	// Interval is the protocol period: each node probes one member per
+	// interval (1s)
+	Interval time.Duration
+	// PingTimeout is how long to wait for a direct ack (Interval/4)
+	PingTimeout time.Duration
+	// IndirectProbes is how many members are asked to probe a node that
+	// missed a direct ping (3)
+	IndirectProbes int
+	// SuspicionTimeout is how long a suspect has to refute before it is
+	// declared dead (5 × Interval)
+	SuspicionTimeout time.Duration
+	// SyncInterval is how often the full member list is exchanged with a
+	// random member, repairing anything gossip missed (10 × Interval)
+	SyncInterval time.Duration
+	// DeadRetention is how long dead and departed members are remembered, so
+	// stale rumours can't bring them back (10 × SuspicionTimeout)
+	DeadRetention time.Duration
+	// Retransmit scales how many messages each update rides on: Retransmit ×
+	// log10(members+1), rounded up (4)
+	Retransmit int
+}
+
+// maxPiggyback caps the updates carried by one message
+const maxPiggyback = 8
+
+// Gossip maintains the cluster's membership with SWIM (Das et al.): each
+// period a node pings one member; if it doesn't answer, a few others are
+// asked to ping it, and only if none succeed is it suspected. Suspicion and
+// recovery spread by piggybacking on pings, and a suspect that is still
+// running refutes by raising its incarnation. Alive and suspect members own
+// keys; the ring follows as states change.
+type Gossip struct {
+	c    *Cluster
+	opts GossipOptions
+
+	mu      sync.Mutex
+	members map[string]*Member // self included
+	queue   map[string]*broadcast
+	probes  []string // shuffled probe order
+	leaving bool
+
+	ringChanged chan struct{}
+	mux         http.Handler
+}
+
+type broadcast struct {
+	update memberUpdate
+	sent   int
+}
+
+// memberUpdate is one claim about a node, as carried in messages
+type memberUpdate struct {
+	ID          string      `json:"id"`
+	Addr        string      `json:"addr"`
+	State       MemberState `json:"state"`
+	Incarnation uint64      `json:"incarnation"`
+}
+
+// NewGossip attaches gossip membership to c. Membership is static until Run.
+func NewGossip(c *Cluster, opts GossipOptions) *Gossip {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.PingTimeout <= 0 {
+		opts.PingTimeout = opts.Interval / 4
+	}
+	if opts.IndirectProbes <= 0 {
+		opts.IndirectProbes = 3
+	}
+	if opts.SuspicionTimeout <= 0 {
+		opts.SuspicionTimeout = 5 * opts.Interval
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = 10 * opts.Interval
+	}
+	if opts.DeadRetention <= 0 {
+		opts.DeadRetention = 10 * opts.SuspicionTimeout
+	}
+	if opts.Retransmit <= 0 {
+		opts.Retransmit = 4
+	}
+	g := &Gossip{
+		c:           c,
+		opts:        opts,
+		members:     make(map[string]*Member),
+		queue:       make(map[string]*broadcast),
+		ringChanged: make(chan struct{}, 1),
+	}
+	g.members[c.self.ID] = &Member{Node: c.self, State: StateAlive, Since: c.clock.Now()}
+	g.mux = g.handler()
+	c.gossip.Store(g)
+	return g
+}
+
+// Members lists every member gossip knows about, self and recently dead or
+// departed nodes included, ordered by ID
+func (g *Gossip) Members() []Member {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Run joins through the seeds and runs the protocol until ctx is done
+func (g *Gossip) Run(ctx context.Context) {
+	go g.followRing(ctx)
+	g.sync(ctx)
+
+	ticker := g.c.clock.NewTicker(g.opts.Interval)
+	defer ticker.Stop()
+	syncEvery := max(int(g.opts.SyncInterval/g.opts.Interval), 1)
+	for tick := 1; ; tick++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		g.probe(ctx)
+		g.expire()
+		if tick%syncEvery == 0 || g.alone() {
+			g.sync(ctx)
+		}
+	}
+}
+
+// Leave announces that this node is shutting down, telling a few members
+// directly rather than waiting for the next probe. Call it once this node's
+// state has been handed off, after Run has returned.
+func (g *Gossip) Leave(ctx context.Context) {
+	g.mu.Lock()
+	g.leaving = true
+	self := g.members[g.c.self.ID]
+	self.State, self.Since = StateLeft, g.c.clock.Now()
+	g.enqueueLocked(self)
+	targets := g.pickLocked(g.opts.IndirectProbes, "")
+	g.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, n := range targets {
+		wg.Add(1)
+		go func(n Node) {
+			defer wg.Done()
+			g.pushPull(ctx, n)
+		}(n)
+	}
+	wg.Wait()
+}
+
+// followRing keeps the cluster's ring in step with the alive and suspect
+// members. It runs apart from the protocol because a ring change may hand
+// state to other nodes.
+func (g *Gossip) followRing(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-g.ringChanged:
+		}
+		g.mu.Lock()
+		var nodes []Node
+		for _, m := range g.members {
+			if m.State.onRing() {
+				nodes = append(nodes, m.Node)
+			}
+		}
+		g.mu.Unlock()
+		if err := g.c.SetMembers(nodes); err != nil {
+			g.c.logger.Warn("rebalancing after membership change", "error", err)
+		}
+	}
+}
+
+// probe pings the next member in the probe order, falling back to indirect
+// pings through others, and suspects it if no ack comes back
+func (g *Gossip) probe(ctx context.Context) {
+	target, ok := g.nextProbe()
+	if !ok {
+		return
+	}
+	if g.ping(ctx, target) {
+		return
+	}
+
+	g.mu.Lock()
+	helpers := g.pickLocked(g.opts.IndirectProbes, target.ID)
+	g.mu.Unlock()
+	acks := make(chan bool, len(helpers))
+	for _, h := range helpers {
+		go func(h Node) {
+			acks <- g.pingVia(ctx, h, target)
+		}(h)
+	}
+	for range helpers {
+		if <-acks {
+			return
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if m, ok := g.members[target.ID]; ok && m.State == StateAlive {
+		g.c.logger.Info("member suspected", "id", target.ID)
+		g.applyLocked(memberUpdate{ID: m.ID, Addr: m.Addr, State: StateSuspect, Incarnation: m.Incarnation})
+	}
+}
+
+// nextProbe walks the members in a random order, reshuffling each round, so
+// every member is probed within one round of the protocol
+func (g *Gossip) nextProbe() (Node, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for attempts := 0; attempts < 2; attempts++ {
+		for len(g.probes) > 0 {
+			id := g.probes[0]
+			g.probes = g.probes[1:]
+			if m, ok := g.members[id]; ok && m.State.onRing() {
+				return m.Node, true
+			}
+		}
+		for id, m := range g.members {
+			if id != g.c.self.ID && m.State.onRing() {
+				g.probes = append(g.probes, id)
+			}
+		}
+		rand.Shuffle(len(g.probes), func(i, j int) { g.probes[i], g.probes[j] = g.probes[j], g.probes[i] })
+	}
+	return Node{}, false
+}
+
+// pickLocked returns up to n random members on the ring other than self
+// and exclude
+func (g *Gossip) pickLocked(n int, exclude string) []Node {
+	var candidates []Node
+	for id, m := range g.members {
+		if id != g.c.self.ID && id != exclude && m.State.onRing() {
+			candidates = append(candidates, m.Node)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	return candidates[:min(n, len(candidates))]
+}
+
+// expire declares suspects dead once their time to refute has run out, and
+// forgets dead and departed members after DeadRetention
+func (g *Gossip) expire() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.c.clock.Now()
+	for id, m := range g.members {
+		switch age := now.Sub(m.Since); {
+		case m.State == StateSuspect && age > g.opts.SuspicionTimeout:
+			g.c.logger.Warn("member declared dead", "id", id)
+			g.applyLocked(memberUpdate{ID: id, Addr: m.Addr, State: StateDead, Incarnation: m.Incarnation})
+		case !m.State.onRing() && id != g.c.self.ID && age > g.opts.DeadRetention:
+			delete(g.members, id)
+		}
+	}
+}
+
+// alone reports whether no other live member is known
+func (g *Gossip) alone() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.pickLocked(1, "")) == 0
+}
+
+// sync exchanges the full member list with a random member, or with the
+// seeds if no other member is known. A node that starts before its seeds,
+// or outlives every peer, retries them each period until one answers.
+func (g *Gossip) sync(ctx context.Context) {
+	g.mu.Lock()
+	targets := g.pickLocked(1, "")
+	g.mu.Unlock()
+	level := slog.LevelWarn
+	if len(targets) == 0 {
+		level = slog.LevelDebug
+		for _, addr := range g.opts.Seeds {
+			if addr != g.c.self.Addr {
+				targets = append(targets, Node{ID: addr, Addr: addr})
+			}
+		}
+	}
+	for _, n := range targets {
+		if err := g.pushPull(ctx, n); err != nil {
+			g.c.logger.Log(ctx, level, "gossip sync failed", "peer", n.Addr, "error", err)
+		}
+	}
+}
+
+// applyLocked folds in a claim about a member and reports whether it was
+// news. Claims about self that aren't "alive" are refuted.
+func (g *Gossip) applyLocked(u memberUpdate) bool {
+	now := g.c.clock.Now()
+	if u.ID == g.c.self.ID {
+		self := g.members[u.ID]
+		if g.leaving || u.Incarnation < self.Incarnation || u.State == StateAlive && u.Incarnation == self.Incarnation {
+			return false
+		}
+		// Someone thinks we're suspect or dead, or remembers a previous run
+		// of this node with a higher incarnation: outbid it
+		self.Incarnation = u.Incarnation + 1
+		g.enqueueLocked(self)
+		return false
+	}
+
+	m, known := g.members[u.ID]
+	if known && !supersedes(u, m) {
+		return false
+	}
+	if !known {
+		m = &Member{}
+		g.members[u.ID] = m
+	}
+	ringChange := !known && u.State.onRing() ||
+		known && (m.State.onRing() != u.State.onRing() || m.Addr != u.Addr)
+	prev := m.State
+	m.Node = Node{ID: u.ID, Addr: u.Addr}
+	m.State, m.Incarnation = u.State, u.Incarnation
+	if !known || prev != u.State {
+		m.Since = now
+	}
+	g.enqueueLocked(m)
+	if ringChange {
+		select {
+		case g.ringChanged <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// supersedes reports whether u overrides what is known about m: a higher
+// incarnation always does, and at the same incarnation a later state does
+func supersedes(u memberUpdate, m *Member) bool {
+	if u.Incarnation != m.Incarnation {
+		return u.Incarnation > m.Incarnation
+	}
+	return u.State > m.State
+}
+
+func (g *Gossip) enqueueLocked(m *Member) {
+	g.queue[m.ID] = &broadcast{update: memberUpdate{ID: m.ID, Addr: m.Addr, State: m.State, Incarnation: m.Incarnation}}
+}
+
+// piggyback takes the updates sent least often so far for the next message
+func (g *Gossip) piggyback() []memberUpdate {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.queue) == 0 {
+		return nil
+	}
+	pending := make([]*broadcast, 0, len(g.queue))
+	for _, b := range g.queue {
+		pending = append(pending, b)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].sent < pending[j].sent })
+	limit := g.opts.Retransmit * int(math.Ceil(math.Log10(float64(len(g.members)+1))))
+	out := make([]memberUpdate, 0, min(len(pending), maxPiggyback))
+	for _, b := range pending[:min(len(pending), maxPiggyback)] {
+		out = append(out, b.update)
+		if b.sent++; b.sent >= limit {
+			delete(g.queue, b.update.ID)
+		}
+	}
+	return out
+}
+
+func (g *Gossip) applyAll(updates []memberUpdate) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, u := range updates {
+		g.applyLocked(u)
+	}
+}
+
+func (g *Gossip) snapshot() []memberUpdate {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make([]memberUpdate, 0, len(g.members))
+	for _, m := range g.members {
+		out = append(out, memberUpdate{ID: m.ID, Addr: m.Addr, State: m.State, Incarnation: m.Incarnation})
+	}
+	return out
+}
+
+const (
+	gossipPath  = InternalPath + "gossip/"
+	pingPath    = gossipPath + "ping"
+	pingReqPath = gossipPath + "ping-req"
+	syncPath    = gossipPath + "sync"
+)
+
+type pingMessage struct {
+	// Target is the ID the sender expects to reach, so a new node that took
+	// over a dead one's address doesn't ack on its behalf
+	Target  string         `json:"target"`
+	Updates []memberUpdate `json:"updates,omitempty"`
+}
+
+type ackMessage struct {
+	Updates []memberUpdate `json:"updates,omitempty"`
+}
+
+type pingReqMessage struct {
+	Target  Node           `json:"target"`
+	Updates []memberUpdate `json:"updates,omitempty"`
+}
+
+type pingReqReply struct {
+	Ack     bool           `json:"ack"`
+	Updates []memberUpdate `json:"updates,omitempty"`
+}
+
+type syncMessage struct {
+	Members []memberUpdate `json:"members"`
+}
+
+// ping sends n a ping with piggybacked updates and reports whether it acked
+func (g *Gossip) ping(ctx context.Context, n Node) bool {
+	ctx, cancel := context.WithTimeout(ctx, g.opts.PingTimeout)
+	defer cancel()
+	var ack ackMessage
+	if err := g.c.call(ctx, n, pingPath, pingMessage{Target: n.ID, Updates: g.piggyback()}, &ack); err != nil {
+		return false
+	}
+	g.applyAll(ack.Updates)
+	return true
+}
+
+// pingVia asks helper to ping target for us
+func (g *Gossip) pingVia(ctx context.Context, helper, target Node) bool {
+	ctx, cancel := context.WithTimeout(ctx, 2*g.opts.PingTimeout)
+	defer cancel()
+	var reply pingReqReply
+	if err := g.c.call(ctx, helper, pingReqPath, pingReqMessage{Target: target, Updates: g.piggyback()}, &reply); err != nil {
+		return false
+	}
+	g.applyAll(reply.Updates)
+	return reply.Ack
+}
+
+// pushPull swaps full member lists with n
+func (g *Gossip) pushPull(ctx context.Context, n Node) error {
+	ctx, cancel := context.WithTimeout(ctx, g.c.timeout)
+	defer cancel()
+	var reply syncMessage
+	if err := g.c.call(ctx, n, syncPath, syncMessage{Members: g.snapshot()}, &reply); err != nil {
+		return err
+	}
+	g.applyAll(reply.Members)
+	return nil
+}
+
+func (g *Gossip) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pingPath, func(w http.ResponseWriter, r *http.Request) {
+		var msg pingMessage
+		if !g.c.decode(w, r, &msg) {
+			return
+		}
+		g.applyAll(msg.Updates)
+		if msg.Target != g.c.self.ID {
+			http.Error(w, "Wrong node", http.StatusConflict)
+			return
+		}
+		writeJSON(w, ackMessage{Updates: g.piggyback()})
+	})
+	mux.HandleFunc(pingReqPath, func(w http.ResponseWriter, r *http.Request) {
+		var msg pingReqMessage
+		if !g.c.decode(w, r, &msg) {
+			return
+		}
+		g.applyAll(msg.Updates)
+		ack := g.ping(r.Context(), msg.Target)
+		writeJSON(w, pingReqReply{Ack: ack, Updates: g.piggyback()})
+	})
+	mux.HandleFunc(syncPath, func(w http.ResponseWriter, r *http.Request) {
+		var msg syncMessage
+		if !g.c.decode(w, r, &msg) {
+			return
+		}
+		g.applyAll(msg.Members)
+		writeJSON(w, syncMessage{Members: g.snapshot()})
+	})
+	return mux
+}
@@ -0,0 +1,281 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+// testGossip runs the protocol on fake time, a period per round. Pings are
+// real requests on loopback and their timeout is real time.
+var testGossip = GossipOptions{
+	Interval:         time.Second,
+	PingTimeout:      time.Second,
+	SuspicionTimeout: 10 * time.Second,
+	SyncInterval:     5 * time.Second,
+	DeadRetention:    time.Hour,
+}
+
+// maxRounds bounds how long the tests wait for the cluster to settle
+const maxRounds = 100
+
+// gossipClock is the nodes' FakeClock, except that the protocol period is a
+// tick the test hands over. A node takes it only once done with the last.
+type gossipClock struct {
+	*clock.FakeClock
+	ticks chan time.Time
+}
+
+func (c gossipClock) NewTicker(time.Duration) clock.Ticker {
+	return handTicker(c.ticks)
+}
+
+type handTicker chan time.Time
+
+func (t handTicker) C() <-chan time.Time { return t }
+func (t handTicker) Stop()               {}
+
+type gossipNode struct {
+	*testNode
+	gossip *Gossip
+	ticks  chan time.Time
+	stop   context.CancelFunc
+	done   chan struct{}
+}
+
+// crash stops the node without announcing it
+func (n *gossipNode) crash() {
+	n.stop()
+	<-n.done
+	n.server.Close()
+}
+
+// newGossipCluster starts n nodes that know only the first node's address
+func newGossipCluster(t *testing.T, n int, opts GossipOptions) (*clock.FakeClock, []*gossipNode) {
+	t.Helper()
+	fc := clock.NewFakeClock(time.Unix(1700000000, 0))
+	nodes := make([]*gossipNode, n)
+	for i := range nodes {
+		nodes[i] = startGossipNode(t, fc, fmt.Sprintf("n%d", i), opts)
+		opts.Seeds = []string{nodes[0].server.URL}
+	}
+	return fc, nodes
+}
+
+// startGossipNode starts a node alone on loopback and runs gossip on it
+func startGossipNode(t *testing.T, fc *clock.FakeClock, id string, opts GossipOptions) *gossipNode {
+	t.Helper()
+	tn := &testNode{}
+	tn.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tn.Handler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(tn.server.Close)
+	tn.svc = newTestService(t, fc, id)
+	ticks := make(chan time.Time)
+	c, err := New(tn.svc, Options{
+		Self:   Node{ID: id, Addr: tn.server.URL},
+		Secret: testSecret,
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Clock:  gossipClock{FakeClock: fc, ticks: ticks},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tn.Cluster = c
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gn := &gossipNode{testNode: tn, gossip: NewGossip(c, opts), ticks: ticks, stop: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(gn.done)
+		gn.gossip.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-gn.done
+	})
+	return gn
+}
+
+// round advances the clock a period and runs it on every node still
+// running. Handing a node its tick waits for it to finish the last period.
+func round(fc *clock.FakeClock, nodes []*gossipNode) {
+	fc.Advance(testGossip.Interval)
+	for _, n := range nodes {
+		select {
+		case n.ticks <- fc.Now():
+		case <-n.done:
+		}
+	}
+}
+
+// until runs rounds until cond holds
+func until(t *testing.T, fc *clock.FakeClock, nodes []*gossipNode, what string, cond func() bool) {
+	t.Helper()
+	for i := 0; !cond(); i++ {
+		if i == maxRounds {
+			t.Fatalf("Still waiting after %d rounds until %s", maxRounds, what)
+		}
+		round(fc, nodes)
+	}
+}
+
+// sees reports whether n's ring holds exactly ids
+func sees(n *gossipNode, ids ...string) bool {
+	members := n.Members()
+	if len(members) != len(ids) {
+		return false
+	}
+	for i, m := range members {
+		if m.ID != ids[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stateOf(n *gossipNode, id string) (Member, bool) {
+	for _, m := range n.gossip.Members() {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return Member{}, false
+}
+
+func TestGossipJoinsThroughSeed(t *testing.T) {
+	fc, nodes := newGossipCluster(t, 4, testGossip)
+	for _, n := range nodes {
+		until(t, fc, nodes, fmt.Sprintf("%s sees every node", n.Self().ID), func() bool {
+			return sees(n, "n0", "n1", "n2", "n3")
+		})
+	}
+	for _, n := range nodes {
+		for _, m := range n.gossip.Members() {
+			if m.State != StateAlive {
+				t.Errorf("%s sees %s as %s", n.Self().ID, m.ID, m.State)
+			}
+		}
+	}
+
+	// With the rings agreeing, the cluster enforces one limit
+	allowed := 0
+	for i := 0; i < 12; i++ {
+		if nodes[i%4].CheckRateLimit("shared").Allowed {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("Expected 5 allowed across the cluster, got %d", allowed)
+	}
+}
+
+func TestGossipDetectsFailure(t *testing.T) {
+	fc, nodes := newGossipCluster(t, 4, testGossip)
+	for _, n := range nodes {
+		until(t, fc, nodes, "the cluster converges", func() bool { return sees(n, "n0", "n1", "n2", "n3") })
+	}
+
+	nodes[3].crash()
+	start := fc.Now()
+	for _, n := range nodes[:3] {
+		until(t, fc, nodes, fmt.Sprintf("%s drops n3 from its ring", n.Self().ID), func() bool {
+			return sees(n, "n0", "n1", "n2")
+		})
+		if m, _ := stateOf(n, "n3"); m.State != StateDead {
+			t.Errorf("%s sees n3 as %s, want dead", n.Self().ID, m.State)
+		}
+	}
+	// A suspect has its full time to refute before it's declared dead
+	if d := fc.Since(start); d <= testGossip.SuspicionTimeout {
+		t.Errorf("n3 dropped after %v, before the suspicion timeout of %v", d, testGossip.SuspicionTimeout)
+	}
+}
+
+func TestGossipLeaveIsAnnounced(t *testing.T) {
+	opts := testGossip
+	// Long enough that only the announcement can explain a quick removal
+	opts.SuspicionTimeout = time.Hour
+	fc, nodes := newGossipCluster(t, 3, opts)
+	for _, n := range nodes {
+		until(t, fc, nodes, "the cluster converges", func() bool { return sees(n, "n0", "n1", "n2") })
+	}
+
+	leaving := nodes[2]
+	leaving.stop()
+	<-leaving.done
+	leaving.Leave(leaving.Self().ID)
+	leaving.gossip.Leave(context.Background())
+	for _, n := range nodes[:2] {
+		until(t, fc, nodes, fmt.Sprintf("%s drops n2 from its ring", n.Self().ID), func() bool {
+			return sees(n, "n0", "n1")
+		})
+		if m, _ := stateOf(n, "n2"); m.State != StateLeft {
+			t.Errorf("%s sees n2 as %s, want left", n.Self().ID, m.State)
+		}
+	}
+}
+
+func TestGossipRefutesSuspicion(t *testing.T) {
+	fc, nodes := newGossipCluster(t, 3, testGossip)
+	for _, n := range nodes {
+		until(t, fc, nodes, "the cluster converges", func() bool { return sees(n, "n0", "n1", "n2") })
+	}
+
+	// A false suspicion, as if a probe of n1 had timed out
+	n0 := nodes[0].gossip
+	n0.mu.Lock()
+	m := n0.members["n1"]
+	n0.applyLocked(memberUpdate{ID: "n1", Addr: m.Addr, State: StateSuspect, Incarnation: m.Incarnation})
+	n0.mu.Unlock()
+
+	for _, n := range nodes {
+		until(t, fc, nodes, fmt.Sprintf("%s sees n1 alive again", n.Self().ID), func() bool {
+			m, _ := stateOf(n, "n1")
+			return m.State == StateAlive && m.Incarnation > 0
+		})
+	}
+	if !sees(nodes[0], "n0", "n1", "n2") {
+		t.Error("Expected n1 to stay on n0's ring")
+	}
+}
+
+func TestGossipRestartedNodeRejoins(t *testing.T) {
+	fc, nodes := newGossipCluster(t, 3, testGossip)
+	for _, n := range nodes {
+		until(t, fc, nodes, "the cluster converges", func() bool { return sees(n, "n0", "n1", "n2") })
+	}
+	nodes[2].crash()
+	for _, n := range nodes[:2] {
+		until(t, fc, nodes, "n2 is declared dead", func() bool { return sees(n, "n0", "n1") })
+	}
+
+	// Same ID, new address, incarnation back to zero: it has to outbid its
+	// own death to be let back in
+	opts := testGossip
+	opts.Seeds = []string{nodes[0].server.URL}
+	restarted := startGossipNode(t, fc, "n2", opts)
+	nodes = append(nodes[:2], restarted)
+
+	for _, n := range nodes {
+		until(t, fc, nodes, fmt.Sprintf("%s sees n2 back", n.Self().ID), func() bool {
+			return sees(n, "n0", "n1", "n2") && n.Owner(keyOwnedBy(t, restarted.Cluster, "n2")).Addr == restarted.server.URL
+		})
+	}
+}
+
+func TestMemberStateText(t *testing.T) {
+	for _, s := range []MemberState{StateAlive, StateSuspect, StateDead, StateLeft} {
+		b, _ := s.MarshalText()
+		var got MemberState
+		if err := got.UnmarshalText(b); err != nil || got != s {
+			t.Errorf("Round trip of %s gave %s, %v", s, got, err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package cluster
+
+import (
+	"fmt"
+	"sync"
+
+	"RateLimiterService/pkg/ratelimiter"
+	"RateLimiterService/pkg/service"
+	"RateLimiterService/pkg/store"
+)
+
+// ReplicationMode says when an owner's writes reach a key's replicas
+type ReplicationMode int
+
+const (
+	// ReplicateAsync queues writes and sends them in the background. Checks
+	// don't wait for replicas, and a crash loses whatever hadn't been sent.
+	ReplicateAsync ReplicationMode = iota
+	// ReplicateSync sends each write to the replicas before the check
+	// returns, so a check costs a round trip to the slowest of them
+	ReplicateSync
+)
+
+func (m ReplicationMode) String() string {
+	if m == ReplicateSync {
+		return "sync"
+	}
+	return "async"
+}
+
+// ParseReplicationMode parses "sync" or "async"
+func ParseReplicationMode(s string) (ReplicationMode, error) {
+	switch s {
+	case "async", "":
+		return ReplicateAsync, nil
+	case "sync":
+		return ReplicateSync, nil
+	}
+	return 0, fmt.Errorf("cluster: unknown replication mode %q", s)
+}
+
+// replicator copies the owner's state for each key to the nodes that follow
+// it on the ring. Those nodes are first in line to own the key if the owner
+// fails, and already hold its state when they take over.
+type replicator struct {
+	mu       sync.Mutex
+	pending  map[string]struct{} // keys written since the last async flush
+	flushing bool
+}
+
+// holders returns the owner and replicas of key under r
+func (c *Cluster) holders(r *Ring, key string) []string {
+	return r.Successors(key, c.replicas+1)
+}
+
+// replicasOf returns the nodes holding copies of key, nearest first
+func (c *Cluster) replicasOf(key string) []Node {
+	ids := c.holders(c.ring.Load(), key)
+	if len(ids) < 2 {
+		return nil
+	}
+	members := *c.members.Load()
+	out := make([]Node, 0, len(ids)-1)
+	for _, id := range ids[1:] {
+		out = append(out, members[id])
+	}
+	return out
+}
+
+// decide decides a check here, copying the result to the key's replicas if
+// this node owns it
+func (c *Cluster) decide(key string, p ratelimiter.Priority) service.Decision {
+	d := c.svc.CheckRateLimitPriority(key, p)
+	if c.replicas > 0 && c.Owner(key).ID == c.self.ID {
+		c.replicate(key)
+	}
+	return d
+}
+
+func (c *Cluster) replicate(key string) {
+	if c.mode == ReplicateSync {
+		e, ok := c.svc.ExportEntry(key)
+		if !ok {
+			return
+		}
+		var wg sync.WaitGroup
+		for _, n := range c.replicasOf(key) {
+			wg.Add(1)
+			go func(n Node) {
+				defer wg.Done()
+				c.copyTo(n, []store.Entry{e}, false)
+			}(n)
+		}
+		wg.Wait()
+		return
+	}
+
+	c.rep.mu.Lock()
+	defer c.rep.mu.Unlock()
+	if c.rep.pending == nil {
+		c.rep.pending = make(map[string]struct{})
+	}
+	c.rep.pending[key] = struct{}{}
+	if !c.rep.flushing {
+		c.rep.flushing = true
+		go c.flushReplicas()
+	}
+}
+
+// flushReplicas sends queued keys until the queue stays empty. A key written
+// several times while a batch is in flight is sent once, as it stands.
+func (c *Cluster) flushReplicas() {
+	for {
+		c.rep.mu.Lock()
+		keys := c.rep.pending
+		if len(keys) == 0 {
+			c.rep.flushing = false
+			c.rep.mu.Unlock()
+			return
+		}
+		c.rep.pending = nil
+		c.rep.mu.Unlock()
+
+		batches := make(map[Node][]store.Entry)
+		for key := range keys {
+			e, ok := c.svc.ExportEntry(key)
+			if !ok {
+				continue
+			}
+			for _, n := range c.replicasOf(key) {
+				batches[n] = append(batches[n], e)
+			}
+		}
+		for n, entries := range batches {
+			c.copyTo(n, entries, false)
+		}
+	}
+}
+
+// copyTo sends entries to a replica, which keeps each one unless it holds a
+// newer version, or combines them as send does with handOver. Failures are
+// counted and logged rather than returned: the owner's own copy is still
+// good.
+func (c *Cluster) copyTo(n Node, entries []store.Entry, handOver bool) {
+	for len(entries) > 0 {
+		k := min(len(entries), handoffBatch)
+		if err := c.send(n, entries[:k], handOver); err != nil {
+			c.replicationErrors.Add(uint64(len(entries)))
+			c.logger.Warn("replicating state failed", "replica", n.ID, "error", err)
+			return
+		}
+		c.replicated.Add(uint64(k))
+		entries = entries[k:]
+	}
+}
+
+// failover decides a check for key whose owner couldn't be reached, on the
+// nearest replica that answers. A replica holds the owner's state as of its
+// last copy, so limits hold across the failure instead of restarting. Returns
+// false if no replica could decide.
+func (c *Cluster) failover(key string, p ratelimiter.Priority) (service.Decision, bool) {
+	for _, n := range c.replicasOf(key) {
+		if n.ID == c.self.ID {
+			c.failedOver.Add(1)
+			return c.svc.CheckRateLimitPriority(key, p), true
+		}
+		d, err := c.forward(n, key, p)
+		if err == nil {
+			c.failedOver.Add(1)
+			return d, true
+		}
+		c.logger.Warn("forwarding check to replica failed", "replica", n.ID, "error", err)
+	}
+	return service.Decision{}, false
+}
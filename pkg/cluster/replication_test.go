@@ -0,0 +1,194 @@
+package cluster
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting until %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newReplicatedCluster(t *testing.T, n, replicas int, mode ReplicationMode) []*testNode {
+	t.Helper()
+	_, nodes := startTestNodes(t, n, Options{Replicas: replicas, Replication: mode}, true)
+	return nodes
+}
+
+// crash stops n without it leaving, and has the others notice
+func crash(n *testNode, others []*testNode) {
+	n.server.Close()
+	for _, o := range others {
+		o.Leave(n.Self().ID)
+	}
+}
+
+// checkHolders fails unless exactly key's owner and replicas hold its state
+func checkHolders(t *testing.T, nodes []*testNode, key string) {
+	t.Helper()
+	holders := nodes[0].holders(nodes[0].ring.Load(), key)
+	for _, n := range nodes {
+		want := slices.Contains(holders, n.Self().ID)
+		if _, ok := n.svc.State(key); ok != want {
+			t.Errorf("Node %s holding %s = %v, holders are %v", n.Self().ID, key, ok, holders)
+		}
+	}
+}
+
+func TestReplicationSyncSurvivesOwnerCrash(t *testing.T) {
+	nodes := newReplicatedCluster(t, 3, 1, ReplicateSync)
+	owner := nodes[2]
+	key := keyOwnedBy(t, owner.Cluster, owner.Self().ID)
+	for i := 0; i < 5; i++ {
+		nodes[0].CheckRateLimit(key)
+	}
+	// Synchronous copies are in place as soon as the checks return
+	checkHolders(t, nodes, key)
+
+	// Until the others notice, checks for the key go to its replica
+	owner.server.Close()
+	if nodes[0].CheckRateLimit(key).Allowed || nodes[1].CheckRateLimit(key).Allowed {
+		t.Error("Expected the replica to deny the exhausted key")
+	}
+	var failedOver uint64
+	for _, n := range nodes[:2] {
+		failedOver += n.Stats().FailedOver
+	}
+	if failedOver != 2 {
+		t.Errorf("Expected 2 checks failed over, got %d", failedOver)
+	}
+
+	crash(owner, nodes[:2])
+	replica := nodes[0]
+	if replica.Owner(key).ID != replica.Self().ID {
+		replica = nodes[1]
+	}
+	if replica.Stats().Promoted == 0 {
+		t.Error("Expected the replica to count its promotion")
+	}
+	for _, n := range nodes[:2] {
+		if n.CheckRateLimit(key).Allowed {
+			t.Errorf("Node %s allowed %s after its owner crashed", n.Self().ID, key)
+		}
+	}
+	// The promoted owner copied the key to its new replica
+	checkHolders(t, nodes[:2], key)
+}
+
+func TestReplicationAsyncCatchesUp(t *testing.T) {
+	nodes := newReplicatedCluster(t, 3, 2, ReplicateAsync)
+	owner := nodes[1]
+	key := keyOwnedBy(t, owner.Cluster, owner.Self().ID)
+	for i := 0; i < 5; i++ {
+		owner.CheckRateLimit(key)
+	}
+	want, _ := owner.svc.ExportEntry(key)
+	eventually(t, "both replicas have the latest write", func() bool {
+		for _, n := range nodes {
+			if e, ok := n.svc.ExportEntry(key); !ok || e.Version != want.Version {
+				return false
+			}
+		}
+		return true
+	})
+	if st := owner.Stats(); st.Replicated == 0 || st.ReplicationErrors != 0 {
+		t.Errorf("Unexpected stats %+v", st)
+	}
+
+	survivors := []*testNode{nodes[0], nodes[2]}
+	crash(owner, survivors)
+	for _, n := range survivors {
+		if n.CheckRateLimit(key).Allowed {
+			t.Errorf("Node %s allowed %s after its owner crashed", n.Self().ID, key)
+		}
+	}
+}
+
+func TestReplicationFollowsMembershipChanges(t *testing.T) {
+	nodes := newReplicatedCluster(t, 3, 1, ReplicateSync)
+	joining := nodes[2]
+	// The first two nodes don't know about the third yet
+	for _, n := range nodes[:2] {
+		n.SetMembers([]Node{nodes[0].Self(), nodes[1].Self()})
+	}
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		for j := 0; j < 5; j++ {
+			nodes[0].CheckRateLimit(keys[i])
+		}
+	}
+
+	for _, n := range nodes[:2] {
+		if err := n.Join(joining.Self()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range keys {
+		checkHolders(t, nodes, key)
+		if nodes[0].CheckRateLimit(key).Allowed {
+			t.Errorf("Allowed %s after the join", key)
+		}
+	}
+}
+
+func TestResetReachesReplicasAndOutlivesOlderCopies(t *testing.T) {
+	nodes := newReplicatedCluster(t, 3, 1, ReplicateSync)
+	owner := nodes[2]
+	key := keyOwnedBy(t, owner.Cluster, owner.Self().ID)
+	for i := 0; i < 5; i++ {
+		nodes[0].CheckRateLimit(key)
+	}
+	drained, _ := owner.svc.ExportEntry(key)
+
+	// Reset through a node that doesn't own the key
+	if !nodes[0].Reset(key) {
+		t.Fatal("Expected Reset to report the key existed")
+	}
+	for _, n := range nodes {
+		if _, ok := n.svc.State(key); ok {
+			t.Errorf("Node %s still holds %s", n.Self().ID, key)
+		}
+	}
+	// A copy written before the reset, e.g. replication still in flight
+	holders := owner.holders(owner.ring.Load(), key)
+	for _, n := range nodes {
+		if !slices.Contains(holders, n.Self().ID) {
+			continue
+		}
+		if ok, _ := n.svc.ImportState(drained); ok {
+			t.Errorf("Node %s took a copy older than the reset", n.Self().ID)
+		}
+	}
+	if !nodes[1].CheckRateLimit(key).Allowed {
+		t.Error("Expected the key to start afresh")
+	}
+	checkHolders(t, nodes, key)
+}
+
+func TestResetWithOwnerDownReachesReplicas(t *testing.T) {
+	nodes := newReplicatedCluster(t, 3, 1, ReplicateSync)
+	owner := nodes[2]
+	key := keyOwnedBy(t, owner.Cluster, owner.Self().ID)
+	for i := 0; i < 5; i++ {
+		nodes[0].CheckRateLimit(key)
+	}
+	owner.server.Close()
+	if !nodes[0].Reset(key) {
+		t.Error("Expected Reset to report the key existed")
+	}
+	for _, n := range nodes[:2] {
+		if _, ok := n.svc.State(key); ok {
+			t.Errorf("Node %s still holds %s", n.Self().ID, key)
+		}
+	}
+}
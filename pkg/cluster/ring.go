@@ -0,0 +1,168 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultVirtualNodes is how many points each node gets on a ring built with
+// NewRing(0). More points even out the share of keys each node owns.
+const DefaultVirtualNodes = 128
+
+// Ring assigns keys to nodes by consistent hashing. Each node is hashed onto
+// the ring at several points and owns the keys that hash up to each of them,
+// so adding or removing a node only moves the keys next to its points.
+type Ring struct {
+	vnodes int
+
+	mu     sync.RWMutex
+	nodes  map[string]struct{}
+	points []uint64 // sorted
+	owner  map[uint64]string
+}
+
+// NewRing returns an empty ring placing vnodes points per node
+func NewRing(vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+	return &Ring{vnodes: vnodes, nodes: make(map[string]struct{}), owner: make(map[uint64]string)}
+}
+
+// Add places node on the ring and reports whether it was new
+func (r *Ring) Add(node string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.nodes[node]; ok {
+		return false
+	}
+	r.nodes[node] = struct{}{}
+	r.rebuildLocked()
+	return true
+}
+
+// Remove takes node off the ring and reports whether it was there
+func (r *Ring) Remove(node string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.nodes[node]; !ok {
+		return false
+	}
+	delete(r.nodes, node)
+	r.rebuildLocked()
+	return true
+}
+
+// Set replaces the ring's nodes and reports whether anything changed
+func (r *Ring) Set(nodes []string) bool {
+	next := make(map[string]struct{}, len(nodes))
+	for _, n := range nodes {
+		next[n] = struct{}{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(next) == len(r.nodes) {
+		same := true
+		for n := range next {
+			if _, ok := r.nodes[n]; !ok {
+				same = false
+				break
+			}
+		}
+		if same {
+			return false
+		}
+	}
+	r.nodes = next
+	r.rebuildLocked()
+	return true
+}
+
+func (r *Ring) rebuildLocked() {
+	r.points = r.points[:0]
+	r.owner = make(map[uint64]string, len(r.nodes)*r.vnodes)
+	for n := range r.nodes {
+		for i := 0; i < r.vnodes; i++ {
+			h := hashKey(n + "#" + strconv.Itoa(i))
+			if prev, ok := r.owner[h]; ok && prev < n {
+				// A collision must resolve the same way on every node
+				continue
+			} else if !ok {
+				r.points = append(r.points, h)
+			}
+			r.owner[h] = n
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// Owner returns the node owning key, or "" if the ring is empty
+func (r *Ring) Owner(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.points) == 0 {
+		return ""
+	}
+	return r.owner[r.points[r.search(hashKey(key))]]
+}
+
+// Successors returns up to n distinct nodes for key in ring order, starting
+// with its owner
+func (r *Ring) Successors(key string, n int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n = min(n, len(r.nodes))
+	if n <= 0 {
+		return nil
+	}
+	out := make([]string, 0, n)
+	start := r.search(hashKey(key))
+	for i := 0; len(out) < n && i < len(r.points); i++ {
+		node := r.owner[r.points[(start+i)%len(r.points)]]
+		if !slices.Contains(out, node) {
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+// search returns the index of the first point at or after h, wrapping round
+func (r *Ring) search(h uint64) int {
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return i
+}
+
+// Nodes lists the ring's nodes in sorted order
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]string, 0, len(r.nodes))
+	for n := range r.nodes {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return mix64(h.Sum64())
+}
+
+// mix64 spreads FNV output over the high bits, which are otherwise nearly
+// constant for short keys sharing a prefix
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
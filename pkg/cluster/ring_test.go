@@ -0,0 +1,96 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingEmpty(t *testing.T) {
+	r := NewRing(0)
+	if owner := r.Owner("k"); owner != "" {
+		t.Errorf("Expected no owner on an empty ring, got %q", owner)
+	}
+	if s := r.Successors("k", 3); s != nil {
+		t.Errorf("Expected no successors, got %v", s)
+	}
+}
+
+func TestRingSpreadsKeys(t *testing.T) {
+	r := NewRing(0)
+	r.Set([]string{"a", "b", "c"})
+	counts := map[string]int{}
+	const keys = 30000
+	for i := 0; i < keys; i++ {
+		counts[r.Owner(fmt.Sprintf("user:%d", i))]++
+	}
+	for node, n := range counts {
+		if share := float64(n) / keys; share < 0.25 || share > 0.42 {
+			t.Errorf("Node %s owns %.2f of keys", node, share)
+		}
+	}
+}
+
+func TestRingAddMovesKeysOnlyToNewNode(t *testing.T) {
+	r := NewRing(0)
+	r.Set([]string{"a", "b", "c"})
+	const keys = 10000
+	before := make([]string, keys)
+	for i := range before {
+		before[i] = r.Owner(fmt.Sprintf("user:%d", i))
+	}
+	if !r.Add("d") || r.Add("d") {
+		t.Fatal("Expected Add to report only the first insert")
+	}
+	moved := 0
+	for i, prev := range before {
+		now := r.Owner(fmt.Sprintf("user:%d", i))
+		if now == prev {
+			continue
+		}
+		if now != "d" {
+			t.Fatalf("Key moved from %s to %s, not to the new node", prev, now)
+		}
+		moved++
+	}
+	if share := float64(moved) / keys; share < 0.15 || share > 0.35 {
+		t.Errorf("Expected about a quarter of keys to move, got %.2f", share)
+	}
+
+	r.Remove("d")
+	for i, prev := range before {
+		if now := r.Owner(fmt.Sprintf("user:%d", i)); now != prev {
+			t.Fatalf("Removing the new node should restore ownership, key %d on %s", i, now)
+		}
+	}
+}
+
+func TestRingAgreesRegardlessOfOrder(t *testing.T) {
+	r1, r2 := NewRing(16), NewRing(16)
+	for _, n := range []string{"a", "b", "c"} {
+		r1.Add(n)
+	}
+	for _, n := range []string{"c", "a", "b"} {
+		r2.Add(n)
+	}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint(i)
+		if r1.Owner(key) != r2.Owner(key) {
+			t.Fatalf("Rings disagree on %s", key)
+		}
+	}
+}
+
+func TestRingSuccessors(t *testing.T) {
+	r := NewRing(0)
+	r.Set([]string{"a", "b", "c"})
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		s := r.Successors(key, 5)
+		if len(s) != 3 || s[0] != r.Owner(key) {
+			t.Fatalf("Successors(%s) = %v, owner %s", key, s, r.Owner(key))
+		}
+		if s[0] == s[1] || s[1] == s[2] || s[0] == s[2] {
+			t.Fatalf("Successors(%s) repeats a node: %v", key, s)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/ratelimiter"
+	"RateLimiterService/pkg/service"
+	"RateLimiterService/pkg/store"
+)
+
+// InternalPath prefixes the endpoints nodes call on each other. They should
+// not be reachable from outside the cluster.
+const InternalPath = "/internal/v1/"
+
+const (
+	checkPath   = InternalPath + "check"
+	handoffPath = InternalPath + "handoff"
+	resetPath   = InternalPath + "reset"
+)
+
+type checkRequest struct {
+	Key      string               `json:"key"`
+	Priority ratelimiter.Priority `json:"priority,omitempty"`
+}
+
+type checkResponse struct {
+	Allowed   bool  `json:"allowed"`
+	Remaining int64 `json:"remaining"`
+}
+
+type resetRequest struct {
+	Key string `json:"key"`
+}
+
+type resetResponse struct {
+	Existed bool `json:"existed"`
+}
+
+type handoffRequest struct {
+	Entries []wireEntry `json:"entries"`
+	// HandOver marks entries moving with a membership change, which are
+	// combined with any state the receiver started since rather than
+	// ordered by version
+	HandOver bool `json:"hand_over,omitempty"`
+}
+
+type handoffResponse struct {
+	Applied int `json:"applied"`
+}
+
+// wireEntry is a store.Entry with its value encoded by the store's codecs
+type wireEntry struct {
+	Key        string           `json:"key"`
+	Type       string           `json:"type"`
+	Value      json.RawMessage  `json:"value"`
+	LastAccess time.Time        `json:"last_access"`
+	Version    *clock.Timestamp `json:"version,omitempty"`
+	Deleted    bool             `json:"deleted,omitempty"`
+}
+
+func encodeEntry(e store.Entry) (wireEntry, error) {
+	if e.Deleted {
+		we := wireEntry{Key: e.Key, LastAccess: e.LastAccess, Deleted: true}
+		if !e.Version.IsZero() {
+			we.Version = &e.Version
+		}
+		return we, nil
+	}
+	typ, data, err := store.EncodeValue(e.Value)
+	if err != nil {
+		return wireEntry{}, fmt.Errorf("key %q: %w", e.Key, err)
+	}
+	we := wireEntry{Key: e.Key, Type: typ, Value: data, LastAccess: e.LastAccess}
+	if !e.Version.IsZero() {
+		we.Version = &e.Version
+	}
+	return we, nil
+}
+
+func decodeEntry(we wireEntry) (store.Entry, error) {
+	if we.Deleted {
+		e := store.Entry{Key: we.Key, LastAccess: we.LastAccess, Deleted: true}
+		if we.Version != nil {
+			e.Version = *we.Version
+		}
+		return e, nil
+	}
+	v, err := store.DecodeValue(we.Type, we.Value)
+	if err != nil {
+		return store.Entry{}, fmt.Errorf("key %q: %w", we.Key, err)
+	}
+	e := store.Entry{Key: we.Key, Value: v, LastAccess: we.LastAccess}
+	if we.Version != nil {
+		e.Version = *we.Version
+	}
+	return e, nil
+}
+
+// Handler serves the internal endpoints under InternalPath. Checks it
+// receives are always decided locally: the sender has already picked this
+// node as the owner, and forwarding again could loop while two nodes
+// disagree about membership.
+func (c *Cluster) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(checkPath, func(w http.ResponseWriter, r *http.Request) {
+		var req checkRequest
+		if !c.decode(w, r, &req) {
+			return
+		}
+		c.served.Add(1)
+		d := c.decide(req.Key, req.Priority)
+		writeJSON(w, checkResponse{Allowed: d.Allowed, Remaining: d.Remaining})
+	})
+	mux.HandleFunc(resetPath, func(w http.ResponseWriter, r *http.Request) {
+		var req resetRequest
+		if !c.decode(w, r, &req) {
+			return
+		}
+		writeJSON(w, resetResponse{Existed: c.bury(req.Key)})
+	})
+	mux.HandleFunc(handoffPath, func(w http.ResponseWriter, r *http.Request) {
+		var req handoffRequest
+		if !c.decode(w, r, &req) {
+			return
+		}
+		importState := c.svc.ImportState
+		if req.HandOver {
+			importState = c.svc.HandOver
+		}
+		applied := 0
+		for _, we := range req.Entries {
+			e, err := decodeEntry(we)
+			if err != nil {
+				c.logger.Warn("dropping handed off entry", "error", err)
+				continue
+			}
+			if ok, _ := importState(e); ok {
+				applied++
+			}
+		}
+		c.received.Add(uint64(applied))
+		writeJSON(w, handoffResponse{Applied: applied})
+	})
+	mux.HandleFunc(countersPath, func(w http.ResponseWriter, r *http.Request) {
+		s := c.counters.Load()
+		if s == nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.serve(w, r)
+	})
+	mux.HandleFunc(gossipPath, func(w http.ResponseWriter, r *http.Request) {
+		g := c.gossip.Load()
+		if g == nil {
+			http.NotFound(w, r)
+			return
+		}
+		g.mux.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+// decode checks the method and secret and reads the JSON body into v
+func (c *Cluster) decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	got := r.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+c.secret)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// forward asks owner to decide a check for key
+func (c *Cluster) forward(owner Node, key string, p ratelimiter.Priority) (service.Decision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	var resp checkResponse
+	if err := c.call(ctx, owner, checkPath, checkRequest{Key: key, Priority: p}, &resp); err != nil {
+		return service.Decision{}, err
+	}
+	return service.Decision{Allowed: resp.Allowed, Remaining: resp.Remaining, Priority: p}, nil
+}
+
+// forwardReset asks owner to reset key
+func (c *Cluster) forwardReset(owner Node, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	var resp resetResponse
+	if err := c.call(ctx, owner, resetPath, resetRequest{Key: key}, &resp); err != nil {
+		return false, err
+	}
+	return resp.Existed, nil
+}
+
+// send hands entries to their new owner, or copies them to a replica unless
+// handOver is set. Entries without a codec can't be moved and are left to
+// expire here.
+func (c *Cluster) send(owner Node, entries []store.Entry, handOver bool) error {
+	req := handoffRequest{Entries: make([]wireEntry, 0, len(entries)), HandOver: handOver}
+	for _, e := range entries {
+		we, err := encodeEntry(e)
+		if err != nil {
+			c.logger.Warn("cannot hand off entry", "error", err)
+			continue
+		}
+		req.Entries = append(req.Entries, we)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	var resp handoffResponse
+	return c.call(ctx, owner, handoffPath, req, &resp)
+}
+
+// call posts in to path on n and decodes the reply into out
+func (c *Cluster) call(ctx context.Context, n Node, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.secret)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("%s%s: %s", n.Addr, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
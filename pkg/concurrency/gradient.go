@@ -0,0 +1,97 @@
+package concurrency
+
+import (
+	"math"
+	"time"
+)
+
+// GradientOptions configures a Gradient limit. Zero fields take the defaults
+// in brackets.
+type GradientOptions struct {
+	Initial int // starting limit [20]
+	Min     int // lowest limit [1]
+	Max     int // highest limit [1000]
+	// Tolerance is how far latency may rise over the baseline before the
+	// limit shrinks [1.5]
+	Tolerance float64
+	// Smoothing is the weight of each new estimate against the current
+	// limit [0.2]
+	Smoothing float64
+	// Probe is the number of samples between re-measurements of the
+	// baseline [1000]
+	Probe int
+}
+
+// Gradient is a Limit after Netflix's GradientLimit. The baseline is the
+// lowest latency seen, and each sample's gradient is
+// Tolerance×baseline/sample, capped to [0.5, 1]: the limit holds while
+// latency is within tolerance of the baseline and shrinks by up to half as
+// it climbs past it. √limit is added either way, as headroom for queueing,
+// so a limit the backend can absorb keeps growing.
+//
+// A minimum never rises by itself, and one measured under load is no
+// baseline, so every Probe samples the limit drops to √limit and the
+// baseline is measured afresh.
+type Gradient struct {
+	opts     GradientOptions
+	limit    float64
+	baseline time.Duration
+	probe    int // samples until the next probe
+}
+
+func NewGradient(opts GradientOptions) *Gradient {
+	if opts.Min <= 0 {
+		opts.Min = 1
+	}
+	if opts.Max <= 0 {
+		opts.Max = 1000
+	}
+	opts.Max = max(opts.Max, opts.Min)
+	if opts.Initial <= 0 {
+		opts.Initial = 20
+	}
+	opts.Initial = min(max(opts.Initial, opts.Min), opts.Max)
+	if opts.Tolerance < 1 {
+		opts.Tolerance = 1.5
+	}
+	if opts.Smoothing <= 0 || opts.Smoothing > 1 {
+		opts.Smoothing = 0.2
+	}
+	if opts.Probe <= 0 {
+		opts.Probe = 1000
+	}
+	return &Gradient{opts: opts, limit: float64(opts.Initial), probe: opts.Probe}
+}
+
+func (g *Gradient) Limit() int {
+	return int(g.limit)
+}
+
+func (g *Gradient) Update(rtt time.Duration, inflight int, dropped bool) {
+	if rtt <= 0 {
+		return
+	}
+	queue := math.Sqrt(g.limit)
+	if g.probe--; g.probe <= 0 {
+		g.probe = g.opts.Probe
+		g.limit = max(queue, float64(g.opts.Min))
+		g.baseline = 0
+		return
+	}
+	if g.baseline == 0 || rtt < g.baseline {
+		g.baseline = rtt
+	}
+	// With under half the limit in use, latency says nothing about what
+	// the backend could take
+	if !dropped && inflight < int(g.limit)/2 {
+		return
+	}
+
+	gradient := 0.5
+	if !dropped {
+		gradient = max(0.5, min(1, g.opts.Tolerance*float64(g.baseline)/float64(rtt)))
+	}
+	next := g.limit*gradient + queue
+	next = g.limit*(1-g.opts.Smoothing) + next*g.opts.Smoothing
+	g.limit = min(max(next, float64(g.opts.Min)), float64(g.opts.Max))
+}
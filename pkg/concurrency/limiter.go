@@ -0,0 +1,131 @@
+// Package concurrency bounds how many requests are in flight at once, with a
+// limit that adapts to their latency rather than being configured. When
+// latency climbs above its baseline, requests are queueing somewhere and the
+// limit shrinks; while it holds, the limit probes upward.
+package concurrency
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+// Limit estimates how many requests may be in flight from the latency of
+// completed ones. Implementations need not be safe for concurrent use; the
+// Limiter serialises calls.
+type Limit interface {
+	// Limit returns the current estimate
+	Limit() int
+	// Update records a request that took rtt, with inflight requests in
+	// flight when it started (itself included). dropped means it failed
+	// from overload, e.g. it timed out, and its rtt says little.
+	Update(rtt time.Duration, inflight int, dropped bool)
+}
+
+// Stats describes a Limiter
+type Stats struct {
+	Limit    int
+	InFlight int
+	Accepted uint64
+	Rejected uint64
+	Dropped  uint64
+}
+
+// Limiter admits requests while fewer than its Limit's estimate are in
+// flight, and feeds the latency of each back into it
+type Limiter struct {
+	clock clock.Clock
+
+	mu       sync.Mutex
+	limit    Limit
+	inflight int
+
+	accepted atomic.Uint64
+	rejected atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+func NewLimiter(limit Limit, clock clock.Clock) *Limiter {
+	return &Limiter{limit: limit, clock: clock}
+}
+
+// Acquire reserves a slot for a request, or returns false if the limit is
+// reached. Release the slot through the token when the request finishes.
+func (l *Limiter) Acquire() (*Token, bool) {
+	l.mu.Lock()
+	if l.inflight >= l.limit.Limit() {
+		l.mu.Unlock()
+		l.rejected.Add(1)
+		return nil, false
+	}
+	l.inflight++
+	inflight := l.inflight
+	l.mu.Unlock()
+	l.accepted.Add(1)
+	return &Token{l: l, start: l.clock.Now(), inflight: inflight}, true
+}
+
+// Limit returns the current limit
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit.Limit()
+}
+
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	limit, inflight := l.limit.Limit(), l.inflight
+	l.mu.Unlock()
+	return Stats{
+		Limit:    limit,
+		InFlight: inflight,
+		Accepted: l.accepted.Load(),
+		Rejected: l.rejected.Load(),
+		Dropped:  l.dropped.Load(),
+	}
+}
+
+func (l *Limiter) release(t *Token, sample, dropped bool) {
+	rtt := l.clock.Since(t.start)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	if sample {
+		l.limit.Update(rtt, t.inflight, dropped)
+	}
+}
+
+// Token is a request's slot in a Limiter. Exactly one of its methods should
+// be called; later calls do nothing, as do calls on a nil Token.
+type Token struct {
+	l        *Limiter
+	start    time.Time
+	inflight int
+	released atomic.Bool
+}
+
+// Done releases the slot of a request that completed, counting its latency
+func (t *Token) Done() {
+	if t != nil && t.released.CompareAndSwap(false, true) {
+		t.l.release(t, true, false)
+	}
+}
+
+// Dropped releases the slot of a request that failed from overload, which
+// shrinks the limit
+func (t *Token) Dropped() {
+	if t != nil && t.released.CompareAndSwap(false, true) {
+		t.l.dropped.Add(1)
+		t.l.release(t, true, true)
+	}
+}
+
+// Ignore releases the slot without counting the request, e.g. one that
+// failed before doing any real work
+func (t *Token) Ignore() {
+	if t != nil && t.released.CompareAndSwap(false, true) {
+		t.l.release(t, false, false)
+	}
+}
@@ -0,0 +1,78 @@
+package concurrency
+
+import (
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+// fixed is a Limit that records its samples
+type fixed struct {
+	limit   int
+	samples []time.Duration
+	drops   int
+}
+
+func (f *fixed) Limit() int { return f.limit }
+
+func (f *fixed) Update(rtt time.Duration, inflight int, dropped bool) {
+	f.samples = append(f.samples, rtt)
+	if dropped {
+		f.drops++
+	}
+}
+
+func TestLimiterBoundsInFlight(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	f := &fixed{limit: 2}
+	l := NewLimiter(f, c)
+
+	a, ok := l.Acquire()
+	b, ok2 := l.Acquire()
+	if !ok || !ok2 {
+		t.Fatal("Expected two slots")
+	}
+	if _, ok := l.Acquire(); ok {
+		t.Fatal("Expected a third request to be rejected")
+	}
+
+	c.Advance(30 * time.Millisecond)
+	a.Done()
+	a.Done() // released once only
+	b.Ignore()
+	if st := l.Stats(); st.InFlight != 0 || st.Accepted != 2 || st.Rejected != 1 {
+		t.Errorf("Unexpected stats %+v", st)
+	}
+	if len(f.samples) != 1 || f.samples[0] != 30*time.Millisecond {
+		t.Errorf("Expected one 30ms sample, got %v", f.samples)
+	}
+
+	d, _ := l.Acquire()
+	d.Dropped()
+	if f.drops != 1 || l.Stats().Dropped != 1 {
+		t.Errorf("Expected a drop to be sampled")
+	}
+}
+
+func TestGradientHalvesOnDrop(t *testing.T) {
+	g := NewGradient(GradientOptions{Initial: 100, Smoothing: 1})
+	g.Update(10*time.Millisecond, 100, false)
+	g.Update(10*time.Millisecond, 100, true)
+	if g.Limit() != 65 { // 110×0.5 + √110
+		t.Errorf("Expected 65, got %d", g.Limit())
+	}
+}
+
+func TestVegasIgnoresAppLimitedSamples(t *testing.T) {
+	v := NewVegas(VegasOptions{Initial: 100})
+	v.Update(10*time.Millisecond, 1, false) // baseline
+	v.Update(50*time.Millisecond, 10, false)
+	if v.Limit() != 100 {
+		t.Errorf("Expected a mostly idle limit to hold, got %d", v.Limit())
+	}
+	v.Update(50*time.Millisecond, 100, false) // 80 queued
+	if v.Limit() != 98 {
+		t.Errorf("Expected the limit to shrink by log10, got %d", v.Limit())
+	}
+}
@@ -0,0 +1,148 @@
+package concurrency
+
+import (
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+// backend models a service that works on up to capacity requests at once in
+// latency each; beyond that they share it, so latency grows with the number
+// in flight
+type backend struct {
+	capacity int
+	latency  time.Duration
+}
+
+func (b backend) serve(inflight int) time.Duration {
+	if inflight <= b.capacity {
+		return b.latency
+	}
+	return b.latency * time.Duration(inflight) / time.Duration(b.capacity)
+}
+
+// simulation drives a Limiter in front of a backend on a fake clock, a
+// millisecond at a time, offering more requests each tick than the backend
+// can take
+type simulation struct {
+	t       *testing.T
+	clock   *clock.FakeClock
+	limiter *Limiter
+	backend backend
+	perTick int
+
+	pending  []pending
+	inflight int
+}
+
+type pending struct {
+	token *Token
+	done  time.Time
+}
+
+// window summarises a stretch of a simulation
+type window struct {
+	limit    int           // limit at the end
+	latency  time.Duration // mean latency of requests started
+	served   int
+	rejected int
+}
+
+// newSimulation starts a simulation with limit windowed over five backend
+// round trips
+func newSimulation(t *testing.T, limit Limit, b backend, perTick int) *simulation {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	l := NewLimiter(NewWindowed(limit, 5*b.latency, 0, c), c)
+	return &simulation{t: t, clock: c, limiter: l, backend: b, perTick: perTick}
+}
+
+func (s *simulation) run(d time.Duration) window {
+	var w window
+	var total time.Duration
+	for end := s.clock.Now().Add(d); s.clock.Now().Before(end); {
+		s.clock.Advance(time.Millisecond)
+		now := s.clock.Now()
+		kept := s.pending[:0]
+		for _, p := range s.pending {
+			if p.done.After(now) {
+				kept = append(kept, p)
+				continue
+			}
+			p.token.Done()
+			s.inflight--
+		}
+		s.pending = kept
+
+		for i := 0; i < s.perTick; i++ {
+			tok, ok := s.limiter.Acquire()
+			if !ok {
+				w.rejected++
+				continue
+			}
+			s.inflight++
+			lat := s.backend.serve(s.inflight)
+			total += lat
+			w.served++
+			s.pending = append(s.pending, pending{token: tok, done: now.Add(lat)})
+		}
+	}
+	w.limit = s.limiter.Limit()
+	if w.served > 0 {
+		w.latency = total / time.Duration(w.served)
+	}
+	return w
+}
+
+// settle runs the simulation for ten seconds and returns the last
+func (s *simulation) settle() window {
+	s.run(9 * time.Second)
+	w := s.run(time.Second)
+	s.t.Logf("capacity %d: limit %d, latency %v, served %d/s", s.backend.capacity, w.limit, w.latency, w.served)
+	return w
+}
+
+// expectHealthy checks the limiter keeps the backend busy without letting
+// latency run away
+func (s *simulation) expectHealthy(w window) {
+	s.t.Helper()
+	throughput := s.backend.capacity * int(time.Second/s.backend.latency)
+	if w.served < throughput*95/100 {
+		s.t.Errorf("Served %d/s, expected close to the backend's %d/s", w.served, throughput)
+	}
+	if w.latency > 2*s.backend.latency {
+		s.t.Errorf("Latency %v, expected within twice the unloaded %v", w.latency, s.backend.latency)
+	}
+}
+
+func TestSimulatedOverload(t *testing.T) {
+	for name, limit := range map[string]func() Limit{
+		"gradient": func() Limit { return NewGradient(GradientOptions{}) },
+		"vegas":    func() Limit { return NewVegas(VegasOptions{}) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			// Four times as many requests offered as the backend can serve
+			s := newSimulation(t, limit(), backend{capacity: 50, latency: 10 * time.Millisecond}, 20)
+			healthy := s.settle()
+			s.expectHealthy(healthy)
+			if healthy.limit < 50 {
+				t.Errorf("Expected the limit to grow to the backend's capacity, got %d", healthy.limit)
+			}
+
+			// The backend loses capacity, so latency rises at the old limit
+			s.backend.capacity = 20
+			degraded := s.settle()
+			s.expectHealthy(degraded)
+			if degraded.limit >= healthy.limit {
+				t.Errorf("Expected the limit to shrink below %d, got %d", healthy.limit, degraded.limit)
+			}
+
+			s.backend.capacity = 50
+			recovered := s.settle()
+			s.expectHealthy(recovered)
+			if recovered.limit < 50 {
+				t.Errorf("Expected the limit to recover, got %d", recovered.limit)
+			}
+		})
+	}
+}
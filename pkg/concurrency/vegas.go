@@ -0,0 +1,113 @@
+package concurrency
+
+import (
+	"math"
+	"time"
+)
+
+// VegasOptions configures a Vegas limit. Zero fields take the defaults in
+// brackets.
+type VegasOptions struct {
+	Initial int // starting limit [20]
+	Min     int // lowest limit [1]
+	Max     int // highest limit [1000]
+	// Smoothing is the weight of each new estimate against the current
+	// limit [1, none]
+	Smoothing float64
+	// ProbeMultiplier sets how often the baseline is re-measured: every
+	// ProbeMultiplier×limit samples [30]
+	ProbeMultiplier int
+}
+
+// Vegas is a Limit after TCP Vegas, by way of Netflix's VegasLimit. The
+// baseline is the lowest latency seen, taken as the latency with no
+// queueing; from it each sample estimates how many requests are queued,
+// limit×(1 - baseline/sample). Below log10(limit) queued the limit grows
+// quickly, below 3×log10(limit) it grows by log10(limit), and above
+// 6×log10(limit) it shrinks by log10(limit). Drops always shrink it.
+//
+// A minimum never rises by itself, so the baseline is periodically reset to
+// the current sample; otherwise a backend that got slower for good would be
+// throttled forever.
+type Vegas struct {
+	opts     VegasOptions
+	limit    float64
+	baseline time.Duration
+	probe    int // samples until the baseline is reset
+}
+
+func NewVegas(opts VegasOptions) *Vegas {
+	if opts.Min <= 0 {
+		opts.Min = 1
+	}
+	if opts.Max <= 0 {
+		opts.Max = 1000
+	}
+	opts.Max = max(opts.Max, opts.Min)
+	if opts.Initial <= 0 {
+		opts.Initial = 20
+	}
+	opts.Initial = min(max(opts.Initial, opts.Min), opts.Max)
+	if opts.Smoothing <= 0 || opts.Smoothing > 1 {
+		opts.Smoothing = 1
+	}
+	if opts.ProbeMultiplier <= 0 {
+		opts.ProbeMultiplier = 30
+	}
+	v := &Vegas{opts: opts, limit: float64(opts.Initial)}
+	v.resetProbe()
+	return v
+}
+
+func (v *Vegas) Limit() int {
+	return int(v.limit)
+}
+
+func (v *Vegas) resetProbe() {
+	v.probe = v.opts.ProbeMultiplier * int(v.limit)
+}
+
+func (v *Vegas) Update(rtt time.Duration, inflight int, dropped bool) {
+	if rtt <= 0 {
+		return
+	}
+	if v.probe--; v.probe <= 0 {
+		v.resetProbe()
+		v.baseline = rtt
+		return
+	}
+	if v.baseline == 0 || rtt < v.baseline {
+		v.baseline = rtt
+		return
+	}
+
+	limit := v.limit
+	l := log10(limit)
+	var next float64
+	switch {
+	case dropped:
+		next = limit - l
+	case inflight*2 < int(limit):
+		// Too little in use to tell
+		return
+	default:
+		queued := math.Ceil(limit * (1 - float64(v.baseline)/float64(rtt)))
+		switch {
+		case queued <= l:
+			next = limit + 6*l
+		case queued < 3*l:
+			next = limit + l
+		case queued > 6*l:
+			next = limit - l
+		default:
+			return
+		}
+	}
+	next = min(max(next, float64(v.opts.Min)), float64(v.opts.Max))
+	v.limit = limit*(1-v.opts.Smoothing) + next*v.opts.Smoothing
+}
+
+// log10 is the integer base-10 logarithm of n, at least 1
+func log10(n float64) float64 {
+	return max(1, math.Floor(math.Log10(n)))
+}
@@ -0,0 +1,52 @@
+package concurrency
+
+import (
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+// Windowed feeds a Limit one sample per window instead of one per request:
+// the mean latency, the most requests in flight and whether any was dropped.
+// Updating on every request reacts within a fraction of a round trip, before
+// the last change has had any effect, and the limit swings; a window of a
+// few round trips damps that. A window closes once it has lasted Window and
+// seen MinSamples.
+type Windowed struct {
+	limit      Limit
+	window     time.Duration
+	minSamples int
+	clock      clock.Clock
+
+	start    time.Time
+	samples  int
+	total    time.Duration
+	inflight int
+	dropped  bool
+}
+
+// NewWindowed wraps limit. minSamples below 1 means 10.
+func NewWindowed(limit Limit, window time.Duration, minSamples int, clock clock.Clock) *Windowed {
+	if minSamples <= 0 {
+		minSamples = 10
+	}
+	return &Windowed{limit: limit, window: window, minSamples: minSamples, clock: clock, start: clock.Now()}
+}
+
+func (w *Windowed) Limit() int {
+	return w.limit.Limit()
+}
+
+func (w *Windowed) Update(rtt time.Duration, inflight int, dropped bool) {
+	w.samples++
+	w.total += rtt
+	w.inflight = max(w.inflight, inflight)
+	w.dropped = w.dropped || dropped
+
+	now := w.clock.Now()
+	if w.samples < w.minSamples || now.Sub(w.start) < w.window {
+		return
+	}
+	w.limit.Update(w.total/time.Duration(w.samples), w.inflight, w.dropped)
+	w.start, w.samples, w.total, w.inflight, w.dropped = now, 0, 0, 0, false
+}
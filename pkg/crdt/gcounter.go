@@ -0,0 +1,58 @@
+// Package crdt holds conflict-free replicated data types: values each node
+// updates locally that converge, without coordination, when nodes merge each
+// other's copies in any order and any number of times.
+package crdt
+
+// GCounter is a grow-only counter. Each node increments only its own slot;
+// the value is the sum of the slots, and merging keeps each slot's maximum.
+// Methods return a new counter rather than modifying the receiver, so copies
+// held elsewhere, such as in a store, stay valid.
+type GCounter map[string]uint64
+
+// Value is the counter's total
+func (c GCounter) Value() uint64 {
+	var sum uint64
+	for _, n := range c {
+		sum += n
+	}
+	return sum
+}
+
+// Get returns node's slot
+func (c GCounter) Get(node string) uint64 {
+	return c[node]
+}
+
+// Inc returns the counter with node's slot raised by n
+func (c GCounter) Inc(node string, n uint64) GCounter {
+	out := c.clone()
+	out[node] += n
+	return out
+}
+
+// Observe returns the counter merged with a single slot reported by node
+func (c GCounter) Observe(node string, n uint64) GCounter {
+	if c[node] >= n {
+		return c
+	}
+	out := c.clone()
+	out[node] = n
+	return out
+}
+
+// Merge returns the least counter covering both c and o
+func (c GCounter) Merge(o GCounter) GCounter {
+	out := c.clone()
+	for node, n := range o {
+		out[node] = max(out[node], n)
+	}
+	return out
+}
+
+func (c GCounter) clone() GCounter {
+	out := make(GCounter, len(c)+1)
+	for node, n := range c {
+		out[node] = n
+	}
+	return out
+}
@@ -0,0 +1,39 @@
+package crdt
+
+import "testing"
+
+func TestGCounterIncLeavesReceiver(t *testing.T) {
+	a := GCounter{}.Inc("n1", 2)
+	b := a.Inc("n1", 1).Inc("n2", 4)
+	if a.Value() != 2 || b.Value() != 7 {
+		t.Errorf("Expected 2 and 7, got %d and %d", a.Value(), b.Value())
+	}
+	if b.Get("n1") != 3 || b.Get("n3") != 0 {
+		t.Errorf("Unexpected slots %v", b)
+	}
+}
+
+func TestGCounterMergeConverges(t *testing.T) {
+	a := GCounter{"n1": 5, "n2": 1}
+	b := GCounter{"n2": 3, "n3": 2}
+	ab, ba := a.Merge(b), b.Merge(a)
+	if ab.Value() != 10 || ba.Value() != 10 {
+		t.Fatalf("Merge = %v and %v, want a total of 10", ab, ba)
+	}
+	if again := ab.Merge(b).Merge(a); again.Value() != 10 {
+		t.Errorf("Merging again changed the value: %v", again)
+	}
+	if a.Value() != 6 {
+		t.Errorf("Merge modified its receiver: %v", a)
+	}
+}
+
+func TestGCounterObserveKeepsMaximum(t *testing.T) {
+	c := GCounter{"n1": 5}
+	if got := c.Observe("n1", 3); got.Get("n1") != 5 {
+		t.Errorf("A stale report lowered the slot to %d", got.Get("n1"))
+	}
+	if got := c.Observe("n1", 8); got.Get("n1") != 8 || c.Get("n1") != 5 {
+		t.Errorf("Observe = %v, receiver %v", got, c)
+	}
+}
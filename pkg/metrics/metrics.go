@@ -0,0 +1,395 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Collector writes one metric family in Prometheus text exposition format
+type Collector interface {
+	Collect(w io.Writer)
+}
+
+// Registry holds collectors and renders them in registration order
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds collectors to the registry
+func (r *Registry) Register(cs ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, cs...)
+}
+
+// WriteTo renders every registered collector
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	cs := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, c := range cs {
+		c.Collect(cw)
+	}
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+// Handler serves the registry at a Prometheus scrape endpoint
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	name   string
+	help   string
+	labels string
+	val    atomic.Uint64
+}
+
+func NewCounter(name, help string) *Counter {
+	return &Counter{name: name, help: help}
+}
+
+func (c *Counter) Inc() {
+	c.val.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.val.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.val.Load()
+}
+
+func (c *Counter) Collect(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.writeSample(w)
+}
+
+func (c *Counter) writeSample(w io.Writer) {
+	fmt.Fprintf(w, "%s%s %d\n", c.name, c.labels, c.val.Load())
+}
+
+// CounterVec is a family of counters partitioned by label values
+type CounterVec struct {
+	name       string
+	help       string
+	labelNames []string
+	mu         sync.Mutex
+	children   map[string]*Counter
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		children:   make(map[string]*Counter),
+	}
+}
+
+// WithLabelValues returns the counter for the given label values, creating it
+// on first use. Values must be given in the order of the label names.
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	labels := formatLabels(v.labelNames, values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.children[labels]
+	if !ok {
+		c = &Counter{name: v.name, labels: labels}
+		v.children[labels] = c
+	}
+	return c
+}
+
+func (v *CounterVec) Collect(w io.Writer) {
+	writeHeader(w, v.name, v.help, "counter")
+	v.mu.Lock()
+	keys := sortedKeys(v.children)
+	children := make([]*Counter, len(keys))
+	for i, k := range keys {
+		children[i] = v.children[k]
+	}
+	v.mu.Unlock()
+	for _, c := range children {
+		c.writeSample(w)
+	}
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	name    string
+	help    string
+	labels  string
+	bounds  []float64
+	mu      sync.Mutex
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return newHistogram(name, help, "", buckets)
+}
+
+func newHistogram(name, help, labels string, buckets []float64) *Histogram {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	return &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		bounds:  bounds,
+		buckets: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.buckets) {
+		h.buckets[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) Collect(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.writeSamples(w)
+}
+
+func (h *Histogram) writeSamples(w io.Writer) {
+	h.mu.Lock()
+	buckets := append([]uint64(nil), h.buckets...)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, b := range h.bounds {
+		cumulative += buckets[i]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(h.labels, "le", formatFloat(b)), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(h.labels, "le", "+Inf"), count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labels, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels, count)
+}
+
+// HistogramVec is a family of histograms partitioned by label values
+type HistogramVec struct {
+	name       string
+	help       string
+	labelNames []string
+	buckets    []float64
+	mu         sync.Mutex
+	children   map[string]*Histogram
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		buckets:    buckets,
+		children:   make(map[string]*Histogram),
+	}
+}
+
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	labels := formatLabels(v.labelNames, values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h, ok := v.children[labels]
+	if !ok {
+		h = newHistogram(v.name, "", labels, v.buckets)
+		v.children[labels] = h
+	}
+	return h
+}
+
+func (v *HistogramVec) Collect(w io.Writer) {
+	writeHeader(w, v.name, v.help, "histogram")
+	v.mu.Lock()
+	keys := sortedKeys(v.children)
+	children := make([]*Histogram, len(keys))
+	for i, k := range keys {
+		children[i] = v.children[k]
+	}
+	v.mu.Unlock()
+	for _, h := range children {
+		h.writeSamples(w)
+	}
+}
+
+// GaugeFunc reports the value returned by fn at scrape time
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return &GaugeFunc{name: name, help: help, fn: fn}
+}
+
+func (g *GaugeFunc) Collect(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+// Sample is one labelled value reported by a GaugeVecFunc
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// GaugeVecFunc reports the labelled values returned by fn at scrape time, for
+// series that come and go between scrapes, such as the busiest keys
+type GaugeVecFunc struct {
+	name       string
+	help       string
+	labelNames []string
+	fn         func() []Sample
+}
+
+func NewGaugeVecFunc(name, help string, fn func() []Sample, labelNames ...string) *GaugeVecFunc {
+	return &GaugeVecFunc{name: name, help: help, labelNames: labelNames, fn: fn}
+}
+
+func (g *GaugeVecFunc) Collect(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	for _, s := range g.fn() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labelNames, s.Labels), formatFloat(s.Value))
+	}
+}
+
+// CounterFunc reports a counter maintained elsewhere, read at scrape time
+type CounterFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func NewCounterFunc(name, help string, fn func() float64) *CounterFunc {
+	return &CounterFunc{name: name, help: help, fn: fn}
+}
+
+func (c *CounterFunc) Collect(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %s\n", c.name, formatFloat(c.fn()))
+}
+
+// DefaultLatencyBuckets are sized for in-process decisions, in seconds
+var DefaultLatencyBuckets = []float64{
+	0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1,
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	if help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) != len(values) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(names), len(values)))
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// withLabel appends one more label to an already formatted label set
+func withLabel(labels, name, value string) string {
+	extra := name + `="` + escapeLabel(value) + `"`
+	if labels == "" {
+		return "{" + extra + "}"
+	}
+	return labels[:len(labels)-1] + "," + extra + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
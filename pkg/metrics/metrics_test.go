@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	decisions := NewCounterVec("decisions_total", "Decisions.", "policy", "outcome")
+	latency := NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	keys := NewGaugeFunc("keys", "Keys.", func() float64 { return 42 })
+	r.Register(decisions, latency, keys)
+
+	decisions.WithLabelValues("tokenbucket", "allowed").Add(3)
+	decisions.WithLabelValues("tokenbucket", "denied").Inc()
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(5)
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP decisions_total Decisions.
+# TYPE decisions_total counter
+decisions_total{policy="tokenbucket",outcome="allowed"} 3
+decisions_total{policy="tokenbucket",outcome="denied"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+# HELP keys Keys.
+# TYPE keys gauge
+keys 42
+`
+	if b.String() != want {
+		t.Errorf("Unexpected exposition:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	c := NewCounterVec("c", "", "key")
+	c.WithLabelValues("a\"b\\c\nd").Inc()
+	var b strings.Builder
+	c.Collect(&b)
+	if !strings.Contains(b.String(), `c{key="a\"b\\c\nd"} 1`) {
+		t.Errorf("Label not escaped: %s", b.String())
+	}
+}
+
+func TestGaugeVecFunc(t *testing.T) {
+	samples := []Sample{{Labels: []string{"a"}, Value: 3}, {Labels: []string{"b"}, Value: 1}}
+	g := NewGaugeVecFunc("top", "Top.", func() []Sample { return samples }, "key")
+	var b strings.Builder
+	g.Collect(&b)
+	want := `# HELP top Top.
+# TYPE top gauge
+top{key="a"} 3
+top{key="b"} 1
+`
+	if b.String() != want {
+		t.Errorf("Unexpected exposition:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
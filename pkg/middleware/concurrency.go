@@ -0,0 +1,63 @@
+// Package middleware holds net/http middleware built on the limiters
+package middleware
+
+import (
+	"net/http"
+
+	"RateLimiterService/pkg/concurrency"
+)
+
+// Acquirer hands out slots under a concurrency limit. *concurrency.Limiter
+// and *service.RateLimitService both implement it.
+type Acquirer interface {
+	Acquire() (*concurrency.Token, bool)
+}
+
+// Concurrency sheds requests with 503 Service Unavailable while the limit is
+// reached, and reports how long the rest took. Responses of 503 or 504 from
+// next count as drops, as they usually mean whatever next called was
+// overloaded too; requests that panic are not counted.
+func Concurrency(l Acquirer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := l.Acquire()
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests in flight", http.StatusServiceUnavailable)
+			return
+		}
+		// Runs first if next panics; otherwise a no-op after Done or Dropped
+		defer token.Ignore()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		if sw.status == http.StatusServiceUnavailable || sw.status == http.StatusGatewayTimeout {
+			token.Dropped()
+		} else {
+			token.Done()
+		}
+	})
+}
+
+// statusWriter records the status a handler responded with
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.status, w.wrote = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
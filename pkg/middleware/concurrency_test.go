@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/concurrency"
+)
+
+func TestConcurrencyShedsOverLimit(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	l := concurrency.NewLimiter(concurrency.NewVegas(concurrency.VegasOptions{Initial: 1}), c)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := Concurrency(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		done <- rec
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 503 with Retry-After over the limit, got %d", rec.Code)
+	}
+
+	close(release)
+	if rec := <-done; rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected the admitted request through, got %d", rec.Code)
+	}
+	if st := l.Stats(); st.InFlight != 0 || st.Rejected != 1 || st.Dropped != 1 {
+		t.Errorf("Expected the 504 counted as a drop, got %+v", st)
+	}
+}
@@ -0,0 +1,176 @@
+package ratelimiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+// Feedback is a client's report on one call to the backend the limiter
+// protects
+type Feedback struct {
+	Error   bool
+	Latency time.Duration // 0 if not measured
+}
+
+// AIMDOptions configures an AIMD limiter. Zero fields take the defaults in
+// brackets.
+type AIMDOptions struct {
+	Min int64 // lowest rate, in tokens per second [1]
+	// Max is the highest rate [the bucket's rate when wrapped, so the limiter
+	// only backs off from the configured rate and recovers to it]
+	Max      int64
+	Increase int64         // added to the rate after each healthy interval [1]
+	Decrease float64       // multiplies the rate after each unhealthy interval [0.5]
+	Interval time.Duration // how often the rate is adjusted [1s]
+	// ErrorRate is the share of bad reports above which an interval is
+	// unhealthy [0.05]
+	ErrorRate float64
+	// Latency, if set, makes reports slower than it count as bad
+	Latency time.Duration
+}
+
+// AIMDStats describes an AIMD limiter's adjustments
+type AIMDStats struct {
+	Rate      int64  // current effective rate, in tokens per second
+	Good      uint64 // healthy reports received
+	Bad       uint64 // errors and slow responses reported
+	Increases uint64 // intervals that raised the rate
+	Decreases uint64 // intervals that cut it
+}
+
+// AIMD adapts a TokenBucket's refill rate to the health of the backend it
+// protects, the way TCP adapts its window to congestion. Clients report the
+// outcome of their calls with Feedback. At the end of each interval with any
+// reports, the rate rises by Increase if few enough were bad, and is
+// multiplied by Decrease otherwise, within [Min, Max]. Intervals without
+// reports leave it alone: silence says nothing about the backend.
+//
+// The rate is shared by every key; the bucket's capacity, and so each key's
+// burst, stays as configured.
+type AIMD struct {
+	bucket *TokenBucket
+	opts   AIMDOptions
+	clock  clock.Clock
+
+	// next is when the current interval ends, in Unix nanoseconds, read
+	// without mu so checks only lock once per interval
+	next atomic.Int64
+	mu   sync.Mutex
+	good uint64 // reports in the current interval
+	bad  uint64
+
+	totalGood atomic.Uint64
+	totalBad  atomic.Uint64
+	increases atomic.Uint64
+	decreases atomic.Uint64
+}
+
+// NewAIMD wraps bucket, starting from its rate clamped to the bounds
+func NewAIMD(bucket *TokenBucket, opts AIMDOptions, clock clock.Clock) *AIMD {
+	if opts.Min <= 0 {
+		opts.Min = 1
+	}
+	if opts.Max <= 0 {
+		opts.Max = bucket.Rate()
+	}
+	opts.Max = max(opts.Max, opts.Min)
+	if opts.Increase <= 0 {
+		opts.Increase = 1
+	}
+	if opts.Decrease <= 0 || opts.Decrease >= 1 {
+		opts.Decrease = 0.5
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.ErrorRate <= 0 {
+		opts.ErrorRate = 0.05
+	}
+	bucket.SetRate(min(max(bucket.Rate(), opts.Min), opts.Max))
+	a := &AIMD{bucket: bucket, opts: opts, clock: clock}
+	a.next.Store(clock.Now().Add(opts.Interval).UnixNano())
+	return a
+}
+
+func (a *AIMD) Allow(key string) (bool, int64) {
+	return a.AllowPriority(key, PriorityDefault)
+}
+
+func (a *AIMD) AllowPriority(key string, p Priority) (bool, int64) {
+	a.adjust(a.clock.Now())
+	return a.bucket.AllowPriority(key, p)
+}
+
+// Combine implements Combiner as the token bucket does
+func (a *AIMD) Combine(x, y interface{}) interface{} {
+	return a.bucket.Combine(x, y)
+}
+
+// Feedback records the outcome of one call to the backend
+func (a *AIMD) Feedback(f Feedback) {
+	now := a.clock.Now()
+	bad := f.Error || (a.opts.Latency > 0 && f.Latency > a.opts.Latency)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.adjustLocked(now)
+	if bad {
+		a.bad++
+		a.totalBad.Add(1)
+	} else {
+		a.good++
+		a.totalGood.Add(1)
+	}
+}
+
+// Rate returns the current effective rate
+func (a *AIMD) Rate() int64 {
+	return a.bucket.Rate()
+}
+
+// Options returns the options in effect, with defaults filled in
+func (a *AIMD) Options() AIMDOptions {
+	return a.opts
+}
+
+func (a *AIMD) Stats() AIMDStats {
+	return AIMDStats{
+		Rate:      a.bucket.Rate(),
+		Good:      a.totalGood.Load(),
+		Bad:       a.totalBad.Load(),
+		Increases: a.increases.Load(),
+		Decreases: a.decreases.Load(),
+	}
+}
+
+func (a *AIMD) adjust(now time.Time) {
+	if now.UnixNano() < a.next.Load() {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.adjustLocked(now)
+}
+
+// adjustLocked ends the current interval if now is past it, applying its
+// reports. Intervals that passed with no checks or reports are skipped.
+func (a *AIMD) adjustLocked(now time.Time) {
+	if now.UnixNano() < a.next.Load() {
+		return
+	}
+	if n := a.good + a.bad; n > 0 {
+		rate := a.bucket.Rate()
+		if float64(a.bad)/float64(n) > a.opts.ErrorRate {
+			rate = max(int64(float64(rate)*a.opts.Decrease), a.opts.Min)
+			a.decreases.Add(1)
+		} else {
+			rate = min(rate+a.opts.Increase, a.opts.Max)
+			a.increases.Add(1)
+		}
+		a.bucket.SetRate(rate)
+	}
+	a.good, a.bad = 0, 0
+	a.next.Store(now.Add(a.opts.Interval).UnixNano())
+}
@@ -0,0 +1,103 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/store"
+)
+
+func newTestAIMD(t *testing.T, rate int64, opts AIMDOptions) (*clock.FakeClock, *AIMD) {
+	t.Helper()
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	s := store.NewInMemoryStoreWithOptions(store.Options{TTL: time.Hour, Clock: c})
+	t.Cleanup(s.Close)
+	return c, NewAIMD(NewTokenBucket(10, rate, c, s), opts, c)
+}
+
+// report sends good and bad reports, then ends the interval
+func report(c *clock.FakeClock, a *AIMD, good, bad int) {
+	for i := 0; i < good; i++ {
+		a.Feedback(Feedback{Latency: 10 * time.Millisecond})
+	}
+	for i := 0; i < bad; i++ {
+		a.Feedback(Feedback{Error: true})
+	}
+	c.Advance(time.Second)
+	a.Allow("tick")
+}
+
+func TestAIMDIncreasesAdditivelyDecreasesMultiplicatively(t *testing.T) {
+	c, a := newTestAIMD(t, 20, AIMDOptions{Min: 2, Max: 25, Increase: 2})
+
+	report(c, a, 100, 1) // 1% errors is healthy
+	if a.Rate() != 22 {
+		t.Fatalf("Expected 22 after a healthy interval, got %d", a.Rate())
+	}
+	report(c, a, 10, 0)
+	report(c, a, 10, 0)
+	if a.Rate() != 25 {
+		t.Errorf("Expected the rate capped at 25, got %d", a.Rate())
+	}
+
+	report(c, a, 80, 20)
+	if a.Rate() != 12 {
+		t.Errorf("Expected the rate halved to 12, got %d", a.Rate())
+	}
+	for i := 0; i < 5; i++ {
+		report(c, a, 0, 1)
+	}
+	if a.Rate() != 2 {
+		t.Errorf("Expected the rate floored at 2, got %d", a.Rate())
+	}
+
+	// Silence leaves the rate alone
+	c.Advance(10 * time.Second)
+	a.Allow("tick")
+	if st := a.Stats(); st.Rate != 2 || st.Increases != 3 || st.Decreases != 6 || st.Bad != 26 {
+		t.Errorf("Unexpected stats %+v", st)
+	}
+}
+
+func TestAIMDCountsSlowResponsesAsBad(t *testing.T) {
+	c, a := newTestAIMD(t, 10, AIMDOptions{Latency: 100 * time.Millisecond})
+	if a.Options().Max != 10 {
+		t.Fatalf("Expected Max to default to the bucket's rate, got %d", a.Options().Max)
+	}
+	for i := 0; i < 10; i++ {
+		a.Feedback(Feedback{Latency: 300 * time.Millisecond})
+	}
+	c.Advance(time.Second)
+	a.Allow("k")
+	if a.Rate() != 5 {
+		t.Errorf("Expected slow responses to halve the rate, got %d", a.Rate())
+	}
+}
+
+func TestAIMDRateGovernsRefill(t *testing.T) {
+	c, a := newTestAIMD(t, 10, AIMDOptions{})
+	for i := 0; i < 10; i++ {
+		a.Allow("k")
+	}
+	report(c, a, 0, 5) // halves the rate
+	if ok, _ := a.Allow("k"); !ok {
+		t.Fatal("Expected a refilled token")
+	}
+	for {
+		if ok, _ := a.Allow("k"); !ok {
+			break
+		}
+	}
+	// Now refilling at 5/s
+	c.Advance(time.Second)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := a.Allow("k"); ok {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("Expected 5 tokens refilled at the reduced rate, got %d", allowed)
+	}
+}
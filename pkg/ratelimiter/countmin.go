@@ -0,0 +1,116 @@
+package ratelimiter
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/sketch"
+)
+
+// windowPosition returns the index of the fixed window of size window
+// holding now, counted from the Unix epoch, and how far through it now is,
+// from 0 to 1
+func windowPosition(now time.Time, window time.Duration) (int64, float64) {
+	ns := now.UnixNano()
+	size := int64(window)
+	return ns / size, float64(ns%size) / float64(size)
+}
+
+// CountMinWindow approximates a sliding window per key in fixed memory. It
+// counts admitted requests in a Count-Min Sketch for the current fixed window
+// and keeps the previous window's, estimating like CRDTWindow: the previous
+// count weighted by how much of it the sliding window still covers, plus the
+// current one. Keys share counters, so the sketch can only over-count, by at
+// most epsilon × the requests admitted per window with probability 1-delta.
+// The weighting assumes the previous window's requests were spread evenly,
+// though, so a key whose requests bunched up late in it is under-counted and
+// may be admitted past its limit, as with any two-window estimate.
+//
+// It keeps nothing per key, so memory stays the same however many keys it
+// sees. That makes it a cheap first line in front of an exact limiter, with
+// a limit above the exact one, to shed requests for keys far over their
+// limit before they reach the store. It does nothing against many distinct
+// keys: each one's first requests are under the limit and pass.
+type CountMinWindow struct {
+	window time.Duration
+	limit  int
+	clock  clock.Clock
+
+	// mu is held for writing only to rotate the sketches
+	mu sync.RWMutex
+	// stripes serialise the estimate and add for each key, so concurrent
+	// requests can't all see room for one more. Other keys' adds to shared
+	// counters only raise the estimate, so they need no lock.
+	stripes [countMinStripes]sync.Mutex
+
+	idx    atomic.Int64
+	curr   *sketch.CountMin
+	prev   *sketch.CountMin
+	denied atomic.Uint64
+}
+
+const countMinStripes = 64
+
+func NewCountMinWindow(window time.Duration, limit int, epsilon, delta float64, clock clock.Clock) *CountMinWindow {
+	return &CountMinWindow{
+		window: window,
+		limit:  limit,
+		clock:  clock,
+		curr:   sketch.NewCountMin(epsilon, delta),
+		prev:   sketch.NewCountMin(epsilon, delta),
+	}
+}
+
+func (w *CountMinWindow) Allow(key string) (bool, int64) {
+	idx, elapsed := windowPosition(w.clock.Now(), w.window)
+	w.rotate(idx)
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	stripe := &w.stripes[h.Sum32()%countMinStripes]
+	stripe.Lock()
+	defer stripe.Unlock()
+	est := float64(w.prev.Estimate(key))*(1-elapsed) + float64(w.curr.Estimate(key))
+	if est+1 > float64(w.limit) {
+		w.denied.Add(1)
+		return false, 0
+	}
+	w.curr.Add(key, 1)
+	return true, int64(float64(w.limit) - est - 1)
+}
+
+// rotate moves the sketches on to window idx. Like CRDTWindow, it never goes
+// back to an earlier window.
+func (w *CountMinWindow) rotate(idx int64) {
+	if idx <= w.idx.Load() {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch cur := w.idx.Load(); {
+	case idx <= cur:
+		return
+	case idx == cur+1:
+		w.prev, w.curr = w.curr, w.prev
+		w.curr.Reset()
+	default:
+		w.prev.Reset()
+		w.curr.Reset()
+	}
+	w.idx.Store(idx)
+}
+
+// Denied returns how many requests the limiter has turned away
+func (w *CountMinWindow) Denied() uint64 {
+	return w.denied.Load()
+}
+
+// Size returns the bytes the sketches take, which never changes
+func (w *CountMinWindow) Size() int {
+	return w.curr.Size() + w.prev.Size()
+}
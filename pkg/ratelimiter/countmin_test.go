@@ -0,0 +1,71 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+func TestCountMinWindowSlides(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0)) // a multiple of 10s
+	w := NewCountMinWindow(10*time.Second, 10, 0.001, 0.01, c)
+
+	for i := 0; i < 10; i++ {
+		if ok, _ := w.Allow("k"); !ok {
+			t.Fatalf("Request %d denied", i)
+		}
+	}
+	if ok, _ := w.Allow("k"); ok {
+		t.Fatal("Expected the 11th request to be denied")
+	}
+	if ok, _ := w.Allow("other"); !ok {
+		t.Error("Expected other keys to be unaffected")
+	}
+
+	// Halfway through the next window, half of the last one still counts
+	c.Advance(15 * time.Second)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := w.Allow("k"); ok {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("Expected 5 allowed with half the previous window counted, got %d", allowed)
+	}
+	if w.Denied() != 6 {
+		t.Errorf("Expected 6 denials, got %d", w.Denied())
+	}
+
+	c.Advance(20 * time.Second)
+	if ok, remaining := w.Allow("k"); !ok || remaining != 9 {
+		t.Errorf("Expected a fresh window, got %v with %d remaining", ok, remaining)
+	}
+}
+
+func TestCountMinWindowFixedMemoryUnderFlood(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	w := NewCountMinWindow(time.Second, 5, 0.001, 0.01, c)
+	size := w.Size()
+
+	// A flood of spoofed keys, each sent a few times, alongside one real client
+	for i := 0; i < 200000; i++ {
+		w.Allow(fmt.Sprintf("spoofed-%d", i%50000))
+	}
+	if w.Size() != size {
+		t.Errorf("Size grew from %d to %d", size, w.Size())
+	}
+	// At most epsilon × 50000 admitted = 50 collisions' worth of over-count
+	// for the real client, which may cost it its quota but never adds to it
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := w.Allow("client"); ok {
+			allowed++
+		}
+	}
+	if allowed > 5 {
+		t.Errorf("Allowed %d requests against a limit of 5", allowed)
+	}
+}
@@ -0,0 +1,254 @@
+package ratelimiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/crdt"
+	"RateLimiterService/pkg/store"
+)
+
+// CRDTWindowState holds a key's counts for the current fixed window and the
+// one before it, one slot per node
+type CRDTWindowState struct {
+	Window int64 // index of the current window, counted from the Unix epoch
+	Curr   crdt.GCounter
+	Prev   crdt.GCounter
+}
+
+// counterSlotSize approximates one node's slot in a GCounter map
+const counterSlotSize = 48
+
+// Size implements store.Sizer
+func (s CRDTWindowState) Size() int {
+	return int(unsafe.Sizeof(s)) + (len(s.Curr)+len(s.Prev))*counterSlotSize
+}
+
+// advance moves the state to window idx. Windows never go backwards, so a
+// clock that steps back keeps counting into the window it had reached.
+func (s CRDTWindowState) advance(idx int64) CRDTWindowState {
+	switch {
+	case idx <= s.Window:
+		return s
+	case idx == s.Window+1:
+		return CRDTWindowState{Window: idx, Prev: s.Curr}
+	}
+	return CRDTWindowState{Window: idx}
+}
+
+// estimate weights the previous window by how much of it the sliding window
+// still covers, assuming its requests were spread evenly
+func (s CRDTWindowState) estimate(elapsed float64) float64 {
+	return float64(s.Prev.Value())*(1-elapsed) + float64(s.Curr.Value())
+}
+
+// CounterDelta is one node's count for a key in one window. Deltas are
+// idempotent: applying one twice, or after a newer one, changes nothing.
+type CounterDelta struct {
+	Key    string `json:"key"`
+	Window int64  `json:"window"`
+	Node   string `json:"node"`
+	Count  uint64 `json:"count"`
+}
+
+// Replicated is implemented by limiters that count in CRDTs on every node and
+// converge by exchanging deltas, rather than deciding each key on one node
+type Replicated interface {
+	RateLimiter
+	// Deltas returns this node's counts for the keys it has admitted
+	// requests for since the last call
+	Deltas() []CounterDelta
+	// Merge applies a delta received from another node
+	Merge(d CounterDelta)
+	// SetNodes tells the limiter how many nodes share the limit. The first
+	// call marks it as synced; until then it counts as a single node.
+	SetNodes(n int)
+	// Flush is signalled when a key has used up this node's error budget,
+	// and deltas should be sent before the next scheduled sync
+	Flush() <-chan struct{}
+}
+
+// CRDTWindow approximates a sliding window shared by several nodes without
+// any of them coordinating a decision. Each node counts the requests it
+// admits into its own slot of a per-key G-counter for the current fixed
+// window, and the estimate for the sliding window is the previous window's
+// total, weighted by how much of it is still covered, plus the current one's.
+// Totals include other nodes' slots as of their last delta, so between syncs
+// a node underestimates by whatever the others admitted since.
+//
+// The error budget bounds that: each node admits at most
+// budget × limit / nodes requests per key between syncs, after which it
+// denies the key and asks for an early sync. Across the cluster a key can
+// then exceed its limit by at most budget × limit. A budget of 0 leaves the
+// overshoot bounded only by the sync interval. Until SetNodes is first
+// called there is nothing to sync with, and the budget doesn't apply.
+// Admissions count against the budget once stored, so concurrent checks of
+// one key may overrun it by as many as are in flight.
+type CRDTWindow struct {
+	window time.Duration
+	limit  int
+	node   string
+	budget float64
+	clock  clock.Clock
+	store  store.Store
+
+	nodes  atomic.Int64
+	synced atomic.Bool
+	mu     sync.Mutex
+	dirty  map[string]dirtyCount // admitted per key since the last Deltas
+	flush  chan struct{}
+}
+
+// dirtyCount is the requests admitted for a key in window since the last
+// Deltas
+type dirtyCount struct {
+	window int64
+	n      int
+}
+
+// NewCRDTWindow returns a limiter admitting limit requests per window for
+// each key across all nodes, counting this node's requests as node
+func NewCRDTWindow(window time.Duration, limit int, node string, budget float64, clock clock.Clock, store store.Store) *CRDTWindow {
+	w := &CRDTWindow{
+		window: window,
+		limit:  limit,
+		node:   node,
+		budget: budget,
+		clock:  clock,
+		store:  store,
+		dirty:  make(map[string]dirtyCount),
+		flush:  make(chan struct{}, 1),
+	}
+	w.nodes.Store(1)
+	return w
+}
+
+// position returns the index of the fixed window holding now and how far
+// through it now is, from 0 to 1
+func (w *CRDTWindow) position(now time.Time) (int64, float64) {
+	return windowPosition(now, w.window)
+}
+
+func (w *CRDTWindow) Allow(key string) (bool, int64) {
+	idx, elapsed := w.position(w.clock.Now())
+
+	var allowed, overBudget bool
+	var remaining int64
+	err := store.TryUpdate(w.store, key, func(old interface{}, exists bool) interface{} {
+		var state CRDTWindowState
+		if exists {
+			state = old.(CRDTWindowState)
+		}
+		state = state.advance(idx)
+
+		allowed, overBudget, remaining = false, false, 0
+		est := state.estimate(elapsed)
+		if est+1 > float64(w.limit) {
+			return state
+		}
+		if overBudget = !w.withinBudget(key, idx); overBudget {
+			return state
+		}
+		state.Curr = state.Curr.Inc(w.node, 1)
+		allowed, remaining = true, int64(float64(w.limit)-est-1)
+		return state
+	})
+	if err != nil {
+		return storeFailed(w.store)
+	}
+	switch {
+	case allowed:
+		w.reserve(key, idx)
+	case overBudget:
+		select {
+		case w.flush <- struct{}{}:
+		default:
+		}
+	}
+	return allowed, remaining
+}
+
+// withinBudget reports whether the key has some of its share of the error
+// budget left in window idx
+func (w *CRDTWindow) withinBudget(key string, idx int64) bool {
+	if !w.synced.Load() || w.budget <= 0 {
+		return true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var n int
+	if d := w.dirty[key]; d.window == idx {
+		// The budget starts afresh with each window
+		n = d.n
+	}
+	return n < max(int(w.budget*float64(w.limit)/float64(w.nodes.Load())), 1)
+}
+
+// reserve counts an admission in window idx against the key's share of the
+// error budget. Standalone, nothing reads the counts, so none are kept.
+func (w *CRDTWindow) reserve(key string, idx int64) {
+	if !w.synced.Load() {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var n int
+	if d := w.dirty[key]; d.window == idx {
+		n = d.n
+	}
+	w.dirty[key] = dirtyCount{window: idx, n: n + 1}
+}
+
+func (w *CRDTWindow) Deltas() []CounterDelta {
+	w.mu.Lock()
+	dirty := w.dirty
+	w.dirty = make(map[string]dirtyCount)
+	w.mu.Unlock()
+
+	var out []CounterDelta
+	for key := range dirty {
+		v, ok := store.Peek(w.store, key)
+		if !ok {
+			continue
+		}
+		state := v.(CRDTWindowState)
+		out = append(out, CounterDelta{Key: key, Window: state.Window, Node: w.node, Count: state.Curr.Get(w.node)})
+		// Requests admitted just before the window turned
+		if n := state.Prev.Get(w.node); n > 0 {
+			out = append(out, CounterDelta{Key: key, Window: state.Window - 1, Node: w.node, Count: n})
+		}
+	}
+	return out
+}
+
+func (w *CRDTWindow) Merge(d CounterDelta) {
+	if d.Node == w.node {
+		return
+	}
+	w.store.Update(d.Key, func(old interface{}, exists bool) interface{} {
+		var state CRDTWindowState
+		if exists {
+			state = old.(CRDTWindowState)
+		}
+		state = state.advance(d.Window)
+		switch d.Window {
+		case state.Window:
+			state.Curr = state.Curr.Observe(d.Node, d.Count)
+		case state.Window - 1:
+			state.Prev = state.Prev.Observe(d.Node, d.Count)
+		}
+		return state
+	})
+}
+
+func (w *CRDTWindow) SetNodes(n int) {
+	w.nodes.Store(int64(max(n, 1)))
+	w.synced.Store(true)
+}
+
+func (w *CRDTWindow) Flush() <-chan struct{} {
+	return w.flush
+}
@@ -0,0 +1,225 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/store"
+)
+
+func newCRDTNode(t *testing.T, c clock.Clock, node string, limit int, budget float64) *CRDTWindow {
+	t.Helper()
+	s := store.NewInMemoryStoreWithOptions(store.Options{TTL: time.Hour, Clock: c})
+	t.Cleanup(s.Close)
+	return NewCRDTWindow(10*time.Second, limit, node, budget, c, s)
+}
+
+// exchange sends each limiter's deltas to every other
+func exchange(nodes ...*CRDTWindow) {
+	for _, from := range nodes {
+		for _, d := range from.Deltas() {
+			for _, to := range nodes {
+				to.Merge(d)
+			}
+		}
+	}
+}
+
+func TestCRDTWindowSlidesLikeAWindowCounter(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0)) // a multiple of 10s
+	w := newCRDTNode(t, c, "n1", 10, 0)
+
+	for i := 0; i < 10; i++ {
+		if ok, _ := w.Allow("k"); !ok {
+			t.Fatalf("Request %d denied", i)
+		}
+	}
+	if ok, _ := w.Allow("k"); ok {
+		t.Fatal("Expected the 11th request to be denied")
+	}
+
+	// Halfway through the next window, half of the last one still counts
+	c.Advance(15 * time.Second)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := w.Allow("k"); ok {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("Expected 5 allowed with half the previous window counted, got %d", allowed)
+	}
+
+	c.Advance(20 * time.Second)
+	if ok, remaining := w.Allow("k"); !ok || remaining != 9 {
+		t.Errorf("Expected a fresh window, got %v with %d remaining", ok, remaining)
+	}
+}
+
+func TestCRDTWindowConvergesAcrossNodes(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	a := newCRDTNode(t, c, "a", 10, 0)
+	b := newCRDTNode(t, c, "b", 10, 0)
+	a.SetNodes(2)
+	b.SetNodes(2)
+
+	for i := 0; i < 4; i++ {
+		a.Allow("k")
+	}
+	for i := 0; i < 3; i++ {
+		b.Allow("k")
+	}
+	exchange(a, b)
+	// An out of date delta is harmless
+	b.Merge(CounterDelta{Key: "k", Window: 170000000, Node: "a", Count: 2})
+
+	for _, w := range []*CRDTWindow{a, b} {
+		if _, remaining := w.Allow("k"); remaining != 2 {
+			t.Errorf("Node %s has %d remaining, want 2 after 8 requests cluster-wide", w.node, remaining)
+		}
+	}
+	exchange(a, b)
+	if ok, _ := a.Allow("k"); !ok {
+		t.Fatal("Expected the 10th request to be allowed")
+	}
+	exchange(a, b)
+	for _, w := range []*CRDTWindow{a, b} {
+		if ok, _ := w.Allow("k"); ok {
+			t.Errorf("Node %s allowed an 11th request", w.node)
+		}
+	}
+}
+
+func TestCRDTWindowErrorBudgetCapsUnsyncedRequests(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	w := newCRDTNode(t, c, "a", 30, 0.3)
+	w.SetNodes(3)
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := w.Allow("k"); ok {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Fatalf("Expected this node's share of the budget (3) allowed before a sync, got %d", allowed)
+	}
+	select {
+	case <-w.Flush():
+	default:
+		t.Error("Expected an early sync to be requested")
+	}
+	if ok, _ := w.Allow("other"); !ok {
+		t.Error("The budget is per key")
+	}
+
+	if ds := w.Deltas(); len(ds) != 2 {
+		t.Fatalf("Expected deltas for both keys, got %+v", ds)
+	}
+	if ok, _ := w.Allow("k"); !ok {
+		t.Error("Expected a sync to restore the budget")
+	}
+}
+
+// retryingStore runs every update function twice, keeping the second
+// result, as a store retrying a conflicting write may
+type retryingStore struct {
+	store.Store
+}
+
+func (s retryingStore) Update(key string, fn func(old interface{}, exists bool) interface{}) {
+	s.Store.Update(key, func(old interface{}, exists bool) interface{} {
+		fn(old, exists)
+		return fn(old, exists)
+	})
+}
+
+func TestCRDTWindowRetriedUpdatesSpendBudgetOnce(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	s := store.NewInMemoryStoreWithOptions(store.Options{TTL: time.Hour, Clock: c})
+	t.Cleanup(s.Close)
+	w := NewCRDTWindow(10*time.Second, 30, "a", 0.3, c, retryingStore{s})
+	w.SetNodes(3)
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := w.Allow("k"); ok {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("Expected this node's share of the budget (3) allowed, got %d", allowed)
+	}
+	if ds := w.Deltas(); len(ds) != 1 || ds[0].Count != 3 {
+		t.Errorf("Expected one delta counting 3, got %+v", ds)
+	}
+}
+
+func TestCRDTWindowDeltasLeaveAccessTime(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	s := store.NewInMemoryStoreWithOptions(store.Options{TTL: time.Hour, Clock: c})
+	t.Cleanup(s.Close)
+	w := NewCRDTWindow(10*time.Second, 30, "a", 0, c, s)
+	w.SetNodes(2)
+
+	w.Allow("k")
+	checked := c.Now()
+	c.Advance(time.Second)
+	w.Deltas()
+	if e, _ := s.Export("k"); !e.LastAccess.Equal(checked) {
+		t.Errorf("Expected reading deltas to leave the access time at %v, got %v", checked, e.LastAccess)
+	}
+}
+
+func TestCRDTWindowStandaloneIgnoresBudget(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	w := newCRDTNode(t, c, "a", 100, 0.1)
+
+	// Nothing ever syncs a standalone node, so a budget would deny each
+	// key for good once spent
+	for window := 0; window < 3; window++ {
+		allowed := 0
+		for i := 0; i < 150; i++ {
+			if ok, _ := w.Allow("k"); ok {
+				allowed++
+			}
+		}
+		if allowed != 100 {
+			t.Fatalf("Window %d: expected the full limit of 100 allowed, got %d", window, allowed)
+		}
+		c.Advance(20 * time.Second)
+	}
+	if len(w.dirty) != 0 {
+		t.Errorf("Expected no counts kept for syncing, got %d keys", len(w.dirty))
+	}
+}
+
+func TestCRDTWindowBudgetResetsEachWindow(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	w := newCRDTNode(t, c, "a", 30, 0.3)
+	w.SetNodes(3)
+	for i := 0; i < 3; i++ {
+		w.Allow("k")
+	}
+	if ok, _ := w.Allow("k"); ok {
+		t.Fatal("Expected the budget spent")
+	}
+	c.Advance(20 * time.Second)
+	if ok, _ := w.Allow("k"); !ok {
+		t.Error("Expected a new window to restore the budget without a sync")
+	}
+}
+
+func TestCRDTWindowIgnoresStaleDeltas(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	w := newCRDTNode(t, c, "a", 10, 0)
+	idx, _ := w.position(c.Now())
+
+	w.Merge(CounterDelta{Key: "k", Window: idx, Node: "b", Count: 4})
+	w.Merge(CounterDelta{Key: "k", Window: idx, Node: "b", Count: 2})
+	w.Merge(CounterDelta{Key: "k", Window: idx - 5, Node: "c", Count: 9})
+	if _, remaining := w.Allow("k"); remaining != 5 {
+		t.Errorf("Expected 5 remaining after b's 4 requests, got %d", remaining)
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimiter
+
+import (
+	"time"
+	"unsafe"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/store"
+)
+
+// GCRAState holds the theoretical arrival time for a key: when the key's
+// bucket would next be empty if requests kept arriving at exactly the rate.
+// Last is when the key last admitted a request, which a SkewGuard measures
+// clock jumps from; TAT only bounds it.
+type GCRAState struct {
+	TAT  time.Time
+	Last time.Time
+}
+
+// Size implements store.Sizer
+func (s GCRAState) Size() int {
+	return int(unsafe.Sizeof(s))
+}
+
+// GCRA implements the generic cell rate algorithm. It enforces the same limit
+// as a token bucket of size burst refilled at rate, but keeps a timestamp per
+// key instead of a count.
+type GCRA struct {
+	burst int64
+	rate  int64
+	clock clock.Clock
+	store store.Store
+	skew  *SkewGuard
+}
+
+func NewGCRA(burst, rate int64, clock clock.Clock, store store.Store) *GCRA {
+	return &GCRA{
+		burst: burst,
+		rate:  rate,
+		clock: clock,
+		store: store,
+	}
+}
+
+// SetSkewGuard makes the limiter report clock jumps to sg and apply its
+// forward cap. Call it before the first Allow.
+func (g *GCRA) SetSkewGuard(sg *SkewGuard) {
+	g.skew = sg
+}
+
+// Combine implements Combiner, keeping the later theoretical arrival time
+func (g *GCRA) Combine(a, b interface{}) interface{} {
+	sa, ok1 := a.(GCRAState)
+	sb, ok2 := b.(GCRAState)
+	if ok1 && ok2 && sb.TAT.After(sa.TAT) {
+		return sb
+	}
+	return a
+}
+
+func (g *GCRA) Allow(key string) (bool, int64) {
+	now := g.clock.Now()
+	interval := time.Second / time.Duration(g.rate)
+	limit := interval * time.Duration(g.burst)
+
+	var allowed bool
+	var remaining int64
+	err := store.TryUpdate(g.store, key, func(old interface{}, exists bool) interface{} {
+		tat := now
+		rebased := false
+		if exists {
+			state := old.(GCRAState)
+			// If the clock jumped since the last allowed request, move TAT by
+			// the same amount, so a step back doesn't block the key. State
+			// saved without Last has only TAT-limit, the earliest that
+			// request can have been.
+			last := state.Last
+			if last.IsZero() {
+				last = state.TAT.Add(-limit)
+			}
+			if elapsed, clamped := g.skew.elapsed(last, now); clamped {
+				state.TAT = state.TAT.Add(now.Sub(last) - elapsed)
+				rebased = true
+			}
+			if state.TAT.After(now) {
+				tat = state.TAT
+			}
+		}
+
+		newTAT := tat.Add(interval)
+		if newTAT.Sub(now) > limit {
+			allowed, remaining = false, 0
+			if exists && !rebased {
+				return old
+			}
+			return GCRAState{TAT: tat, Last: now}
+		}
+		allowed, remaining = true, int64((limit-newTAT.Sub(now))/interval)
+		return GCRAState{TAT: newTAT, Last: now}
+	})
+	if err != nil {
+		return storeFailed(g.store)
+	}
+	return allowed, remaining
+}
@@ -0,0 +1,83 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"math"
+)
+
+// Priority ranks requests that share a key. Where capacity is reserved for
+// higher priorities, lower ones are denied first as it runs out.
+type Priority int
+
+const (
+	// PriorityBatch is for work that can wait: retried jobs, backfills
+	PriorityBatch Priority = iota - 1
+	// PriorityDefault is the zero value, for requests that don't say
+	PriorityDefault
+	// PriorityCritical is for interactive traffic that should be served
+	// while anything is left
+	PriorityCritical
+)
+
+// Priorities lists every priority, lowest first
+var Priorities = []Priority{PriorityBatch, PriorityDefault, PriorityCritical}
+
+// ParsePriority accepts "critical", "default" and "batch"; empty means default
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "", "default":
+		return PriorityDefault, nil
+	case "critical":
+		return PriorityCritical, nil
+	case "batch":
+		return PriorityBatch, nil
+	}
+	return 0, fmt.Errorf("invalid priority %q", s)
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityBatch:
+		return "batch"
+	case PriorityDefault:
+		return "default"
+	case PriorityCritical:
+		return "critical"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// MarshalText implements encoding.TextMarshaler, so priorities read as their
+// names in JSON
+func (p Priority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Priority) UnmarshalText(b []byte) error {
+	v, err := ParsePriority(string(b))
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
+
+// PriorityLimiter is implemented by limiters that can hold capacity back for
+// higher priorities. Allow is AllowPriority at PriorityDefault.
+type PriorityLimiter interface {
+	AllowPriority(key string, p Priority) (bool, int64)
+}
+
+// reservedFloors converts fractions of capacity reserved per priority into,
+// for each priority, the tokens it must leave in the bucket: those reserved
+// for the priorities above it
+func reservedFloors(capacity int64, reserved map[Priority]float64) map[Priority]int64 {
+	floors := make(map[Priority]int64, len(Priorities))
+	var above float64
+	for i := len(Priorities) - 1; i >= 0; i-- {
+		p := Priorities[i]
+		floors[p] = min(int64(math.Round(above*float64(capacity))), capacity)
+		above += max(reserved[p], 0)
+	}
+	return floors
+}
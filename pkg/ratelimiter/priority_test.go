@@ -0,0 +1,60 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/store"
+)
+
+func TestTokenBucketReservesCapacityForHigherPriorities(t *testing.T) {
+	s := store.NewInMemoryStore(time.Hour)
+	defer s.Close()
+	tb := NewTokenBucket(10, 1, frozenClock(), s)
+	tb.SetReserved(map[Priority]float64{PriorityCritical: 0.2, PriorityDefault: 0.3})
+
+	// Batch may spend down to the 5 tokens reserved above it
+	for want := int64(4); want >= 0; want-- {
+		if ok, remaining := tb.AllowPriority("k", PriorityBatch); !ok || remaining != want {
+			t.Fatalf("Batch: Allow = %v, %d; want true, %d", ok, remaining, want)
+		}
+	}
+	if ok, _ := tb.AllowPriority("k", PriorityBatch); ok {
+		t.Fatal("Expected batch denied at the reserve")
+	}
+	// Default down to critical's 2
+	for i := 0; i < 3; i++ {
+		if ok, _ := tb.Allow("k"); !ok {
+			t.Fatalf("Default request %d denied", i)
+		}
+	}
+	if ok, _ := tb.Allow("k"); ok {
+		t.Fatal("Expected default denied at critical's reserve")
+	}
+	// Critical takes the rest
+	for want := int64(1); want >= 0; want-- {
+		if ok, remaining := tb.AllowPriority("k", PriorityCritical); !ok || remaining != want {
+			t.Fatalf("Critical: Allow = %v, %d; want true, %d", ok, remaining, want)
+		}
+	}
+	if ok, _ := tb.AllowPriority("k", PriorityCritical); ok {
+		t.Error("Expected critical denied once the bucket is empty")
+	}
+}
+
+func TestPriorityJSON(t *testing.T) {
+	var req struct {
+		Priority Priority `json:"priority,omitempty"`
+	}
+	if err := json.Unmarshal([]byte(`{"priority":"batch"}`), &req); err != nil || req.Priority != PriorityBatch {
+		t.Fatalf("Expected batch, got %v (%v)", req.Priority, err)
+	}
+	if err := json.Unmarshal([]byte(`{"priority":"urgent"}`), &req); err == nil {
+		t.Error("Expected an unknown priority rejected")
+	}
+	req.Priority = PriorityDefault
+	if b, _ := json.Marshal(req); string(b) != `{}` {
+		t.Errorf("Expected the default omitted, got %s", b)
+	}
+}
@@ -1,15 +1,18 @@
 package ratelimiter
 
 import (
+	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"RateLimiterService/pkg/clock"
 	"RateLimiterService/pkg/store"
 )
 
 // Edge cases handled:
-// - Clock drift: Algorithms use elapsed time calculations, resilient to small drifts.
-// - Concurrent: Store handles locking; algorithms are stateless per call.
+// - Clock drift: Algorithms use elapsed time calculations, resilient to small drifts;
+//   a SkewGuard clamps time running backwards and optionally caps forward jumps.
+// - Concurrent: Each decision is one atomic Store.Update; algorithms are stateless per call.
 // - Memory: Per-key state is managed by Store; SlidingWindow filters old timestamps.
 
 // RateLimiter interface for different rate limiting algorithms
@@ -17,55 +20,157 @@ type RateLimiter interface {
 	Allow(key string) (bool, int64)
 }
 
+// storeFailed decides a request the store couldn't record: admitted if the
+// store fails open, denied otherwise
+func storeFailed(s store.Store) (bool, int64) {
+	if f, ok := s.(store.FailOpener); ok && f.FailOpen() {
+		return true, 0
+	}
+	return false, 0
+}
+
+// Inspector is implemented by limiters that keep per-key state outside the
+// Store they were given, e.g. in server-side data structures
+type Inspector interface {
+	State(key string) (interface{}, bool)
+}
+
+// Combiner is implemented by limiters that can reconcile two states written
+// for the same key independently, e.g. by the old and new owner of a key
+// while it moves between nodes. Combine returns whichever admits less, or
+// a merge admitting no more than either; a is the local state and wins when
+// the types don't match.
+type Combiner interface {
+	Combine(a, b interface{}) interface{}
+}
+
 // TokenBucketState holds the state for a key
 type TokenBucketState struct {
 	Tokens   int64
 	LastTime time.Time
 }
 
+// Size implements store.Sizer
+func (s TokenBucketState) Size() int {
+	return int(unsafe.Sizeof(s))
+}
+
 // TokenBucket implementation
 type TokenBucket struct {
 	capacity int64
-	rate     int64
+	rate     atomic.Int64
 	clock    clock.Clock
 	store    store.Store
+	skew     *SkewGuard
+	// floors holds the tokens each priority must leave for those above it
+	floors map[Priority]int64
 }
 
 func NewTokenBucket(capacity, rate int64, clock clock.Clock, store store.Store) *TokenBucket {
-	return &TokenBucket{
+	tb := &TokenBucket{
 		capacity: capacity,
-		rate:     rate,
 		clock:    clock,
 		store:    store,
 	}
+	tb.rate.Store(rate)
+	return tb
+}
+
+// SetRate changes the refill rate for every key. Time since a key last
+// refilled is credited at the new rate.
+func (tb *TokenBucket) SetRate(rate int64) {
+	tb.rate.Store(rate)
+}
+
+// Rate returns the current refill rate in tokens per second
+func (tb *TokenBucket) Rate() int64 {
+	return tb.rate.Load()
+}
+
+// SetSkewGuard makes the bucket report clock jumps to g and apply its
+// forward cap. Call it before the first Allow.
+func (tb *TokenBucket) SetSkewGuard(g *SkewGuard) {
+	tb.skew = g
+}
+
+// SetReserved sets aside fractions of each key's capacity for priorities:
+// tokens reserved for a priority can only be spent by it and those above it.
+// So as a bucket drains, batch requests are denied first, then default ones,
+// while critical ones may spend it dry. Reserving for batch has no effect.
+// Call it before the first Allow.
+func (tb *TokenBucket) SetReserved(reserved map[Priority]float64) {
+	tb.floors = reservedFloors(tb.capacity, reserved)
 }
 
 func (tb *TokenBucket) Allow(key string) (bool, int64) {
+	return tb.AllowPriority(key, PriorityDefault)
+}
+
+// AllowPriority spends a token unless doing so would dip into capacity
+// reserved for higher priorities than p. remaining counts the tokens left to
+// p.
+func (tb *TokenBucket) AllowPriority(key string, p Priority) (bool, int64) {
 	now := tb.clock.Now()
+	rate := tb.rate.Load()
+	floor := tb.floors[p]
 
-	val, exists := tb.store.Get(key)
-	var state TokenBucketState
-	if !exists {
-		state = TokenBucketState{Tokens: tb.capacity, LastTime: now}
-		tb.store.Set(key, state)
-		return true, tb.capacity - 1
-	}
-	state = val.(TokenBucketState)
+	var allowed bool
+	var remaining int64
+	err := store.TryUpdate(tb.store, key, func(old interface{}, exists bool) interface{} {
+		state := TokenBucketState{Tokens: tb.capacity, LastTime: now}
+		rebased := false
+		if exists {
+			state = old.(TokenBucketState)
+			elapsed, clamped := tb.skew.elapsed(state.LastTime, now)
+			tokensToAdd := elapsed.Nanoseconds() * rate / int64(time.Second)
+			state.Tokens += tokensToAdd
+			if state.Tokens > tb.capacity {
+				state.Tokens = tb.capacity
+			}
+			if clamped {
+				// Restart refill from now rather than from a timestamp we don't trust
+				state.LastTime, rebased = now, true
+			}
+		}
 
-	elapsed := now.Sub(state.LastTime)
-	tokensToAdd := elapsed.Nanoseconds() * tb.rate / int64(time.Second)
-	state.Tokens += tokensToAdd
-	if state.Tokens > tb.capacity {
-		state.Tokens = tb.capacity
+		if state.Tokens > floor {
+			state.Tokens--
+			state.LastTime = now
+			allowed, remaining = true, state.Tokens-floor
+			return state
+		}
+		allowed, remaining = false, 0
+		if exists && !rebased {
+			// Keep LastTime so the partial refill isn't lost
+			return old
+		}
+		return state
+	})
+	if err != nil {
+		return storeFailed(tb.store)
 	}
+	return allowed, remaining
+}
 
-	if state.Tokens > 0 {
-		state.Tokens--
-		state.LastTime = now
-		tb.store.Set(key, state)
-		return true, state.Tokens
+// Combine implements Combiner, keeping the state with fewer tokens once both
+// are refilled to now
+func (tb *TokenBucket) Combine(a, b interface{}) interface{} {
+	sa, ok1 := a.(TokenBucketState)
+	sb, ok2 := b.(TokenBucketState)
+	if !ok1 || !ok2 {
+		return a
 	}
-	return false, 0
+	now := tb.clock.Now()
+	if tb.refilled(sb, now) < tb.refilled(sa, now) {
+		return sb
+	}
+	return sa
+}
+
+// refilled is how many tokens s holds at now
+func (tb *TokenBucket) refilled(s TokenBucketState, now time.Time) int64 {
+	elapsed := max(0, now.Sub(s.LastTime))
+	return min(tb.capacity, s.Tokens+elapsed.Nanoseconds()*tb.rate.Load()/int64(time.Second))
 }
 
 // SlidingWindowState holds the timestamps for a key
@@ -73,12 +178,19 @@ type SlidingWindowState struct {
 	Requests []time.Time
 }
 
+// Size implements store.Sizer. It counts the slice's capacity, which is what
+// stays allocated.
+func (s SlidingWindowState) Size() int {
+	return int(unsafe.Sizeof(s)) + cap(s.Requests)*int(unsafe.Sizeof(time.Time{}))
+}
+
 // SlidingWindow implementation
 type SlidingWindow struct {
 	windowSize  time.Duration
 	maxRequests int
 	clock       clock.Clock
 	store       store.Store
+	skew        *SkewGuard
 }
 
 func NewSlidingWindow(windowSize time.Duration, maxRequests int, clock clock.Clock, store store.Store) *SlidingWindow {
@@ -90,31 +202,88 @@ func NewSlidingWindow(windowSize time.Duration, maxRequests int, clock clock.Clo
 	}
 }
 
+// SetSkewGuard makes the window report clock jumps to g and apply its
+// forward cap. Call it before the first Allow.
+func (sw *SlidingWindow) SetSkewGuard(g *SkewGuard) {
+	sw.skew = g
+}
+
 func (sw *SlidingWindow) Allow(key string) (bool, int64) {
 	now := sw.clock.Now()
 	windowStart := now.Add(-sw.windowSize)
 
-	val, exists := sw.store.Get(key)
-	var state SlidingWindowState
-	if !exists {
-		state = SlidingWindowState{Requests: []time.Time{}}
-	} else {
-		state = val.(SlidingWindowState)
+	var allowed bool
+	var remaining int64
+	err := store.TryUpdate(sw.store, key, func(old interface{}, exists bool) interface{} {
+		var state SlidingWindowState
+		if exists {
+			state = old.(SlidingWindowState)
+		}
+
+		// If the clock jumped since the newest request, shift the history onto
+		// the current clock so its spacing, not its absolute times, decides
+		var shift time.Duration
+		if n := len(state.Requests); n > 0 {
+			newest := state.Requests[n-1]
+			if elapsed, clamped := sw.skew.elapsed(newest, now); clamped {
+				shift = now.Sub(newest) - elapsed
+			}
+		}
+
+		// Remove old requests
+		validReqs := []time.Time{}
+		for _, t := range state.Requests {
+			t = t.Add(shift)
+			if t.After(windowStart) {
+				validReqs = append(validReqs, t)
+			}
+		}
+
+		allowed, remaining = false, 0
+		if len(validReqs) < sw.maxRequests {
+			validReqs = append(validReqs, now)
+			allowed, remaining = true, int64(sw.maxRequests-len(validReqs))
+		}
+		return SlidingWindowState{Requests: validReqs}
+	})
+	if err != nil {
+		return storeFailed(sw.store)
 	}
+	return allowed, remaining
+}
 
-	// Remove old requests
-	validReqs := []time.Time{}
-	for _, t := range state.Requests {
+// Combine implements Combiner with the union of both states' requests still
+// in the window
+func (sw *SlidingWindow) Combine(a, b interface{}) interface{} {
+	sa, ok1 := a.(SlidingWindowState)
+	sb, ok2 := b.(SlidingWindowState)
+	if !ok1 || !ok2 {
+		return a
+	}
+	windowStart := sw.clock.Now().Add(-sw.windowSize)
+	merged := make([]time.Time, 0, len(sa.Requests)+len(sb.Requests))
+	i, j := 0, 0
+	for i < len(sa.Requests) || j < len(sb.Requests) {
+		var t time.Time
+		switch {
+		case j == len(sb.Requests) || (i < len(sa.Requests) && sa.Requests[i].Before(sb.Requests[j])):
+			t, i = sa.Requests[i], i+1
+		case i == len(sa.Requests) || sb.Requests[j].Before(sa.Requests[i]):
+			t, j = sb.Requests[j], j+1
+		default:
+			// The same request, recorded before the states diverged
+			t, i, j = sa.Requests[i], i+1, j+1
+		}
 		if t.After(windowStart) {
-			validReqs = append(validReqs, t)
+			merged = append(merged, t)
 		}
 	}
+	return SlidingWindowState{Requests: merged}
+}
 
-	if len(validReqs) < sw.maxRequests {
-		validReqs = append(validReqs, now)
-		state.Requests = validReqs
-		sw.store.Set(key, state)
-		return true, int64(sw.maxRequests - len(validReqs))
-	}
-	return false, 0
-}
\ No newline at end of file
+func init() {
+	store.RegisterCodec("tokenbucket", TokenBucketState{}, store.JSONCodec[TokenBucketState]{})
+	store.RegisterCodec("slidingwindow", SlidingWindowState{}, store.JSONCodec[SlidingWindowState]{})
+	store.RegisterCodec("gcra", GCRAState{}, store.JSONCodec[GCRAState]{})
+	store.RegisterCodec("crdtwindow", CRDTWindowState{}, store.JSONCodec[CRDTWindowState]{})
+}
@@ -0,0 +1,172 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/resp"
+	"RateLimiterService/pkg/resp/resptest"
+	"RateLimiterService/pkg/store"
+)
+
+// frozenClock never advances, so nothing refills during a stress run
+func frozenClock() *clock.FakeClock {
+	return clock.NewFakeClock(time.Unix(1700000000, 0))
+}
+
+// hammer sends goroutines*perGoroutine concurrent requests for one key and
+// returns how many were allowed
+func hammer(l RateLimiter, goroutines, perGoroutine int) int64 {
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for i := 0; i < perGoroutine; i++ {
+				if ok, _ := l.Allow("hot"); ok {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return allowed.Load()
+}
+
+func TestConcurrentLimitsHold(t *testing.T) {
+	c := frozenClock()
+	const limit = 100
+
+	for name, mk := range map[string]func(store.Store) RateLimiter{
+		"tokenbucket":   func(s store.Store) RateLimiter { return NewTokenBucket(limit, 1, c, s) },
+		"slidingwindow": func(s store.Store) RateLimiter { return NewSlidingWindow(time.Minute, limit, c, s) },
+		"gcra":          func(s store.Store) RateLimiter { return NewGCRA(limit, 1, c, s) },
+		"countmin":      func(store.Store) RateLimiter { return NewCountMinWindow(time.Minute, limit, 0.001, 0.01, c) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := store.NewInMemoryStore(time.Hour)
+			defer s.Close()
+			if got := hammer(mk(s), 50, 20); got != limit {
+				t.Errorf("Expected exactly %d of 1000 concurrent requests allowed, got %d", limit, got)
+			}
+		})
+	}
+}
+
+func TestConcurrentLimitsHoldOnRedisUpdate(t *testing.T) {
+	srv, err := resptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	s := store.NewRedisStore(resp.NewClient(srv.Addr(), resp.Options{}), "rl:", time.Hour)
+	defer s.Close()
+
+	// Goes through Store.Update (WATCH/MULTI/EXEC), not the Lua scripts
+	tb := NewTokenBucket(20, 1, frozenClock(), s)
+	if got := hammer(tb, 8, 10); got != 20 {
+		t.Errorf("Expected exactly 20 allowed, got %d", got)
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Unexpected store error %v", err)
+	}
+}
+
+func TestLimitersFollowStoreFailurePolicy(t *testing.T) {
+	srv, err := resptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := srv.Addr()
+	srv.Close()
+
+	limiters := map[string]func(*store.RedisStore) RateLimiter{
+		"tokenbucket":        func(s *store.RedisStore) RateLimiter { return NewTokenBucket(10, 1, frozenClock(), s) },
+		"slidingwindow":      func(s *store.RedisStore) RateLimiter { return NewSlidingWindow(time.Minute, 10, frozenClock(), s) },
+		"gcra":               func(s *store.RedisStore) RateLimiter { return NewGCRA(10, 1, frozenClock(), s) },
+		"crdtwindow":         func(s *store.RedisStore) RateLimiter { return NewCRDTWindow(time.Minute, 10, "a", 0, frozenClock(), s) },
+		"redistokenbucket":   func(s *store.RedisStore) RateLimiter { return NewRedisTokenBucket(10, 1, s) },
+		"redisslidingwindow": func(s *store.RedisStore) RateLimiter { return NewRedisSlidingWindow(time.Minute, 10, s) },
+		"redisgcra":          func(s *store.RedisStore) RateLimiter { return NewRedisGCRA(10, 1, s) },
+	}
+	for _, failOpen := range []bool{false, true} {
+		for name, mk := range limiters {
+			t.Run(fmt.Sprintf("%s/failopen=%v", name, failOpen), func(t *testing.T) {
+				s := store.NewRedisStore(resp.NewClient(addr, resp.Options{}), "rl:", time.Hour)
+				defer s.Close()
+				s.SetFailOpen(failOpen)
+				// Nothing can be recorded, so the store's policy decides
+				if ok, _ := mk(s).Allow("k"); ok != failOpen {
+					t.Errorf("Allow = %v with the server down, want %v", ok, failOpen)
+				}
+				if _, scripted := mk(s).(Inspector); !scripted && s.Err() == nil {
+					t.Error("Expected the store to keep the error")
+				}
+			})
+		}
+	}
+}
+
+func TestTokenBucketFirstRequestConsumesToken(t *testing.T) {
+	s := store.NewInMemoryStore(time.Hour)
+	defer s.Close()
+	tb := NewTokenBucket(3, 1, frozenClock(), s)
+	for want := int64(2); want >= 0; want-- {
+		if ok, remaining := tb.Allow("k"); !ok || remaining != want {
+			t.Fatalf("Allow = %v, %d; want true, %d", ok, remaining, want)
+		}
+	}
+	if ok, _ := tb.Allow("k"); ok {
+		t.Error("Expected deny once capacity is used")
+	}
+}
+
+func TestCombineKeepsTheStricterState(t *testing.T) {
+	c := frozenClock()
+	now := c.Now()
+	s := store.NewInMemoryStore(time.Hour)
+	defer s.Close()
+
+	tb := NewTokenBucket(10, 1, c, s)
+	// 2 tokens plus 5 seconds of refill beats 4 tokens just now
+	older := TokenBucketState{Tokens: 2, LastTime: now.Add(-5 * time.Second)}
+	fresher := TokenBucketState{Tokens: 4, LastTime: now}
+	if got := tb.Combine(older, fresher); got != fresher {
+		t.Errorf("tokenbucket: got %v, want %v", got, fresher)
+	}
+
+	g := NewGCRA(10, 1, c, s)
+	later := GCRAState{TAT: now.Add(3 * time.Second)}
+	if got := g.Combine(GCRAState{TAT: now}, later); got != later {
+		t.Errorf("gcra: got %v, want %v", got, later)
+	}
+
+	sw := NewSlidingWindow(time.Minute, 10, c, s)
+	at := func(secs ...int) []time.Time {
+		out := make([]time.Time, len(secs))
+		for i, sec := range secs {
+			out[i] = now.Add(time.Duration(sec) * time.Second)
+		}
+		return out
+	}
+	got := sw.Combine(SlidingWindowState{Requests: at(-90, -30, -10)}, SlidingWindowState{Requests: at(-30, -20, -1)})
+	want := at(-30, -20, -10, -1)
+	if reqs := got.(SlidingWindowState).Requests; len(reqs) != len(want) {
+		t.Errorf("slidingwindow: got %v, want %v", reqs, want)
+	} else {
+		for i := range want {
+			if !reqs[i].Equal(want[i]) {
+				t.Errorf("slidingwindow: got %v, want %v", reqs, want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"RateLimiterService/pkg/resp"
+	"RateLimiterService/pkg/store"
+)
+
+// The Redis limiters run each decision as one Lua script, so the read, the
+// refill and the write are atomic across every limiter instance sharing the
+// server. Scripts read the server's TIME rather than the caller's clock so
+// that instances with skewed clocks still agree. Times are in microseconds.
+//
+// If the server can't be reached the limiters decide as the store's
+// FailOpen says, like the in-process ones: by default they admit, so an
+// outage of the shared store doesn't take down every caller. Readiness
+// reports it either way.
+
+var redisTokenBucketScript = resp.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil then
+  tokens = capacity
+  ts = now
+end
+local elapsed = math.max(0, now - ts)
+tokens = math.min(capacity, tokens + math.floor(elapsed * rate / 1000000))
+if tokens > 0 then
+  tokens = tokens - 1
+  redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+  redis.call('PEXPIRE', KEYS[1], ARGV[3])
+  return {1, tokens}
+end
+return {0, 0}
+`)
+
+var redisTokenBucketStateScript = resp.NewScript(`
+return redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+`)
+
+var redisSlidingWindowScript = resp.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local max = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - tonumber(ARGV[1]))
+local count = redis.call('ZCARD', KEYS[1])
+if count < max then
+  redis.call('ZADD', KEYS[1], now, ARGV[4])
+  redis.call('PEXPIRE', KEYS[1], ARGV[3])
+  return {1, max - count - 1}
+end
+return {0, 0}
+`)
+
+var redisSlidingWindowStateScript = resp.NewScript(`
+return redis.call('ZRANGE', KEYS[1], 0, -1, 'WITHSCORES')
+`)
+
+var redisGCRAScript = resp.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local limit = interval * tonumber(ARGV[2])
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+local newTat = math.max(tat, now) + interval
+if newTat - now > limit then
+  return {0, 0}
+end
+redis.call('SET', KEYS[1], newTat, 'PX', ARGV[3])
+return {1, math.floor((limit - (newTat - now)) / interval)}
+`)
+
+// RedisTokenBucket is a TokenBucket whose state lives on a Redis-compatible server
+type RedisTokenBucket struct {
+	capacity int64
+	rate     int64
+	store    *store.RedisStore
+}
+
+func NewRedisTokenBucket(capacity, rate int64, s *store.RedisStore) *RedisTokenBucket {
+	return &RedisTokenBucket{capacity: capacity, rate: rate, store: s}
+}
+
+func (tb *RedisTokenBucket) Allow(key string) (bool, int64) {
+	return runDecision(redisTokenBucketScript, tb.store, key,
+		tb.capacity, tb.rate, ttlMillis(tb.store))
+}
+
+func (tb *RedisTokenBucket) State(key string) (interface{}, bool) {
+	reply, err := redisTokenBucketStateScript.Run(tb.store.Client(), []string{tb.store.Prefix() + key})
+	fields, _ := reply.([]interface{})
+	if err != nil || len(fields) != 2 || fields[0] == nil {
+		return nil, false
+	}
+	tokens, err1 := resp.Int64(fields[0], nil)
+	ts, err2 := resp.Int64(fields[1], nil)
+	if err1 != nil || err2 != nil {
+		return nil, false
+	}
+	return TokenBucketState{Tokens: tokens, LastTime: time.UnixMicro(ts)}, true
+}
+
+// RedisSlidingWindow is a SlidingWindow whose timestamps live in a sorted set
+// on a Redis-compatible server
+type RedisSlidingWindow struct {
+	windowSize  time.Duration
+	maxRequests int
+	store       *store.RedisStore
+}
+
+func NewRedisSlidingWindow(windowSize time.Duration, maxRequests int, s *store.RedisStore) *RedisSlidingWindow {
+	return &RedisSlidingWindow{windowSize: windowSize, maxRequests: maxRequests, store: s}
+}
+
+func (sw *RedisSlidingWindow) Allow(key string) (bool, int64) {
+	// Members must be unique or concurrent requests in the same microsecond collapse
+	member := strconv.FormatUint(rand.Uint64(), 36)
+	return runDecision(redisSlidingWindowScript, sw.store, key,
+		sw.windowSize.Microseconds(), sw.maxRequests, ttlMillis(sw.store), member)
+}
+
+func (sw *RedisSlidingWindow) State(key string) (interface{}, bool) {
+	reply, err := redisSlidingWindowStateScript.Run(sw.store.Client(), []string{sw.store.Prefix() + key})
+	pairs, _ := reply.([]interface{})
+	if err != nil || len(pairs) == 0 {
+		return nil, false
+	}
+	state := SlidingWindowState{Requests: make([]time.Time, 0, len(pairs)/2)}
+	for i := 1; i < len(pairs); i += 2 {
+		score, err := resp.Int64(pairs[i], nil)
+		if err != nil {
+			return nil, false
+		}
+		state.Requests = append(state.Requests, time.UnixMicro(score))
+	}
+	return state, true
+}
+
+// RedisGCRA is a GCRA whose theoretical arrival times live on a Redis-compatible server
+type RedisGCRA struct {
+	burst int64
+	rate  int64
+	store *store.RedisStore
+}
+
+func NewRedisGCRA(burst, rate int64, s *store.RedisStore) *RedisGCRA {
+	return &RedisGCRA{burst: burst, rate: rate, store: s}
+}
+
+func (g *RedisGCRA) Allow(key string) (bool, int64) {
+	interval := (time.Second / time.Duration(g.rate)).Microseconds()
+	return runDecision(redisGCRAScript, g.store, key, interval, g.burst, ttlMillis(g.store))
+}
+
+func (g *RedisGCRA) State(key string) (interface{}, bool) {
+	tat, err := resp.Int64(g.store.Client().Do("GET", g.store.Prefix()+key))
+	if err != nil {
+		return nil, false
+	}
+	return GCRAState{TAT: time.UnixMicro(tat)}, true
+}
+
+// runDecision runs a script returning {allowed, remaining}
+func runDecision(script *resp.Script, s *store.RedisStore, key string, args ...interface{}) (bool, int64) {
+	allowed, remaining, err := parseDecision(script.Run(s.Client(), []string{s.Prefix() + key}, args...))
+	if err != nil {
+		return storeFailed(s)
+	}
+	return allowed, remaining
+}
+
+func parseDecision(reply interface{}, err error) (bool, int64, error) {
+	if err != nil {
+		return false, 0, err
+	}
+	arr, ok := reply.([]interface{})
+	if !ok || len(arr) != 2 {
+		return false, 0, fmt.Errorf("unexpected script reply %v", reply)
+	}
+	allowed, err := resp.Int64(arr[0], nil)
+	if err != nil {
+		return false, 0, err
+	}
+	remaining, err := resp.Int64(arr[1], nil)
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed == 1, remaining, nil
+}
+
+// ttlMillis is how long idle limiter state is kept; PEXPIRE needs a positive value
+func ttlMillis(s *store.RedisStore) int64 {
+	if ms := s.TTL().Milliseconds(); ms > 0 {
+		return ms
+	}
+	return int64(24 * time.Hour / time.Millisecond)
+}
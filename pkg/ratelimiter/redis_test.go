@@ -0,0 +1,297 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/resp"
+	"RateLimiterService/pkg/resp/resptest"
+	"RateLimiterService/pkg/store"
+)
+
+// The stand-in server can't run Lua, so each script gets a Go emulation that
+// follows it line for line. The tests ending OnRealServer run the scripts
+// themselves against REDIS_TEST_ADDR, and are skipped without one.
+
+type tbHash struct {
+	tokens, ts int64
+}
+
+func micros(t time.Time) int64 {
+	return t.UnixMicro()
+}
+
+func atoi(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+func registerRedisEmulations(srv *resptest.Server) {
+	srv.HandleScript(redisTokenBucketScript.Source(), func(db *resptest.DB, keys, args []string) (interface{}, error) {
+		now := micros(db.Now())
+		capacity, rate, ttl := atoi(args[0]), atoi(args[1]), atoi(args[2])
+		st := tbHash{tokens: capacity, ts: now}
+		if v, ok := db.Get(keys[0]); ok {
+			st = v.(tbHash)
+		}
+		elapsed := max(0, now-st.ts)
+		st.tokens = min(capacity, st.tokens+elapsed*rate/1000000)
+		if st.tokens > 0 {
+			st.tokens--
+			st.ts = now
+			db.Set(keys[0], st, time.Duration(ttl)*time.Millisecond)
+			return []interface{}{int64(1), st.tokens}, nil
+		}
+		return []interface{}{int64(0), int64(0)}, nil
+	})
+	srv.HandleScript(redisTokenBucketStateScript.Source(), func(db *resptest.DB, keys, args []string) (interface{}, error) {
+		v, ok := db.Get(keys[0])
+		if !ok {
+			return []interface{}{nil, nil}, nil
+		}
+		st := v.(tbHash)
+		return []interface{}{strconv.FormatInt(st.tokens, 10), strconv.FormatInt(st.ts, 10)}, nil
+	})
+
+	srv.HandleScript(redisSlidingWindowScript.Source(), func(db *resptest.DB, keys, args []string) (interface{}, error) {
+		now := micros(db.Now())
+		window, maxReqs, ttl := atoi(args[0]), atoi(args[1]), atoi(args[2])
+		var scores []int64
+		if v, ok := db.Get(keys[0]); ok {
+			for _, s := range v.([]int64) {
+				if s > now-window {
+					scores = append(scores, s)
+				}
+			}
+		}
+		count := int64(len(scores))
+		if count < maxReqs {
+			scores = append(scores, now)
+			db.Set(keys[0], scores, time.Duration(ttl)*time.Millisecond)
+			return []interface{}{int64(1), maxReqs - count - 1}, nil
+		}
+		// Pruning alone doesn't touch the expiry, and Redis drops a key once
+		// its sorted set is empty
+		if len(scores) == 0 {
+			db.Del(keys[0])
+		} else {
+			db.Replace(keys[0], scores)
+		}
+		return []interface{}{int64(0), int64(0)}, nil
+	})
+	srv.HandleScript(redisSlidingWindowStateScript.Source(), func(db *resptest.DB, keys, args []string) (interface{}, error) {
+		v, ok := db.Get(keys[0])
+		if !ok {
+			return []interface{}{}, nil
+		}
+		scores := append([]int64(nil), v.([]int64)...)
+		sort.Slice(scores, func(i, j int) bool { return scores[i] < scores[j] })
+		out := make([]interface{}, 0, 2*len(scores))
+		for i, s := range scores {
+			out = append(out, strconv.Itoa(i), strconv.FormatInt(s, 10))
+		}
+		return out, nil
+	})
+
+	srv.HandleScript(redisGCRAScript.Source(), func(db *resptest.DB, keys, args []string) (interface{}, error) {
+		now := micros(db.Now())
+		interval, ttl := atoi(args[0]), atoi(args[2])
+		limit := interval * atoi(args[1])
+		tat := now
+		if v, ok := db.Get(keys[0]); ok {
+			tat = atoi(v.(string))
+		}
+		newTat := max(tat, now) + interval
+		if newTat-now > limit {
+			return []interface{}{int64(0), int64(0)}, nil
+		}
+		db.Set(keys[0], strconv.FormatInt(newTat, 10), time.Duration(ttl)*time.Millisecond)
+		return []interface{}{int64(1), (limit - (newTat - now)) / interval}, nil
+	})
+}
+
+// newRedisStores returns n stores sharing one stand-in server, as n limiter
+// replicas would share one Redis
+func newRedisStores(t *testing.T, n int) ([]*store.RedisStore, *resptest.Server) {
+	t.Helper()
+	srv, err := resptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerRedisEmulations(srv)
+	stores := make([]*store.RedisStore, n)
+	for i := range stores {
+		stores[i] = store.NewRedisStore(resp.NewClient(srv.Addr(), resp.Options{}), "rl:", time.Hour)
+	}
+	t.Cleanup(func() {
+		for _, s := range stores {
+			s.Close()
+		}
+		srv.Close()
+	})
+	return stores, srv
+}
+
+// redisLimiters builds each Redis limiter with capacity 4 at 1 per second
+var redisLimiters = map[string]func(*store.RedisStore) RateLimiter{
+	"tokenbucket":   func(s *store.RedisStore) RateLimiter { return NewRedisTokenBucket(4, 1, s) },
+	"slidingwindow": func(s *store.RedisStore) RateLimiter { return NewRedisSlidingWindow(10*time.Second, 4, s) },
+	"gcra":          func(s *store.RedisStore) RateLimiter { return NewRedisGCRA(4, 1, s) },
+}
+
+// expectSharedLimit alternates checks between two replicas and expects them
+// to enforce one limit between them
+func expectSharedLimit(t *testing.T, stores []*store.RedisStore) {
+	for name, mk := range redisLimiters {
+		t.Run(name, func(t *testing.T) {
+			replicas := []RateLimiter{mk(stores[0]), mk(stores[1])}
+			key := name + "-key"
+			allowed := 0
+			for i := 0; i < 8; i++ {
+				if ok, _ := replicas[i%2].Allow(key); ok {
+					allowed++
+				}
+			}
+			if allowed != 4 {
+				t.Errorf("Expected 4 allowed across both replicas, got %d", allowed)
+			}
+			if _, ok := replicas[0].(Inspector).State(key); !ok {
+				t.Error("Expected state to be inspectable")
+			}
+			if err := stores[0].Err(); err != nil {
+				t.Errorf("Unexpected store error %v", err)
+			}
+		})
+	}
+}
+
+func TestRedisLimitersShareLimitAcrossInstances(t *testing.T) {
+	stores, srv := newRedisStores(t, 2)
+	now := time.Unix(1700000000, 0)
+	srv.SetNow(func() time.Time { return now })
+	expectSharedLimit(t, stores)
+}
+
+func TestRedisTokenBucketRefillsOnServerTime(t *testing.T) {
+	stores, srv := newRedisStores(t, 1)
+	now := time.Unix(1700000000, 0)
+	srv.SetNow(func() time.Time { return now })
+	tb := NewRedisTokenBucket(2, 1, stores[0])
+
+	tb.Allow("k")
+	if _, remaining := tb.Allow("k"); remaining != 0 {
+		t.Fatalf("Expected bucket drained, remaining %d", remaining)
+	}
+	if ok, _ := tb.Allow("k"); ok {
+		t.Fatal("Expected deny on empty bucket")
+	}
+	later := now.Add(time.Second)
+	srv.SetNow(func() time.Time { return later })
+	if ok, _ := tb.Allow("k"); !ok {
+		t.Error("Expected allow after one second of refill")
+	}
+
+	state, ok := tb.State("k")
+	if !ok || !state.(TokenBucketState).LastTime.Equal(later) {
+		t.Errorf("Unexpected state %v", state)
+	}
+}
+
+func TestRedisSlidingWindowDenialsDoNotExtendExpiry(t *testing.T) {
+	srv, err := resptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerRedisEmulations(srv)
+	s := store.NewRedisStore(resp.NewClient(srv.Addr(), resp.Options{}), "rl:", time.Minute)
+	t.Cleanup(func() {
+		s.Close()
+		srv.Close()
+	})
+	now := time.Unix(1700000000, 0)
+	srv.SetNow(func() time.Time { return now })
+	// A window longer than the TTL, so only expiry can free the key
+	sw := NewRedisSlidingWindow(time.Hour, 2, s)
+
+	sw.Allow("k")
+	sw.Allow("k")
+	now = now.Add(50 * time.Second)
+	if ok, _ := sw.Allow("k"); ok {
+		t.Fatal("Expected deny once the window is full")
+	}
+	// The script only sets the expiry when it admits a request
+	now = now.Add(11 * time.Second)
+	if ok, _ := sw.Allow("k"); !ok {
+		t.Error("Expected the key to have expired a minute after its last admitted request")
+	}
+}
+
+// realRedisStores returns n stores on the server at REDIS_TEST_ADDR, default
+// localhost:6379, under a prefix unique to the test, or skips the test if
+// none answers. Unlike the stand-in, it runs the Lua scripts themselves.
+func realRedisStores(t *testing.T, n int) []*store.RedisStore {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	prefix := fmt.Sprintf("ratelimiter-test-%d:", time.Now().UnixNano())
+	opts := resp.Options{DialTimeout: 200 * time.Millisecond}
+	stores := make([]*store.RedisStore, n)
+	for i := range stores {
+		stores[i] = store.NewRedisStore(resp.NewClient(addr, opts), prefix, time.Minute)
+	}
+	if err := stores[0].Ping(); err != nil {
+		for _, s := range stores {
+			s.Close()
+		}
+		t.Skipf("No Redis at %s: %v", addr, err)
+	}
+	t.Cleanup(func() {
+		var keys []string
+		stores[0].Range(func(key string, _ interface{}) bool {
+			keys = append(keys, key)
+			return true
+		})
+		for _, k := range keys {
+			stores[0].Delete(k)
+		}
+		for _, s := range stores {
+			s.Close()
+		}
+	})
+	return stores
+}
+
+func TestRedisScriptsShareLimitOnRealServer(t *testing.T) {
+	expectSharedLimit(t, realRedisStores(t, 2))
+}
+
+func TestRedisScriptsRefillOnRealServer(t *testing.T) {
+	stores := realRedisStores(t, 1)
+	for name, mk := range map[string]func(*store.RedisStore) RateLimiter{
+		"tokenbucket":   func(s *store.RedisStore) RateLimiter { return NewRedisTokenBucket(2, 10, s) },
+		"slidingwindow": func(s *store.RedisStore) RateLimiter { return NewRedisSlidingWindow(100*time.Millisecond, 2, s) },
+		"gcra":          func(s *store.RedisStore) RateLimiter { return NewRedisGCRA(2, 10, s) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			l := mk(stores[0])
+			for i := 0; i < 2; i++ {
+				if ok, _ := l.Allow("k-" + name); !ok {
+					t.Fatalf("Expected request %d allowed", i)
+				}
+			}
+			if ok, _ := l.Allow("k-" + name); ok {
+				t.Fatal("Expected deny once capacity is used")
+			}
+			time.Sleep(250 * time.Millisecond)
+			if ok, _ := l.Allow("k-" + name); !ok {
+				t.Error("Expected allow after refilling on server time")
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package ratelimiter
+
+import "time"
+
+// JumpDirection says which way a clock jump went
+type JumpDirection int
+
+const (
+	// JumpBackward means a stored timestamp was ahead of now: the wall clock
+	// stepped back, or the state was written by a node whose clock runs ahead
+	JumpBackward JumpDirection = iota
+	// JumpForward means more than SkewGuard.MaxForward passed since a stored timestamp
+	JumpForward
+)
+
+func (d JumpDirection) String() string {
+	if d == JumpForward {
+		return "forward"
+	}
+	return "backward"
+}
+
+// SkewGuard bounds the time the algorithms credit between a key's stored
+// timestamp and now. Time running backwards is always clamped to zero, so it
+// never takes tokens away or holds a key past its window; a nil guard does
+// that much without reporting. MaxForward optionally caps the time credited
+// in one step: with a TokenBucket, anything under capacity/rate also slows
+// how fast an idle key refills, so set it only where timestamps come from
+// clocks that can't be trusted.
+type SkewGuard struct {
+	MaxForward time.Duration // 0 means uncapped
+	// OnJump, if set, is called for every clamped step with its size. It is
+	// called from inside Store.Update and must not block.
+	OnJump func(dir JumpDirection, by time.Duration)
+}
+
+// elapsed returns the time to credit between last and now, and whether it
+// had to be clamped
+func (g *SkewGuard) elapsed(last, now time.Time) (time.Duration, bool) {
+	d := now.Sub(last)
+	switch {
+	case d < 0:
+		g.report(JumpBackward, -d)
+		return 0, true
+	case g != nil && g.MaxForward > 0 && d > g.MaxForward:
+		g.report(JumpForward, d-g.MaxForward)
+		return g.MaxForward, true
+	}
+	return d, false
+}
+
+func (g *SkewGuard) report(dir JumpDirection, by time.Duration) {
+	if g != nil && g.OnJump != nil {
+		g.OnJump(dir, by)
+	}
+}
@@ -0,0 +1,139 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/store"
+)
+
+type jumpRecorder struct {
+	dirs []JumpDirection
+}
+
+func (r *jumpRecorder) guard(maxForward time.Duration) *SkewGuard {
+	return &SkewGuard{MaxForward: maxForward, OnJump: func(dir JumpDirection, by time.Duration) {
+		r.dirs = append(r.dirs, dir)
+	}}
+}
+
+func newSkewTest(t *testing.T) (*clock.FakeClock, store.Store) {
+	t.Helper()
+	c := frozenClock()
+	s := store.NewInMemoryStoreWithOptions(store.Options{TTL: 24 * time.Hour, Clock: c})
+	t.Cleanup(s.Close)
+	return c, s
+}
+
+func TestTokenBucketBackwardJumpKeepsTokens(t *testing.T) {
+	c, s := newSkewTest(t)
+	var jumps jumpRecorder
+	tb := NewTokenBucket(5, 1, c, s)
+	tb.SetSkewGuard(jumps.guard(0))
+
+	tb.Allow("k") // 4 left
+	c.Set(c.Now().Add(-time.Hour))
+	if ok, remaining := tb.Allow("k"); !ok || remaining != 3 {
+		t.Fatalf("Allow after stepping back = %v, %d; want true, 3", ok, remaining)
+	}
+	// Refill resumes from the new clock rather than waiting an hour
+	for i := 0; i < 3; i++ {
+		tb.Allow("k")
+	}
+	c.Advance(2 * time.Second)
+	if _, remaining := tb.Allow("k"); remaining != 1 {
+		t.Errorf("Expected refill from the rebased time, remaining %d", remaining)
+	}
+	if len(jumps.dirs) != 1 || jumps.dirs[0] != JumpBackward {
+		t.Errorf("Unexpected jumps %v", jumps.dirs)
+	}
+}
+
+func TestTokenBucketCapsForwardJump(t *testing.T) {
+	c, s := newSkewTest(t)
+	var jumps jumpRecorder
+	tb := NewTokenBucket(10, 1, c, s)
+	tb.SetSkewGuard(jumps.guard(3 * time.Second))
+
+	for i := 0; i < 10; i++ {
+		tb.Allow("k")
+	}
+	c.Advance(time.Hour)
+	if _, remaining := tb.Allow("k"); remaining != 2 {
+		t.Errorf("Expected only 3s of refill credited, remaining %d", remaining)
+	}
+	if len(jumps.dirs) != 1 || jumps.dirs[0] != JumpForward {
+		t.Errorf("Unexpected jumps %v", jumps.dirs)
+	}
+}
+
+func TestGCRABurstUnderSmallForwardCap(t *testing.T) {
+	c, s := newSkewTest(t)
+	var jumps jumpRecorder
+	g := NewGCRA(10, 1, c, s)
+	g.SetSkewGuard(jumps.guard(5 * time.Second))
+
+	allowed := 0
+	for i := 0; i < 12; i++ {
+		if ok, _ := g.Allow("k"); ok {
+			allowed++
+		}
+	}
+	if allowed != 10 {
+		t.Errorf("Expected the full burst of 10 on a frozen clock, got %d", allowed)
+	}
+	if len(jumps.dirs) != 0 {
+		t.Errorf("Expected no jumps on a frozen clock, got %v", jumps.dirs)
+	}
+
+	// As with the token bucket, a jump is credited at most 5s of refill
+	c.Advance(time.Hour)
+	if _, remaining := g.Allow("k"); remaining != 4 {
+		t.Errorf("Expected only 5s of refill credited, remaining %d", remaining)
+	}
+	if len(jumps.dirs) != 1 || jumps.dirs[0] != JumpForward {
+		t.Errorf("Unexpected jumps %v", jumps.dirs)
+	}
+}
+
+func TestSlidingWindowBackwardJumpDoesNotExtendWindow(t *testing.T) {
+	c, s := newSkewTest(t)
+	sw := NewSlidingWindow(10*time.Second, 2, c, s)
+
+	sw.Allow("k")
+	sw.Allow("k")
+	c.Set(c.Now().Add(-time.Hour))
+	if ok, _ := sw.Allow("k"); ok {
+		t.Fatal("Expected the window to still be full right after the step")
+	}
+	// Without rebasing, the old requests would count for another hour
+	c.Advance(11 * time.Second)
+	if ok, _ := sw.Allow("k"); !ok {
+		t.Error("Expected the window to slide on the new clock")
+	}
+}
+
+func TestGCRABackwardJumpKeepsState(t *testing.T) {
+	c, s := newSkewTest(t)
+	var jumps jumpRecorder
+	g := NewGCRA(2, 1, c, s)
+	g.SetSkewGuard(jumps.guard(0))
+
+	g.Allow("k") // 1 left
+	c.Set(c.Now().Add(-time.Hour))
+	if ok, remaining := g.Allow("k"); !ok || remaining != 0 {
+		t.Fatalf("Allow after stepping back = %v, %d; want true, 0", ok, remaining)
+	}
+	// Without rebasing, the key would be blocked for an hour
+	if ok, _ := g.Allow("k"); ok {
+		t.Fatal("Expected the burst to be spent")
+	}
+	c.Advance(time.Second)
+	if ok, _ := g.Allow("k"); !ok {
+		t.Error("Expected one emission interval to free a request")
+	}
+	if len(jumps.dirs) != 1 || jumps.dirs[0] != JumpBackward {
+		t.Errorf("Unexpected jumps %v", jumps.dirs)
+	}
+}
@@ -0,0 +1,324 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Error is an error reply sent by the server. The connection stays usable.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// ErrNil is returned by helpers that expect a value when the reply is nil
+var ErrNil = errors.New("resp: nil reply")
+
+// Options configures a Client
+type Options struct {
+	Password    string
+	DB          int
+	PoolSize    int           // idle connections kept; defaults to 16
+	DialTimeout time.Duration // defaults to 5s
+	IOTimeout   time.Duration // per command; defaults to 3s
+}
+
+// Client is a pool of connections to one server speaking RESP2 (Redis, Valkey,
+// KeyDB or the in-process stand-in in resptest). It is safe for concurrent use.
+type Client struct {
+	addr   string
+	opts   Options
+	idle   chan *Conn
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewClient returns a client for addr. Connections are dialled on demand.
+func NewClient(addr string, opts Options) *Client {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 16
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.IOTimeout <= 0 {
+		opts.IOTimeout = 3 * time.Second
+	}
+	return &Client{addr: addr, opts: opts, idle: make(chan *Conn, opts.PoolSize)}
+}
+
+// Do sends one command and returns its reply. Replies are string, int64,
+// []interface{} or nil; error replies are returned as Error.
+func (c *Client) Do(args ...interface{}) (interface{}, error) {
+	var reply interface{}
+	err := c.WithConn(func(cn *Conn) error {
+		var err error
+		reply, err = cn.Do(args...)
+		return err
+	})
+	return reply, err
+}
+
+// WithConn runs fn with a dedicated connection, e.g. for WATCH/MULTI/EXEC.
+// The connection is discarded if fn returns a network error.
+func (c *Client) WithConn(fn func(*Conn) error) error {
+	cn, err := c.get()
+	if err != nil {
+		return err
+	}
+	err = fn(cn)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) && !errors.Is(err, ErrNil) {
+		cn.Close()
+		return err
+	}
+	c.put(cn)
+	return err
+}
+
+func (c *Client) get() (*Conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil, errors.New("resp: client closed")
+	}
+	return c.dial()
+}
+
+func (c *Client) put(cn *Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		cn.Close()
+		return
+	}
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+func (c *Client) dial() (*Conn, error) {
+	nc, err := net.DialTimeout("tcp", c.addr, c.opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &Conn{conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc), timeout: c.opts.IOTimeout}
+	if c.opts.Password != "" {
+		if _, err := cn.Do("AUTH", c.opts.Password); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.opts.DB != 0 {
+		if _, err := cn.Do("SELECT", c.opts.DB); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// Close closes idle connections; connections in use are closed when returned
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// Conn is a single connection. It is not safe for concurrent use.
+type Conn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+// Do sends one command and reads its reply
+func (cn *Conn) Do(args ...interface{}) (interface{}, error) {
+	if cn.timeout > 0 {
+		cn.conn.SetDeadline(time.Now().Add(cn.timeout))
+	}
+	if err := WriteCommand(cn.w, args...); err != nil {
+		return nil, err
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+	reply, err := ReadReply(cn.r)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+func (cn *Conn) Close() error {
+	return cn.conn.Close()
+}
+
+// WriteCommand writes args as a RESP array of bulk strings
+func WriteCommand(w *bufio.Writer, args ...interface{}) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("resp: unsupported argument type %T", arg)
+		}
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+	}
+	return nil
+}
+
+// ReadReply reads one RESP2 value
+func ReadReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("resp: empty line")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = ReadReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("resp: unexpected reply type %q", line[0])
+}
+
+// WriteReply encodes v as a RESP2 reply. It accepts the types ReadReply returns.
+func WriteReply(w *bufio.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case Error:
+		fmt.Fprintf(w, "-%s\r\n", string(v))
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, e := range v {
+			if err := WriteReply(w, e); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("resp: unsupported reply type %T", v)
+	}
+	return nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("resp: malformed line")
+	}
+	return line[:len(line)-2], nil
+}
+
+// String converts a bulk or simple string reply
+func String(reply interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch v := reply.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", ErrNil
+	}
+	return "", fmt.Errorf("resp: unexpected reply %T", reply)
+}
+
+// Int64 converts an integer reply
+func Int64(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case nil:
+		return 0, ErrNil
+	}
+	return 0, fmt.Errorf("resp: unexpected reply %T", reply)
+}
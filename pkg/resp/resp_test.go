@@ -0,0 +1,84 @@
+package resp_test
+
+import (
+	"testing"
+
+	"RateLimiterService/pkg/resp"
+	"RateLimiterService/pkg/resp/resptest"
+)
+
+func newTestClient(t *testing.T) (*resp.Client, *resptest.Server) {
+	t.Helper()
+	srv, err := resptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := resp.NewClient(srv.Addr(), resp.Options{})
+	t.Cleanup(func() {
+		c.Close()
+		srv.Close()
+	})
+	return c, srv
+}
+
+func TestClientCommands(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	if s, err := resp.String(c.Do("PING")); err != nil || s != "PONG" {
+		t.Fatalf("PING = %q, %v", s, err)
+	}
+	if _, err := c.Do("SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := resp.String(c.Do("GET", "k")); err != nil || s != "v" {
+		t.Errorf("GET = %q, %v", s, err)
+	}
+	if _, err := resp.String(c.Do("GET", "missing")); err != resp.ErrNil {
+		t.Errorf("Expected ErrNil for missing key, got %v", err)
+	}
+	if n, err := resp.Int64(c.Do("DEL", "k", "missing")); err != nil || n != 1 {
+		t.Errorf("DEL = %d, %v", n, err)
+	}
+	if _, err := c.Do("NOPE"); err == nil {
+		t.Error("Expected error reply for unknown command")
+	} else if _, ok := err.(resp.Error); !ok {
+		t.Errorf("Expected resp.Error, got %T", err)
+	}
+}
+
+func TestScriptFallsBackToEval(t *testing.T) {
+	c, srv := newTestClient(t)
+	script := resp.NewScript("return ARGV[1]")
+	calls := 0
+	srv.HandleScript(script.Source(), func(db *resptest.DB, keys, args []string) (interface{}, error) {
+		calls++
+		return args[0], nil
+	})
+
+	// First run is NOSCRIPT and falls back to EVAL, the second hits the cache
+	for i := 0; i < 2; i++ {
+		if s, err := resp.String(script.Run(c, nil, "hello")); err != nil || s != "hello" {
+			t.Fatalf("Run = %q, %v", s, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 script calls, got %d", calls)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"rl:*", "rl:user1", true},
+		{"rl:*", "other", false},
+		{`rl:\*`, "rl:*", true},
+		{`rl:\*`, "rl:x", false},
+		{"a?c", "abc", true},
+	} {
+		if got := resptest.Match(tc.pattern, tc.key); got != tc.want {
+			t.Errorf("Match(%q, %q) = %v", tc.pattern, tc.key, got)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package resptest
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"RateLimiterService/pkg/resp"
+)
+
+// ScriptFunc emulates a Lua script in Go. It runs with the server locked, so
+// like a real script it is atomic with respect to all other commands.
+type ScriptFunc func(db *DB, keys, args []string) (interface{}, error)
+
+// Server is an in-process RESP2 server for tests. It implements the handful
+// of commands the store and limiters use; scripts are not interpreted but
+// dispatched by SHA-1 to Go emulations registered with HandleScript.
+type Server struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	data     map[string]item
+	versions map[string]uint64 // bumped on every write, for WATCH
+	scripts  map[string]ScriptFunc
+	loaded   map[string]bool // SHAs known to the script cache
+	now      func() time.Time
+
+	wg sync.WaitGroup
+}
+
+type item struct {
+	val     interface{}
+	expires time.Time
+}
+
+// NewServer starts a server on a loopback port
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		ln:       ln,
+		data:     make(map[string]item),
+		versions: make(map[string]uint64),
+		scripts:  make(map[string]ScriptFunc),
+		loaded:   make(map[string]bool),
+		now:      time.Now,
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// SetNow replaces the server's clock, used for TTLs and the TIME command
+func (s *Server) SetNow(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// HandleScript registers fn as the implementation of the Lua script src
+func (s *Server) HandleScript(src string, fn ScriptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[sha(src)] = fn
+}
+
+// Close stops accepting and waits for connection handlers to exit
+func (s *Server) Close() {
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	var conns sync.WaitGroup
+	defer conns.Wait()
+	var open []net.Conn
+	var openMu sync.Mutex
+	defer func() {
+		openMu.Lock()
+		for _, c := range open {
+			c.Close()
+		}
+		openMu.Unlock()
+	}()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		openMu.Lock()
+		open = append(open, c)
+		openMu.Unlock()
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			s.serve(c)
+		}()
+	}
+}
+
+func (s *Server) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	sess := &session{}
+	for {
+		req, err := resp.ReadReply(r)
+		if err != nil {
+			return
+		}
+		arr, ok := req.([]interface{})
+		if !ok || len(arr) == 0 {
+			resp.WriteReply(w, resp.Error("ERR protocol error"))
+			w.Flush()
+			continue
+		}
+		args := make([]string, len(arr))
+		for i, a := range arr {
+			args[i], _ = a.(string)
+		}
+		resp.WriteReply(w, s.exec(sess, args))
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// session is per-connection transaction state
+type session struct {
+	watched map[string]uint64
+	multi   bool
+	queued  [][]string
+}
+
+func (s *Server) exec(sess *session, args []string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmd := strings.ToUpper(args[0])
+	switch {
+	case cmd == "WATCH":
+		if sess.watched == nil {
+			sess.watched = make(map[string]uint64)
+		}
+		for _, k := range args[1:] {
+			sess.watched[k] = s.versions[k]
+		}
+		return "OK"
+	case cmd == "UNWATCH":
+		sess.watched = nil
+		return "OK"
+	case cmd == "MULTI":
+		sess.multi = true
+		return "OK"
+	case cmd == "DISCARD":
+		sess.multi, sess.queued, sess.watched = false, nil, nil
+		return "OK"
+	case cmd == "EXEC":
+		queued, watched := sess.queued, sess.watched
+		sess.multi, sess.queued, sess.watched = false, nil, nil
+		for k, v := range watched {
+			if s.versions[k] != v {
+				return nil // aborted
+			}
+		}
+		replies := make([]interface{}, len(queued))
+		for i, q := range queued {
+			replies[i] = s.execLocked(q)
+		}
+		return replies
+	case sess.multi:
+		sess.queued = append(sess.queued, args)
+		return "QUEUED"
+	}
+	return s.execLocked(args)
+}
+
+func (s *Server) execLocked(args []string) interface{} {
+	db := &DB{s: s}
+	cmd := strings.ToUpper(args[0])
+	args = args[1:]
+	switch cmd {
+	case "PING":
+		return "PONG"
+	case "AUTH", "SELECT":
+		return "OK"
+	case "TIME":
+		now := s.now()
+		return []interface{}{strconv.FormatInt(now.Unix(), 10), strconv.Itoa(now.Nanosecond() / 1000)}
+	case "GET":
+		if len(args) != 1 {
+			return wrongArgs(cmd)
+		}
+		v, ok := db.Get(args[0])
+		if !ok {
+			return nil
+		}
+		str, ok := v.(string)
+		if !ok {
+			return resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		return str
+	case "SET":
+		if len(args) != 2 && len(args) != 4 {
+			return wrongArgs(cmd)
+		}
+		var ttl time.Duration
+		if len(args) == 4 {
+			if !strings.EqualFold(args[2], "PX") {
+				return resp.Error("ERR syntax error")
+			}
+			ms, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil || ms <= 0 {
+				return resp.Error("ERR invalid expire time in 'set' command")
+			}
+			ttl = time.Duration(ms) * time.Millisecond
+		}
+		db.Set(args[0], args[1], ttl)
+		return "OK"
+	case "PEXPIRE":
+		if len(args) != 2 {
+			return wrongArgs(cmd)
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return resp.Error("ERR value is not an integer or out of range")
+		}
+		v, ok := db.Get(args[0])
+		if !ok {
+			return int64(0)
+		}
+		db.Set(args[0], v, time.Duration(ms)*time.Millisecond)
+		return int64(1)
+	case "DEL", "EXISTS":
+		var n int64
+		for _, k := range args {
+			if _, ok := db.Get(k); ok {
+				n++
+				if cmd == "DEL" {
+					db.Del(k)
+				}
+			}
+		}
+		return n
+	case "SCAN":
+		return s.scan(db, args)
+	case "SCRIPT":
+		if len(args) != 2 || !strings.EqualFold(args[0], "LOAD") {
+			return resp.Error("ERR unsupported SCRIPT subcommand")
+		}
+		return s.load(args[1])
+	case "EVAL":
+		if len(args) < 2 {
+			return wrongArgs(cmd)
+		}
+		return s.eval(db, s.load(args[0]), args[1:])
+	case "EVALSHA":
+		if len(args) < 2 {
+			return wrongArgs(cmd)
+		}
+		if !s.loaded[args[0]] {
+			return resp.Error("NOSCRIPT No matching script. Please use EVAL.")
+		}
+		return s.eval(db, args[0], args[1:])
+	}
+	return resp.Error(fmt.Sprintf("ERR unknown command '%s'", cmd))
+}
+
+func (s *Server) load(src string) string {
+	h := sha(src)
+	s.loaded[h] = true
+	return h
+}
+
+func (s *Server) eval(db *DB, h string, args []string) interface{} {
+	fn, ok := s.scripts[h]
+	if !ok {
+		return resp.Error("ERR resptest: no Go emulation registered for script " + h)
+	}
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 0 || numKeys > len(args)-1 {
+		return resp.Error("ERR Number of keys can't be greater than number of args")
+	}
+	reply, err := fn(db, args[1:1+numKeys], args[1+numKeys:])
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	return reply
+}
+
+// scan returns every match in one batch with cursor 0
+func (s *Server) scan(db *DB, args []string) interface{} {
+	if len(args) < 1 {
+		return wrongArgs("SCAN")
+	}
+	pattern := "*"
+	for i := 1; i+1 < len(args); i += 2 {
+		if strings.EqualFold(args[i], "MATCH") {
+			pattern = args[i+1]
+		}
+	}
+	var keys []string
+	for k := range s.data {
+		if _, ok := db.Get(k); ok && Match(pattern, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	out := make([]interface{}, len(keys))
+	for i, k := range keys {
+		out[i] = k
+	}
+	return []interface{}{"0", out}
+}
+
+func wrongArgs(cmd string) resp.Error {
+	return resp.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func sha(src string) string {
+	sum := sha1.Sum([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+// DB gives script emulations access to the keyspace. Values set through the
+// plain commands are strings; emulations may store any Go value.
+type DB struct {
+	s *Server
+}
+
+// Now is the server's time, as the TIME command would report it
+func (db *DB) Now() time.Time {
+	return db.s.now()
+}
+
+func (db *DB) Get(key string) (interface{}, bool) {
+	it, ok := db.s.data[key]
+	if !ok {
+		return nil, false
+	}
+	if !it.expires.IsZero() && !db.s.now().Before(it.expires) {
+		delete(db.s.data, key)
+		return nil, false
+	}
+	return it.val, true
+}
+
+// Set stores v; ttl <= 0 means no expiry
+func (db *DB) Set(key string, v interface{}, ttl time.Duration) {
+	it := item{val: v}
+	if ttl > 0 {
+		it.expires = db.s.now().Add(ttl)
+	}
+	db.s.data[key] = it
+	db.s.versions[key]++
+}
+
+// Replace stores v and keeps key's expiry, as a write not followed by
+// PEXPIRE does
+func (db *DB) Replace(key string, v interface{}) {
+	it, ok := db.s.data[key]
+	if !ok {
+		db.Set(key, v, 0)
+		return
+	}
+	it.val = v
+	db.s.data[key] = it
+	db.s.versions[key]++
+}
+
+func (db *DB) Del(key string) {
+	delete(db.s.data, key)
+	db.s.versions[key]++
+}
+
+// Match reports whether key matches a Redis glob pattern (*, ? and \ escapes)
+func Match(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(key); i >= 0; i-- {
+				if Match(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return len(key) == 0
+}
@@ -0,0 +1,46 @@
+package resp
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+// Script is a Lua script run atomically on the server with EVALSHA, falling
+// back to EVAL when the server's script cache doesn't have it yet
+type Script struct {
+	src string
+	sha string
+}
+
+func NewScript(src string) *Script {
+	sum := sha1.Sum([]byte(src))
+	return &Script{src: src, sha: hex.EncodeToString(sum[:])}
+}
+
+// SHA returns the hex SHA-1 the server caches the script under
+func (s *Script) SHA() string {
+	return s.sha
+}
+
+// Source returns the script text
+func (s *Script) Source() string {
+	return s.src
+}
+
+// Run executes the script with the given keys and arguments
+func (s *Script) Run(c *Client, keys []string, args ...interface{}) (interface{}, error) {
+	cmd := make([]interface{}, 0, 3+len(keys)+len(args))
+	cmd = append(cmd, "EVALSHA", s.sha, len(keys))
+	for _, k := range keys {
+		cmd = append(cmd, k)
+	}
+	cmd = append(cmd, args...)
+
+	reply, err := c.Do(cmd...)
+	if e, ok := err.(Error); ok && strings.HasPrefix(string(e), "NOSCRIPT") {
+		cmd[0], cmd[1] = "EVAL", s.src
+		return c.Do(cmd...)
+	}
+	return reply, err
+}
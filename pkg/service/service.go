@@ -1,56 +1,500 @@
 package service
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/concurrency"
 	"RateLimiterService/pkg/ratelimiter"
+	"RateLimiterService/pkg/sketch"
 	"RateLimiterService/pkg/store"
 )
 
 // Config holds the configuration for the rate limiter service
 type Config struct {
-	Algorithm        string
-	Capacity         int64
-	Rate             int64
-	WindowSize       time.Duration
-	MaxRequests      int
-	TTL              time.Duration
-	MaxKeys          int // max keys in store to prevent memory growth
+	Policy      string // name reported in metrics; defaults to Algorithm
+	Algorithm   string
+	Capacity    int64
+	Rate        int64
+	WindowSize  time.Duration
+	MaxRequests int
+	TTL         time.Duration
+	MaxKeys     int         // max keys in store to prevent memory growth
+	MaxBytes    int64       // budget for the store's estimated memory use
+	Clock       clock.Clock // nil means the system clock
+	// Skew reports and bounds clock jumps seen by the in-process algorithms.
+	// Without one, time running backwards is still clamped, silently.
+	Skew *ratelimiter.SkewGuard
+	// Node names this instance in the counters crdtwindow shares between
+	// instances; ErrorBudget bounds how far they may overshoot the limit
+	// between syncs, as a fraction of it
+	Node        string
+	ErrorBudget float64
+	// SketchEpsilon and SketchDelta size the Count-Min Sketches behind
+	// countmin and PreFilter: an estimate exceeds the true count by more than
+	// SketchEpsilon × the requests admitted per window with probability at
+	// most SketchDelta (0.001 and 0.01 if 0)
+	SketchEpsilon float64
+	SketchDelta   float64
+	// PreFilter, if set, screens every check with a countmin limiter of its
+	// own before the configured algorithm. Requests it denies never reach
+	// the store, so a key hammered far past its limit costs no store work.
+	// Every key's first requests pass, so it doesn't stop a flood of
+	// distinct keys growing or churning the store; MaxKeys bounds that.
+	PreFilter *PreFilter
+	// TopK, if positive, tracks the TopK keys with the most checks and the
+	// most denials, using a fixed number of counters. Every check updates
+	// them under one lock, so this serialises checks that would otherwise
+	// run in parallel on different shards.
+	TopK int
+	// Adaptive, if set, lets client feedback move tokenbucket's rate within
+	// the given bounds. Only the in-process token bucket supports it.
+	Adaptive *ratelimiter.AIMDOptions
+	// Reserved sets aside fractions of each key's capacity for priorities,
+	// so lower priorities are denied first as it drains; see
+	// TokenBucket.SetReserved. Only the in-process token bucket supports it.
+	Reserved map[ratelimiter.Priority]float64
+	// Concurrency, if set, bounds how many requests are in flight at once,
+	// with a limit that adapts to their latency. See Acquire.
+	Concurrency *Concurrency
+}
+
+// Concurrency configures Config.Concurrency. Zero fields take the defaults
+// in brackets.
+type Concurrency struct {
+	Algorithm string // "gradient" or "vegas" ["gradient"]
+	Initial   int    // starting limit [20]
+	Min       int    // lowest limit [1]
+	Max       int    // highest limit [1000]
+	// Window is how long latency samples are pooled before the limit moves.
+	// It should span a few round trips of the work being limited. [1s]
+	Window time.Duration
+}
+
+// PreFilter is the window and limit for Config.PreFilter. The limit should
+// sit above the main algorithm's, so the sketch's over-counting only ever
+// bites on keys the main algorithm would deny anyway.
+type PreFilter struct {
+	WindowSize  time.Duration
+	MaxRequests int
 }
 
 // Decision represents the result of a rate limit check
 type Decision struct {
 	Allowed   bool
-	Remaining int64
+	Remaining int64 // left to Priority, where capacity is reserved
+	Priority  ratelimiter.Priority
 }
 
 // RateLimitService encapsulates the rate limiting logic
 type RateLimitService struct {
-	limiter ratelimiter.RateLimiter
+	policy    string
+	limiter   ratelimiter.RateLimiter
+	prefilter *ratelimiter.CountMinWindow
+	store     store.Store
+	inflight  *concurrency.Limiter
+
+	topK        int
+	topRequests *sketch.SpaceSaving
+	topDenied   *sketch.SpaceSaving
+}
+
+// HeavyHitters lists the keys checked and denied most often. Counts are
+// upper bounds; each key's true count is within its Error of it.
+type HeavyHitters struct {
+	Requests []sketch.Counted
+	Denied   []sketch.Counted
+	// Total checks and denials counted since tracking started or was reset
+	TotalRequests uint64
+	TotalDenied   uint64
 }
 
-// NewRateLimitService creates a new service based on config
+// topKSlack is how many counters are kept per key reported. Space-Saving only
+// guarantees a counter to keys above total/counters, so spare counters keep
+// the reported top K accurate when traffic is spread thin.
+const topKSlack = 10
+
+// NewRateLimitService creates a new service based on config, keeping state in memory
 func NewRateLimitService(config Config) *RateLimitService {
-	c := clock.RealClock{}
-	s := store.NewInMemoryStoreWithMaxKeys(config.TTL, config.MaxKeys)
+	// Only the redis store rejects algorithms
+	svc, _ := NewRateLimitServiceWithStore(config, store.NewInMemoryStoreWithOptions(store.Options{
+		TTL:      config.TTL,
+		MaxKeys:  config.MaxKeys,
+		MaxBytes: config.MaxBytes,
+		Clock:    config.Clock,
+	}))
+	return svc
+}
+
+// NewRateLimitServiceWithStore creates a new service that keeps state in s.
+// The service takes ownership of s and closes it in Close. It fails if the
+// algorithm can't run on s, leaving s open.
+func NewRateLimitServiceWithStore(config Config, s store.Store) (*RateLimitService, error) {
+	c := config.Clock
+	if c == nil {
+		c = clock.RealClock{}
+	}
+
+	epsilon, delta := config.SketchEpsilon, config.SketchDelta
+	if epsilon <= 0 {
+		epsilon = 0.001
+	}
+	if delta <= 0 {
+		delta = 0.01
+	}
 
 	var limiter ratelimiter.RateLimiter
+	if config.Algorithm == "countmin" {
+		// Keeps nothing in the store, whichever it is
+		limiter = ratelimiter.NewCountMinWindow(config.WindowSize, config.MaxRequests, epsilon, delta, c)
+	} else if rs, ok := s.(*store.RedisStore); ok {
+		// Run decisions as server-side scripts so replicas share limits
+		var err error
+		if limiter, err = newRedisLimiter(config, rs); err != nil {
+			return nil, err
+		}
+	} else {
+		switch config.Algorithm {
+		case "tokenbucket":
+			limiter = ratelimiter.NewTokenBucket(config.Capacity, config.Rate, c, s)
+		case "slidingwindow":
+			limiter = ratelimiter.NewSlidingWindow(config.WindowSize, config.MaxRequests, c, s)
+		case "gcra":
+			limiter = ratelimiter.NewGCRA(config.Capacity, config.Rate, c, s)
+		case "crdtwindow":
+			node := config.Node
+			if node == "" {
+				node = "local"
+			}
+			limiter = ratelimiter.NewCRDTWindow(config.WindowSize, config.MaxRequests, node, config.ErrorBudget, c, s)
+		default:
+			// Default to token bucket
+			limiter = ratelimiter.NewTokenBucket(10, 1, c, s)
+		}
+		if g, ok := limiter.(interface{ SetSkewGuard(*ratelimiter.SkewGuard) }); ok {
+			g.SetSkewGuard(config.Skew)
+		}
+		if tb, ok := limiter.(*ratelimiter.TokenBucket); ok && len(config.Reserved) > 0 {
+			tb.SetReserved(config.Reserved)
+		}
+		if tb, ok := limiter.(*ratelimiter.TokenBucket); ok && config.Adaptive != nil {
+			limiter = ratelimiter.NewAIMD(tb, *config.Adaptive, c)
+		}
+	}
+
+	policy := config.Policy
+	if policy == "" {
+		policy = config.Algorithm
+	}
+	svc := &RateLimitService{policy: policy, limiter: limiter, store: s}
+	if pf := config.PreFilter; pf != nil {
+		svc.prefilter = ratelimiter.NewCountMinWindow(pf.WindowSize, pf.MaxRequests, epsilon, delta, c)
+	}
+	if cc := config.Concurrency; cc != nil {
+		svc.inflight = newConcurrencyLimiter(*cc, c)
+	}
+	if config.TopK > 0 {
+		svc.topK = config.TopK
+		svc.topRequests = sketch.NewSpaceSaving(config.TopK * topKSlack)
+		svc.topDenied = sketch.NewSpaceSaving(config.TopK * topKSlack)
+	}
+	return svc, nil
+}
+
+func newConcurrencyLimiter(cc Concurrency, c clock.Clock) *concurrency.Limiter {
+	var limit concurrency.Limit
+	if cc.Algorithm == "vegas" {
+		limit = concurrency.NewVegas(concurrency.VegasOptions{Initial: cc.Initial, Min: cc.Min, Max: cc.Max})
+	} else {
+		limit = concurrency.NewGradient(concurrency.GradientOptions{Initial: cc.Initial, Min: cc.Min, Max: cc.Max})
+	}
+	window := cc.Window
+	if window <= 0 {
+		window = time.Second
+	}
+	return concurrency.NewLimiter(concurrency.NewWindowed(limit, window, 0, c), c)
+}
+
+func newRedisLimiter(config Config, s *store.RedisStore) (ratelimiter.RateLimiter, error) {
 	switch config.Algorithm {
 	case "tokenbucket":
-		limiter = ratelimiter.NewTokenBucket(config.Capacity, config.Rate, c, s)
+		return ratelimiter.NewRedisTokenBucket(config.Capacity, config.Rate, s), nil
 	case "slidingwindow":
-		limiter = ratelimiter.NewSlidingWindow(config.WindowSize, config.MaxRequests, c, s)
+		return ratelimiter.NewRedisSlidingWindow(config.WindowSize, config.MaxRequests, s), nil
+	case "gcra":
+		return ratelimiter.NewRedisGCRA(config.Capacity, config.Rate, s), nil
 	default:
-		// Default to token bucket
-		limiter = ratelimiter.NewTokenBucket(10, 1, c, s)
+		return nil, fmt.Errorf("algorithm %q is not supported with the redis store", config.Algorithm)
 	}
-
-	return &RateLimitService{limiter: limiter}
 }
 
 // CheckRateLimit checks if a request is allowed for the given key
 func (s *RateLimitService) CheckRateLimit(key string) Decision {
-	allowed, remaining := s.limiter.Allow(key)
-	return Decision{Allowed: allowed, Remaining: remaining}
-}
\ No newline at end of file
+	return s.CheckRateLimitPriority(key, ratelimiter.PriorityDefault)
+}
+
+// CheckRateLimitPriority checks a request of priority p. Limiters that don't
+// reserve capacity by priority treat every request alike.
+func (s *RateLimitService) CheckRateLimitPriority(key string, p ratelimiter.Priority) Decision {
+	d := s.decide(key, p)
+	if s.topRequests != nil {
+		s.topRequests.Add(key, 1)
+		if !d.Allowed {
+			s.topDenied.Add(key, 1)
+		}
+	}
+	return d
+}
+
+func (s *RateLimitService) decide(key string, p ratelimiter.Priority) Decision {
+	if s.prefilter != nil {
+		if ok, _ := s.prefilter.Allow(key); !ok {
+			return Decision{Priority: p}
+		}
+	}
+	var allowed bool
+	var remaining int64
+	if pl, ok := s.limiter.(ratelimiter.PriorityLimiter); ok {
+		allowed, remaining = pl.AllowPriority(key, p)
+	} else {
+		allowed, remaining = s.limiter.Allow(key)
+	}
+	return Decision{Allowed: allowed, Remaining: remaining, Priority: p}
+}
+
+// HeavyHitters returns up to k of the most checked and most denied keys,
+// and false if Config.TopK wasn't set. k is capped at Config.TopK.
+func (s *RateLimitService) HeavyHitters(k int) (HeavyHitters, bool) {
+	if s.topRequests == nil {
+		return HeavyHitters{}, false
+	}
+	k = min(k, s.topK)
+	return HeavyHitters{
+		Requests:      s.topRequests.Top(k),
+		Denied:        s.topDenied.Top(k),
+		TotalRequests: s.topRequests.Total(),
+		TotalDenied:   s.topDenied.Total(),
+	}, true
+}
+
+// ErrNotAdaptive is returned by Feedback when Config.Adaptive wasn't set
+var ErrNotAdaptive = errors.New("service: limiter is not adaptive")
+
+// Feedback reports the outcome of a call to the protected backend to an
+// adaptive limiter
+func (s *RateLimitService) Feedback(f ratelimiter.Feedback) error {
+	a, ok := s.Adaptive()
+	if !ok {
+		return ErrNotAdaptive
+	}
+	a.Feedback(f)
+	return nil
+}
+
+// Adaptive returns the limiter if its rate adapts to feedback
+func (s *RateLimitService) Adaptive() (*ratelimiter.AIMD, bool) {
+	a, ok := s.limiter.(*ratelimiter.AIMD)
+	return a, ok
+}
+
+// Acquire takes a slot for a request that should count against the
+// concurrency limit, or returns false if the limit is reached. Release the
+// slot through the token when the request finishes. Without
+// Config.Concurrency every request is admitted, with a nil token whose
+// methods do nothing.
+func (s *RateLimitService) Acquire() (*concurrency.Token, bool) {
+	if s.inflight == nil {
+		return nil, true
+	}
+	return s.inflight.Acquire()
+}
+
+// Concurrency returns the concurrency limiter, if Config.Concurrency was set
+func (s *RateLimitService) Concurrency() (*concurrency.Limiter, bool) {
+	return s.inflight, s.inflight != nil
+}
+
+// ResetHeavyHitters starts counting heavy hitters afresh
+func (s *RateLimitService) ResetHeavyHitters() {
+	if s.topRequests != nil {
+		s.topRequests.Reset()
+		s.topDenied.Reset()
+	}
+}
+
+// PreFilter returns the sketch screening checks, if Config.PreFilter was set
+func (s *RateLimitService) PreFilter() (*ratelimiter.CountMinWindow, bool) {
+	return s.prefilter, s.prefilter != nil
+}
+
+// Replicated returns the limiter if it converges by exchanging counters
+// with other instances rather than deciding each key on one of them
+func (s *RateLimitService) Replicated() (ratelimiter.Replicated, bool) {
+	r, ok := s.limiter.(ratelimiter.Replicated)
+	return r, ok
+}
+
+// Policy returns the name of the configured policy
+func (s *RateLimitService) Policy() string {
+	return s.policy
+}
+
+// StoreStats reports store counters if the backing store tracks them
+func (s *RateLimitService) StoreStats() (store.Stats, bool) {
+	if r, ok := s.store.(store.StatsReporter); ok {
+		return r.Stats(), true
+	}
+	return store.Stats{}, false
+}
+
+// Live reports whether the store's background work is still making progress
+func (s *RateLimitService) Live() error {
+	if c, ok := s.store.(store.LivenessChecker); ok {
+		return c.Alive()
+	}
+	return nil
+}
+
+// Ready reports whether the store backend is reachable. In-memory stores are
+// always reachable.
+func (s *RateLimitService) Ready() error {
+	if p, ok := s.store.(store.Pinger); ok {
+		return p.Ping()
+	}
+	return nil
+}
+
+// ErrSnapshotUnsupported is returned when the store cannot be snapshotted
+var ErrSnapshotUnsupported = errors.New("service: store does not support snapshots")
+
+// SaveSnapshot writes the store's contents to path, replacing it atomically
+func (s *RateLimitService) SaveSnapshot(path string) (int, error) {
+	ss, ok := s.store.(store.Snapshottable)
+	if !ok {
+		return 0, ErrSnapshotUnsupported
+	}
+	return store.SaveSnapshotFile(path, ss)
+}
+
+// RestoreSnapshot loads state saved by SaveSnapshot. Keys that have been
+// written since startup keep their current state.
+func (s *RateLimitService) RestoreSnapshot(path string) (store.RestoreStats, error) {
+	ss, ok := s.store.(store.Snapshottable)
+	if !ok {
+		return store.RestoreStats{}, ErrSnapshotUnsupported
+	}
+	return store.LoadSnapshotFile(path, ss)
+}
+
+// ExportState calls fn with each stored entry until fn returns false. It is
+// how state leaves a node that no longer owns the keys.
+func (s *RateLimitService) ExportState(fn func(store.Entry) bool) error {
+	ss, ok := s.store.(store.Snapshottable)
+	if !ok {
+		return ErrSnapshotUnsupported
+	}
+	ss.Dump(fn)
+	return nil
+}
+
+// ExportEntry returns key's stored entry, for copying to another node
+func (s *RateLimitService) ExportEntry(key string) (store.Entry, bool) {
+	ex, ok := s.store.(store.Exporter)
+	if !ok {
+		return store.Entry{}, false
+	}
+	return ex.Export(key)
+}
+
+// ImportState applies an entry exported by another node and reports whether
+// it was kept. Versioned stores keep whichever write is newer; others keep
+// e only if the key holds no state yet.
+func (s *RateLimitService) ImportState(e store.Entry) (bool, error) {
+	switch st := s.store.(type) {
+	case store.Merger:
+		return st.Merge(e), nil
+	case store.Snapshottable:
+		if e.Deleted {
+			// Without versions there's no telling whether it's newer
+			return false, nil
+		}
+		_, exists := s.store.Get(e.Key)
+		st.Load(e)
+		return !exists, nil
+	}
+	return false, ErrSnapshotUnsupported
+}
+
+// HandOver applies an entry handed over by a key's previous owner. Checks
+// may have reached this node first and started the key afresh, so where the
+// limiter can, the two states are combined into whichever admits less
+// rather than the newer write winning. Otherwise it is ImportState.
+func (s *RateLimitService) HandOver(e store.Entry) (bool, error) {
+	c, ok := s.limiter.(ratelimiter.Combiner)
+	if !ok {
+		return s.ImportState(e)
+	}
+	m, ok := s.store.(store.CombiningMerger)
+	if !ok {
+		return s.ImportState(e)
+	}
+	return m.MergeWith(e, c.Combine), nil
+}
+
+// Close releases the backing store, stopping any background goroutines
+func (s *RateLimitService) Close() error {
+	switch c := s.store.(type) {
+	case interface{ Close() error }:
+		return c.Close()
+	case interface{ Close() }:
+		c.Close()
+	}
+	return nil
+}
+
+// State returns the raw limiter state stored for key. Where the store can
+// export entries it reads without touching the key, so inspecting it neither
+// extends its TTL nor saves it from eviction.
+func (s *RateLimitService) State(key string) (interface{}, bool) {
+	if in, ok := s.limiter.(ratelimiter.Inspector); ok {
+		return in.State(key)
+	}
+	return store.Peek(s.store, key)
+}
+
+// Reset drops all limiter state for key and reports whether it existed
+func (s *RateLimitService) Reset(key string) bool {
+	return s.store.Delete(key)
+}
+
+// Tombstone is Reset for a key other nodes hold copies of: it returns a
+// tombstone to import on them, which also keeps older copies from restoring
+// the key here. Stores without versions return one with a zero version.
+func (s *RateLimitService) Tombstone(key string) (store.Entry, bool) {
+	if t, ok := s.store.(store.Tombstoner); ok {
+		return t.Tombstone(key)
+	}
+	return store.Entry{Key: key, Deleted: true}, s.store.Delete(key)
+}
+
+// Keys lists up to limit keys with the given prefix in lexical order, starting
+// after cursor. The returned cursor is empty when there are no more keys.
+func (s *RateLimitService) Keys(prefix, cursor string, limit int) ([]string, string) {
+	var keys []string
+	s.store.Range(func(key string, _ interface{}) bool {
+		if strings.HasPrefix(key, prefix) && key > cursor {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	sort.Strings(keys)
+	if limit <= 0 || len(keys) <= limit {
+		return keys, ""
+	}
+	keys = keys[:limit]
+	return keys, keys[limit-1]
+}
@@ -3,6 +3,12 @@ package service
 import (
 	"testing"
 	"time"
+
+	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/ratelimiter"
+	"RateLimiterService/pkg/resp"
+	"RateLimiterService/pkg/sketch"
+	"RateLimiterService/pkg/store"
 )
 
 func TestRateLimitService_TokenBucket(t *testing.T) {
@@ -37,8 +43,10 @@ func TestRateLimitService_SlidingWindow(t *testing.T) {
 		WindowSize:  10 * time.Second,
 		MaxRequests: 3,
 		TTL:         1 * time.Hour,
+		Clock:       clock.NewFakeClock(time.Unix(1700000000, 0)),
 	}
 	svc := NewRateLimitService(config)
+	c := config.Clock.(*clock.FakeClock)
 
 	key := "test"
 
@@ -48,7 +56,7 @@ func TestRateLimitService_SlidingWindow(t *testing.T) {
 		if !decision.Allowed {
 			t.Errorf("Expected allow at %d", i)
 		}
-		time.Sleep(1 * time.Second)
+		c.Advance(1 * time.Second)
 	}
 
 	// Deny 4th
@@ -56,4 +64,218 @@ func TestRateLimitService_SlidingWindow(t *testing.T) {
 	if decision.Allowed {
 		t.Error("Expected deny")
 	}
-}
\ No newline at end of file
+
+	// The first request leaves the window 10s after it was made
+	c.Advance(7 * time.Second)
+	if decision := svc.CheckRateLimit(key); !decision.Allowed {
+		t.Error("Expected allow once the window slides")
+	}
+}
+
+func TestRateLimitService_KeysAndReset(t *testing.T) {
+	config := Config{
+		Algorithm: "tokenbucket",
+		Capacity:  5,
+		Rate:      1,
+		TTL:       1 * time.Hour,
+	}
+	svc := NewRateLimitService(config)
+
+	for _, key := range []string{"user:c", "user:a", "user:b", "ip:1"} {
+		svc.CheckRateLimit(key)
+	}
+
+	keys, cursor := svc.Keys("user:", "", 2)
+	if len(keys) != 2 || keys[0] != "user:a" || keys[1] != "user:b" || cursor != "user:b" {
+		t.Fatalf("Unexpected first page %v cursor %q", keys, cursor)
+	}
+	keys, cursor = svc.Keys("user:", cursor, 2)
+	if len(keys) != 1 || keys[0] != "user:c" || cursor != "" {
+		t.Fatalf("Unexpected second page %v cursor %q", keys, cursor)
+	}
+
+	if _, ok := svc.State("user:a"); !ok {
+		t.Fatal("Expected state for user:a")
+	}
+	if !svc.Reset("user:a") {
+		t.Error("Expected reset to report existing key")
+	}
+	if _, ok := svc.State("user:a"); ok {
+		t.Error("Expected state to be gone after reset")
+	}
+	if svc.Reset("user:a") {
+		t.Error("Expected second reset to report missing key")
+	}
+}
+
+func TestRateLimitService_StateLeavesAccessTime(t *testing.T) {
+	fc := clock.NewFakeClock(time.Unix(1700000000, 0))
+	svc := NewRateLimitService(Config{
+		Algorithm: "tokenbucket",
+		Capacity:  5,
+		Rate:      1,
+		TTL:       1 * time.Hour,
+		Clock:     fc,
+	})
+	defer svc.Close()
+
+	svc.CheckRateLimit("user:a")
+	checked := fc.Now()
+	fc.Advance(30 * time.Minute)
+	if _, ok := svc.State("user:a"); !ok {
+		t.Fatal("Expected state for user:a")
+	}
+	e, ok := svc.store.(store.Exporter).Export("user:a")
+	if !ok || !e.LastAccess.Equal(checked) {
+		t.Errorf("Expected inspecting the key to leave its access time at %v, got %v", checked, e.LastAccess)
+	}
+}
+
+func TestRateLimitService_RejectsAlgorithmRedisCannotRun(t *testing.T) {
+	// Nothing is dialled until the first command
+	rs := store.NewRedisStore(resp.NewClient("127.0.0.1:1", resp.Options{}), "test:", time.Hour)
+	defer rs.Close()
+	config := Config{Algorithm: "crdtwindow", WindowSize: time.Second, MaxRequests: 5}
+	if _, err := NewRateLimitServiceWithStore(config, rs); err == nil {
+		t.Error("Expected crdtwindow on the redis store to be rejected")
+	}
+}
+
+func TestRateLimitService_PreFilterKeepsHotKeyOutOfStore(t *testing.T) {
+	config := Config{
+		Algorithm: "tokenbucket",
+		Capacity:  5,
+		Rate:      1,
+		TTL:       1 * time.Hour,
+		Clock:     clock.NewFakeClock(time.Unix(1700000000, 0)),
+		// Above the bucket's 5, as it should be
+		PreFilter: &PreFilter{WindowSize: time.Second, MaxRequests: 8},
+	}
+	svc := NewRateLimitService(config)
+
+	// The exact limiter decides what the pre-filter lets through
+	allowed := 0
+	for i := 0; i < 12; i++ {
+		if svc.CheckRateLimit("k").Allowed {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("Expected the bucket's 5 allowed, got %d", allowed)
+	}
+	if state, _ := svc.State("k"); state.(ratelimiter.TokenBucketState).Tokens != 0 {
+		t.Errorf("Expected the bucket spent, got state %+v", state)
+	}
+
+	pf, ok := svc.PreFilter()
+	if !ok || pf.Denied() != 4 {
+		t.Fatalf("Expected the 4 requests past 8 denied by the pre-filter")
+	}
+	// Once the sketch denies a key, it no longer reaches the store
+	svc.Reset("k")
+	svc.CheckRateLimit("k")
+	if _, ok := svc.State("k"); ok {
+		t.Error("Expected a denied key to stay out of the store")
+	}
+}
+
+func TestRateLimitService_HeavyHitters(t *testing.T) {
+	config := Config{
+		Algorithm: "tokenbucket",
+		Capacity:  5,
+		Rate:      1,
+		TTL:       1 * time.Hour,
+		Clock:     clock.NewFakeClock(time.Unix(1700000000, 0)),
+		TopK:      2,
+	}
+	svc := NewRateLimitService(config)
+	for i := 0; i < 20; i++ {
+		svc.CheckRateLimit("hammer")
+	}
+	for i := 0; i < 4; i++ {
+		svc.CheckRateLimit("busy")
+		svc.CheckRateLimit("quiet")
+	}
+	svc.CheckRateLimit("quiet")
+
+	hh, ok := svc.HeavyHitters(10)
+	if !ok {
+		t.Fatal("Expected heavy hitters to be tracked")
+	}
+	if len(hh.Requests) != 2 || hh.Requests[0].Key != "hammer" || hh.Requests[1].Key != "quiet" {
+		t.Errorf("Unexpected top requests %+v", hh.Requests)
+	}
+	if len(hh.Denied) != 1 || hh.Denied[0] != (sketch.Counted{Key: "hammer", Count: 15}) {
+		t.Errorf("Unexpected top denied %+v", hh.Denied)
+	}
+	if hh.TotalRequests != 29 || hh.TotalDenied != 15 {
+		t.Errorf("Unexpected totals %d and %d", hh.TotalRequests, hh.TotalDenied)
+	}
+
+	svc.ResetHeavyHitters()
+	if hh, _ := svc.HeavyHitters(10); len(hh.Requests) != 0 {
+		t.Errorf("Expected nothing after a reset, got %+v", hh.Requests)
+	}
+}
+
+func TestRateLimitService_Feedback(t *testing.T) {
+	fc := clock.NewFakeClock(time.Unix(1700000000, 0))
+	config := Config{Algorithm: "tokenbucket", Capacity: 5, Rate: 10, TTL: time.Hour, Clock: fc}
+	if err := NewRateLimitService(config).Feedback(ratelimiter.Feedback{}); err != ErrNotAdaptive {
+		t.Fatalf("Expected ErrNotAdaptive, got %v", err)
+	}
+
+	config.Adaptive = &ratelimiter.AIMDOptions{Min: 2}
+	svc := NewRateLimitService(config)
+	if err := svc.Feedback(ratelimiter.Feedback{Error: true}); err != nil {
+		t.Fatal(err)
+	}
+	fc.Advance(time.Second)
+	svc.CheckRateLimit("k")
+	if a, ok := svc.Adaptive(); !ok || a.Rate() != 5 {
+		t.Errorf("Expected the rate halved to 5")
+	}
+}
+
+func TestRateLimitService_Acquire(t *testing.T) {
+	config := Config{Algorithm: "tokenbucket", Capacity: 5, Rate: 10, TTL: time.Hour}
+	svc := NewRateLimitService(config)
+	tok, ok := svc.Acquire()
+	if !ok {
+		t.Fatal("Expected requests admitted without a concurrency limit")
+	}
+	tok.Done()
+
+	config.Concurrency = &Concurrency{Algorithm: "vegas", Initial: 2}
+	svc = NewRateLimitService(config)
+	a, _ := svc.Acquire()
+	svc.Acquire()
+	if _, ok := svc.Acquire(); ok {
+		t.Fatal("Expected a third request over the limit of 2 to be rejected")
+	}
+	a.Done()
+	if l, ok := svc.Concurrency(); !ok || l.Stats().InFlight != 1 {
+		t.Errorf("Expected one request left in flight")
+	}
+}
+
+func TestRateLimitService_CheckRateLimitPriority(t *testing.T) {
+	fc := clock.NewFakeClock(time.Unix(1700000000, 0))
+	config := Config{
+		Algorithm: "tokenbucket", Capacity: 4, Rate: 1, TTL: time.Hour, Clock: fc,
+		Reserved: map[ratelimiter.Priority]float64{ratelimiter.PriorityCritical: 0.5},
+		Adaptive: &ratelimiter.AIMDOptions{},
+	}
+	svc := NewRateLimitService(config)
+
+	svc.CheckRateLimitPriority("k", ratelimiter.PriorityBatch)
+	svc.CheckRateLimit("k")
+	d := svc.CheckRateLimitPriority("k", ratelimiter.PriorityBatch)
+	if d.Allowed || d.Priority != ratelimiter.PriorityBatch {
+		t.Fatalf("Expected batch denied at critical's reserve, got %+v", d)
+	}
+	d = svc.CheckRateLimitPriority("k", ratelimiter.PriorityCritical)
+	if want := (Decision{Allowed: true, Remaining: 1, Priority: ratelimiter.PriorityCritical}); d != want {
+		t.Errorf("Expected %+v through the adaptive wrapper, got %+v", want, d)
+	}
+}
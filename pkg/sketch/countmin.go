@@ -0,0 +1,86 @@
+// Package sketch holds probabilistic summaries whose memory is fixed up front,
+// however many distinct keys they see.
+package sketch
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+	"unsafe"
+)
+
+// CountMin is a Count-Min Sketch: depth rows of width counters, each row
+// indexed by its own hash of the key. Adding to a key increments one counter
+// per row, and a key's estimate is the smallest of its counters. Collisions
+// only ever add to a counter, so estimates never fall below the true count;
+// they exceed it by more than epsilon × the total added with probability at
+// most delta.
+//
+// Add and Estimate are safe for concurrent use. Reset is not atomic with
+// them: an Add racing a Reset may survive it in some rows.
+type CountMin struct {
+	width uint64
+	depth uint64
+	cells []atomic.Uint64
+}
+
+// NewCountMin returns a sketch sized for the given error and failure
+// probability: ceil(e/epsilon) counters per row, ceil(ln(1/delta)) rows. It
+// panics unless both are between 0 and 1 exclusive.
+func NewCountMin(epsilon, delta float64) *CountMin {
+	if !(epsilon > 0 && epsilon < 1) || !(delta > 0 && delta < 1) {
+		panic(fmt.Sprintf("sketch: epsilon and delta must be between 0 and 1 exclusive, got %v and %v", epsilon, delta))
+	}
+	width := uint64(math.Ceil(math.E / epsilon))
+	depth := uint64(math.Ceil(math.Log(1 / delta)))
+	return &CountMin{
+		width: max(width, 1),
+		depth: max(depth, 1),
+		cells: make([]atomic.Uint64, max(width, 1)*max(depth, 1)),
+	}
+}
+
+// indexes derives every row's column from one 64-bit hash, by double hashing
+func (c *CountMin) indexes(key string, fn func(i uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	for row := uint64(0); row < c.depth; row++ {
+		fn(row*c.width + (h1+row*h2)%c.width)
+	}
+}
+
+// Add counts n more occurrences of key
+func (c *CountMin) Add(key string, n uint64) {
+	c.indexes(key, func(i uint64) {
+		c.cells[i].Add(n)
+	})
+}
+
+// Estimate returns an upper bound on the occurrences of key counted since
+// the last Reset
+func (c *CountMin) Estimate(key string) uint64 {
+	est := uint64(math.MaxUint64)
+	c.indexes(key, func(i uint64) {
+		est = min(est, c.cells[i].Load())
+	})
+	return est
+}
+
+// Reset zeroes every counter
+func (c *CountMin) Reset() {
+	for i := range c.cells {
+		c.cells[i].Store(0)
+	}
+}
+
+// Width and Depth return the sketch's dimensions
+func (c *CountMin) Width() int { return int(c.width) }
+func (c *CountMin) Depth() int { return int(c.depth) }
+
+// Size returns the bytes the counters take
+func (c *CountMin) Size() int {
+	return len(c.cells) * int(unsafe.Sizeof(atomic.Uint64{}))
+}
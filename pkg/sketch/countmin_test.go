@@ -0,0 +1,70 @@
+package sketch
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCountMinNeverUnderCounts(t *testing.T) {
+	c := NewCountMin(0.01, 0.01)
+	counts := make(map[string]uint64)
+	var total uint64
+	for i := 0; i < 20000; i++ {
+		// A few heavy keys among many light ones
+		key := fmt.Sprintf("k%d", i%5000)
+		if i%10 == 0 {
+			key = fmt.Sprintf("heavy%d", i%3)
+		}
+		c.Add(key, 1)
+		counts[key]++
+		total++
+	}
+
+	bound := uint64(0.01 * float64(total))
+	over := 0
+	for key, n := range counts {
+		est := c.Estimate(key)
+		if est < n {
+			t.Fatalf("Estimate(%s) = %d, below the true %d", key, est, n)
+		}
+		if est-n > bound {
+			over++
+		}
+	}
+	// delta allows 1% of keys past the bound; leave slack for the hash
+	if over > len(counts)/50 {
+		t.Errorf("%d of %d keys over-counted by more than %d", over, len(counts), bound)
+	}
+}
+
+func TestCountMinSizeIsFixed(t *testing.T) {
+	c := NewCountMin(0.001, 0.01)
+	if c.Width() != 2719 || c.Depth() != 5 {
+		t.Errorf("Expected a 5×2719 sketch, got %d×%d", c.Depth(), c.Width())
+	}
+	size := c.Size()
+	for i := 0; i < 100000; i++ {
+		c.Add(fmt.Sprintf("spoofed-%d", i), 1)
+	}
+	if c.Size() != size {
+		t.Errorf("Size grew from %d to %d", size, c.Size())
+	}
+
+	c.Reset()
+	if est := c.Estimate("spoofed-1"); est != 0 {
+		t.Errorf("Expected 0 after Reset, got %d", est)
+	}
+}
+
+func TestCountMinRejectsOutOfRangeArguments(t *testing.T) {
+	for _, args := range [][2]float64{{0, 0.01}, {0.01, 0}, {0.01, 2}, {1.5, 0.01}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected NewCountMin(%v, %v) to panic", args[0], args[1])
+				}
+			}()
+			NewCountMin(args[0], args[1])
+		}()
+	}
+}
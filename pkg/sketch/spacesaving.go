@@ -0,0 +1,122 @@
+package sketch
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// Counted is a key with its estimated count. The true count lies between
+// Count-Error and Count.
+type Counted struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error"`
+}
+
+// SpaceSaving finds the most frequent keys in a stream using a fixed number
+// of counters. A key not being counted takes over the smallest counter and
+// inherits its count as error, so every key seen more than total/capacity
+// times is guaranteed a counter, and counts are never too low. It is safe
+// for concurrent use.
+type SpaceSaving struct {
+	mu       sync.Mutex
+	capacity int
+	index    map[string]*ssItem
+	items    ssHeap
+	total    uint64
+}
+
+type ssItem struct {
+	Counted
+	pos int // index in the heap
+}
+
+// ssHeap is a min-heap of counters by count
+type ssHeap []*ssItem
+
+func (h ssHeap) Len() int           { return len(h) }
+func (h ssHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos, h[j].pos = i, j
+}
+func (h *ssHeap) Push(x any) {
+	it := x.(*ssItem)
+	it.pos = len(*h)
+	*h = append(*h, it)
+}
+func (h *ssHeap) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
+
+// NewSpaceSaving returns a summary with capacity counters
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	capacity = max(capacity, 1)
+	return &SpaceSaving{
+		capacity: capacity,
+		index:    make(map[string]*ssItem, capacity),
+		items:    make(ssHeap, 0, capacity),
+	}
+}
+
+// Add counts n more occurrences of key
+func (s *SpaceSaving) Add(key string, n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total += n
+	if it, ok := s.index[key]; ok {
+		it.Count += n
+		heap.Fix(&s.items, it.pos)
+		return
+	}
+	if len(s.items) < s.capacity {
+		it := &ssItem{Counted: Counted{Key: key, Count: n}}
+		s.index[key] = it
+		heap.Push(&s.items, it)
+		return
+	}
+	// Replace the least counted key
+	it := s.items[0]
+	delete(s.index, it.Key)
+	it.Key, it.Error = key, it.Count
+	it.Count += n
+	s.index[key] = it
+	heap.Fix(&s.items, 0)
+}
+
+// Top returns up to k keys with the highest counts, highest first
+func (s *SpaceSaving) Top(k int) []Counted {
+	s.mu.Lock()
+	out := make([]Counted, 0, len(s.items))
+	for _, it := range s.items {
+		out = append(out, it.Counted)
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out[:min(k, len(out))]
+}
+
+// Total returns the sum of everything added since the last Reset
+func (s *SpaceSaving) Total() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+// Reset forgets every key
+func (s *SpaceSaving) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index = make(map[string]*ssItem, s.capacity)
+	s.items = s.items[:0]
+	s.total = 0
+}
@@ -0,0 +1,54 @@
+package sketch
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSpaceSavingFindsHeavyHitters(t *testing.T) {
+	s := NewSpaceSaving(20)
+	// Three heavy keys hidden among 10000 distinct light ones
+	for i := 0; i < 10000; i++ {
+		s.Add(fmt.Sprintf("light-%d", i), 1)
+		switch i % 10 {
+		case 0, 1, 2:
+			s.Add("attacker", 1)
+		case 3, 4:
+			s.Add("crawler", 1)
+		case 5:
+			s.Add("batch", 1)
+		}
+	}
+
+	top := s.Top(3)
+	want := []string{"attacker", "crawler", "batch"}
+	if len(top) != 3 {
+		t.Fatalf("Expected 3 keys, got %v", top)
+	}
+	truth := map[string]uint64{"attacker": 3000, "crawler": 2000, "batch": 1000}
+	for i, c := range top {
+		if c.Key != want[i] {
+			t.Errorf("Rank %d is %s, want %s", i, c.Key, want[i])
+		}
+		if c.Count < truth[c.Key] || c.Count-c.Error > truth[c.Key] {
+			t.Errorf("%s: count %d ± %d doesn't bound the true %d", c.Key, c.Count, c.Error, truth[c.Key])
+		}
+	}
+	if s.Total() != 16000 {
+		t.Errorf("Expected a total of 16000, got %d", s.Total())
+	}
+}
+
+func TestSpaceSavingReset(t *testing.T) {
+	s := NewSpaceSaving(2)
+	s.Add("a", 3)
+	s.Add("b", 1)
+	s.Add("c", 1) // takes over b's counter
+	if top := s.Top(5); len(top) != 2 || top[1] != (Counted{Key: "c", Count: 2, Error: 1}) {
+		t.Fatalf("Unexpected top %v", top)
+	}
+	s.Reset()
+	if top := s.Top(5); len(top) != 0 || s.Total() != 0 {
+		t.Errorf("Expected nothing after Reset, got %v", top)
+	}
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Codec converts one value type to and from its persisted form. Encode must
+// produce valid JSON so snapshots and logs stay human-readable.
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+type codecEntry struct {
+	name  string
+	codec Codec
+}
+
+var codecs = struct {
+	sync.RWMutex
+	byName map[string]codecEntry
+	byType map[reflect.Type]codecEntry
+}{
+	byName: make(map[string]codecEntry),
+	byType: make(map[reflect.Type]codecEntry),
+}
+
+// RegisterCodec makes values with the same dynamic type as sample persistable
+// under name. Packages that keep their own state types in a Store register
+// them from init. Registering a name or type twice panics.
+func RegisterCodec(name string, sample interface{}, c Codec) {
+	typ := reflect.TypeOf(sample)
+	codecs.Lock()
+	defer codecs.Unlock()
+	if _, dup := codecs.byName[name]; dup {
+		panic(fmt.Sprintf("store: codec %q registered twice", name))
+	}
+	if _, dup := codecs.byType[typ]; dup {
+		panic(fmt.Sprintf("store: codec for %s registered twice", typ))
+	}
+	e := codecEntry{name: name, codec: c}
+	codecs.byName[name] = e
+	codecs.byType[typ] = e
+}
+
+// EncodeValue returns the registered type name and encoded form of v
+func EncodeValue(v interface{}) (string, []byte, error) {
+	codecs.RLock()
+	e, ok := codecs.byType[reflect.TypeOf(v)]
+	codecs.RUnlock()
+	if !ok {
+		return "", nil, fmt.Errorf("store: no codec registered for %T", v)
+	}
+	data, err := e.codec.Encode(v)
+	return e.name, data, err
+}
+
+// DecodeValue decodes data with the codec registered under name
+func DecodeValue(name string, data []byte) (interface{}, error) {
+	codecs.RLock()
+	e, ok := codecs.byName[name]
+	codecs.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("store: no codec registered for type %q", name)
+	}
+	return e.codec.Decode(data)
+}
+
+// JSONCodec encodes values of type T with encoding/json
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v.(T))
+}
+
+func (JSONCodec[T]) Decode(data []byte) (interface{}, error) {
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"container/list"
+	"fmt"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+// EvictionPolicy chooses which key a bounded store drops to make room
+type EvictionPolicy int
+
+const (
+	// EvictLRU drops the least recently used key (the default)
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU drops the least frequently used key, oldest first among ties
+	EvictLFU
+	// EvictTTL never evicts for capacity; keys leave only by TTL cleanup
+	EvictTTL
+)
+
+// ParseEvictionPolicy accepts "lru", "lfu" and "ttl"; empty means lru
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	switch s {
+	case "", "lru":
+		return EvictLRU, nil
+	case "lfu":
+		return EvictLFU, nil
+	case "ttl":
+		return EvictTTL, nil
+	}
+	return 0, fmt.Errorf("invalid eviction policy %q", s)
+}
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case EvictLRU:
+		return "lru"
+	case EvictLFU:
+		return "lfu"
+	case EvictTTL:
+		return "ttl"
+	}
+	return fmt.Sprintf("EvictionPolicy(%d)", int(p))
+}
+
+// EvictReason says why an entry left the store
+type EvictReason int
+
+const (
+	// EvictCapacity means the entry was dropped to stay within the store's bound
+	EvictCapacity EvictReason = iota
+	// EvictExpired means the entry was idle for longer than the TTL
+	EvictExpired
+)
+
+func (r EvictReason) String() string {
+	if r == EvictExpired {
+		return "expired"
+	}
+	return "capacity"
+}
+
+// item is a stored value plus its bookkeeping for the eviction order
+type item struct {
+	key        string
+	value      interface{}
+	lastAccess time.Time
+	size       int64 // estimated bytes, from sizeOf
+	version    clock.Timestamp
+	elem       *list.Element // position within the LRU list or LFU bucket
+	bucket     *list.Element // LFU only: the frequency bucket holding elem
+}
+
+// evictor keeps items in eviction order. Every method is O(1).
+type evictor interface {
+	add(it *item)
+	touch(it *item)
+	remove(it *item)
+	victim() *item       // nil when empty
+	next(it *item) *item // the victim after it, or nil
+}
+
+func newEvictor(p EvictionPolicy) evictor {
+	switch p {
+	case EvictLFU:
+		return &lfu{buckets: list.New()}
+	case EvictTTL:
+		return nil
+	}
+	return &lru{order: list.New()}
+}
+
+// lru is a recency list, most recent at the front
+type lru struct {
+	order *list.List
+}
+
+func (l *lru) add(it *item)    { it.elem = l.order.PushFront(it) }
+func (l *lru) touch(it *item)  { l.order.MoveToFront(it.elem) }
+func (l *lru) remove(it *item) { l.order.Remove(it.elem) }
+
+func (l *lru) victim() *item {
+	if e := l.order.Back(); e != nil {
+		return e.Value.(*item)
+	}
+	return nil
+}
+
+func (l *lru) next(it *item) *item {
+	if e := it.elem.Prev(); e != nil {
+		return e.Value.(*item)
+	}
+	return nil
+}
+
+// lfu is the constant-time LFU of Shah, Mitra and Matani: a list of buckets
+// in ascending frequency, each holding its items most recent first. A touch
+// moves an item to the next bucket, creating it if needed.
+type lfu struct {
+	buckets *list.List
+}
+
+type lfuBucket struct {
+	freq  uint64
+	items *list.List
+}
+
+func (l *lfu) add(it *item) {
+	front := l.buckets.Front()
+	if front == nil || front.Value.(*lfuBucket).freq != 1 {
+		front = l.buckets.PushFront(&lfuBucket{freq: 1, items: list.New()})
+	}
+	it.bucket = front
+	it.elem = front.Value.(*lfuBucket).items.PushFront(it)
+}
+
+func (l *lfu) touch(it *item) {
+	cur := it.bucket
+	b := cur.Value.(*lfuBucket)
+	next := cur.Next()
+	if next == nil || next.Value.(*lfuBucket).freq != b.freq+1 {
+		next = l.buckets.InsertAfter(&lfuBucket{freq: b.freq + 1, items: list.New()}, cur)
+	}
+	l.remove(it)
+	it.bucket = next
+	it.elem = next.Value.(*lfuBucket).items.PushFront(it)
+}
+
+func (l *lfu) remove(it *item) {
+	b := it.bucket.Value.(*lfuBucket)
+	b.items.Remove(it.elem)
+	if b.items.Len() == 0 {
+		l.buckets.Remove(it.bucket)
+	}
+}
+
+func (l *lfu) victim() *item {
+	if front := l.buckets.Front(); front != nil {
+		return front.Value.(*lfuBucket).items.Back().Value.(*item)
+	}
+	return nil
+}
+
+func (l *lfu) next(it *item) *item {
+	if e := it.elem.Prev(); e != nil {
+		return e.Value.(*item)
+	}
+	if b := it.bucket.Next(); b != nil {
+		return b.Value.(*lfuBucket).items.Back().Value.(*item)
+	}
+	return nil
+}
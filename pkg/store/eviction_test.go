@@ -0,0 +1,195 @@
+package store
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+func newEvictingStore(t *testing.T, policy EvictionPolicy, maxKeys int) (*InMemoryStore, *[]string) {
+	t.Helper()
+	var evicted []string
+	s := NewInMemoryStoreWithOptions(Options{
+		TTL:      time.Hour,
+		MaxKeys:  maxKeys,
+		Eviction: policy,
+		OnEvict: func(e Entry, reason EvictReason) {
+			if reason != EvictCapacity {
+				t.Errorf("Unexpected reason %v for %s", reason, e.Key)
+			}
+			evicted = append(evicted, e.Key)
+		},
+	})
+	t.Cleanup(s.Close)
+	return s, &evicted
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	s, evicted := newEvictingStore(t, EvictLRU, 3)
+	s.Set("a", 1)
+	s.Set("b", 2)
+	s.Set("c", 3)
+	s.Get("a") // b is now the least recently used
+	s.Set("d", 4)
+	s.Update("c", func(old interface{}, exists bool) interface{} { return 30 })
+	s.Set("e", 5)
+
+	if want := []string{"b", "a"}; !reflect.DeepEqual(*evicted, want) {
+		t.Errorf("Evicted %v, expected %v", *evicted, want)
+	}
+	if stats := s.Stats(); stats.Keys != 3 || stats.Evictions != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestLFUEvictsLeastFrequentlyUsed(t *testing.T) {
+	s, evicted := newEvictingStore(t, EvictLFU, 3)
+	s.Set("a", 1)
+	s.Set("b", 2)
+	s.Set("c", 3)
+	for i := 0; i < 3; i++ {
+		s.Get("a")
+	}
+	s.Get("b")
+	s.Set("d", 4) // c has the fewest uses
+	s.Set("e", 5) // d is newest but least used; ties go to the oldest
+	s.Get("e")
+	s.Set("f", 6) // b and e tie at two uses, b is older
+
+	if want := []string{"c", "d", "b"}; !reflect.DeepEqual(*evicted, want) {
+		t.Errorf("Evicted %v, expected %v", *evicted, want)
+	}
+	if _, ok := s.Get("a"); !ok {
+		t.Error("Expected the most used key to survive")
+	}
+}
+
+func TestTTLPolicyIgnoresMaxKeys(t *testing.T) {
+	s, evicted := newEvictingStore(t, EvictTTL, 2)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		s.Set(k, k)
+	}
+	if len(*evicted) != 0 || s.Stats().Keys != 4 {
+		t.Errorf("Expected no capacity eviction, evicted %v", *evicted)
+	}
+}
+
+func TestOnEvictReportsExpiry(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	// The cleanup goroutine also ticks as the clock advances, so guard reasons
+	var mu sync.Mutex
+	var reasons []EvictReason
+	s := NewInMemoryStoreWithOptions(Options{
+		TTL:   time.Hour,
+		Clock: c,
+		OnEvict: func(e Entry, reason EvictReason) {
+			mu.Lock()
+			reasons = append(reasons, reason)
+			mu.Unlock()
+		},
+	})
+	defer s.Close()
+	s.Set("stale", 1)
+	c.Advance(50 * time.Minute)
+	s.Set("fresh", 2)
+	c.Advance(15 * time.Minute)
+	s.cleanup()
+
+	mu.Lock()
+	if !reflect.DeepEqual(reasons, []EvictReason{EvictExpired}) {
+		t.Errorf("Unexpected reasons %v", reasons)
+	}
+	mu.Unlock()
+	if _, ok := s.Get("fresh"); !ok {
+		t.Error("Expected fresh key to survive cleanup")
+	}
+	if _, ok := s.Get("stale"); ok {
+		t.Error("Expected stale key to expire")
+	}
+}
+
+func TestCleanupRunsOnClockTicks(t *testing.T) {
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	expired := make(chan string, 1)
+	s := NewInMemoryStoreWithOptions(Options{
+		TTL:     time.Hour,
+		Clock:   c,
+		OnEvict: func(e Entry, reason EvictReason) { expired <- e.Key },
+	})
+	defer s.Close()
+	c.BlockUntil(1) // the cleanup ticker
+	s.Set("k", 1)
+
+	c.Advance(90 * time.Minute)
+	select {
+	case key := <-expired:
+		if key != "k" {
+			t.Errorf("Expired %q", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cleanup did not run on the fake clock's ticks")
+	}
+}
+
+func TestParseEvictionPolicy(t *testing.T) {
+	for _, p := range []EvictionPolicy{EvictLRU, EvictLFU, EvictTTL} {
+		if got, err := ParseEvictionPolicy(p.String()); err != nil || got != p {
+			t.Errorf("ParseEvictionPolicy(%q) = %v, %v", p, got, err)
+		}
+	}
+	if _, err := ParseEvictionPolicy("fifo"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
+
+func BenchmarkLRUEvictionAtCapacity(b *testing.B) {
+	s := NewInMemoryStoreWithOptions(Options{TTL: time.Hour, MaxKeys: 100000})
+	defer s.Close()
+	for i := 0; i < b.N; i++ {
+		s.Set(strconv.Itoa(i), i)
+	}
+}
+
+type sizedValue int
+
+func (v sizedValue) Size() int { return int(v) }
+
+func TestMaxBytesEvictsBySize(t *testing.T) {
+	per := sizeOf("a", sizedValue(100))
+	s, evicted := newEvictingStore(t, EvictLRU, 0)
+	s.maxBytes = 3 * per
+
+	s.Set("a", sizedValue(100))
+	s.Set("b", sizedValue(100))
+	s.Set("c", sizedValue(100))
+	if len(*evicted) != 0 {
+		t.Fatalf("Evicted %v within budget", *evicted)
+	}
+	// Growing one value pushes the oldest others out, never the one written
+	s.Update("c", func(old interface{}, exists bool) interface{} { return sizedValue(100 + 2*int(per)) })
+	if want := []string{"a", "b"}; !reflect.DeepEqual(*evicted, want) {
+		t.Errorf("Evicted %v, expected %v", *evicted, want)
+	}
+	if stats := s.Stats(); stats.Keys != 1 || stats.Bytes != sizeOf("c", sizedValue(100+2*int(per))) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	s.Delete("c")
+	if bytes := s.Stats().Bytes; bytes != 0 {
+		t.Errorf("Expected 0 bytes after delete, got %d", bytes)
+	}
+}
+
+func TestMaxBytesSparesNewLFUEntry(t *testing.T) {
+	s, evicted := newEvictingStore(t, EvictLFU, 0)
+	s.maxBytes = sizeOf("a", sizedValue(0))
+	s.Set("a", sizedValue(0))
+	s.Get("a")
+	s.Set("b", sizedValue(0)) // b has the lowest count, but is the entry just written
+	if want := []string{"a"}; !reflect.DeepEqual(*evicted, want) {
+		t.Errorf("Evicted %v, expected %v", *evicted, want)
+	}
+}
@@ -0,0 +1,222 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+type node struct {
+	phys  *clock.FakeClock
+	hlc   *clock.HLC
+	store *InMemoryStore
+}
+
+// newSkewedNodes returns nodes whose physical clocks are offset from each
+// other by the given amounts
+func newSkewedNodes(t *testing.T, offsets ...time.Duration) []*node {
+	t.Helper()
+	base := time.Unix(1700000000, 0)
+	nodes := make([]*node, len(offsets))
+	for i, off := range offsets {
+		n := &node{phys: clock.NewFakeClock(base.Add(off))}
+		n.hlc = clock.NewHLC(fmt.Sprintf("n%d", i), n.phys, 0)
+		n.store = NewInMemoryStoreWithOptions(Options{TTL: time.Hour, Clock: n.hlc, HLC: n.hlc})
+		t.Cleanup(n.store.Close)
+		nodes[i] = n
+	}
+	return nodes
+}
+
+func (n *node) entry(key string) Entry {
+	e, _ := n.store.Export(key)
+	return e
+}
+
+func TestMergeOrdersCausallyDespiteSkew(t *testing.T) {
+	// n0 runs ten seconds ahead of n1
+	nodes := newSkewedNodes(t, 10*time.Second, 0)
+	fast, slow := nodes[0], nodes[1]
+
+	fast.store.Set("k", testState{N: 1})
+	if !slow.store.Merge(fast.entry("k")) {
+		t.Fatal("Expected the first replicated write to apply")
+	}
+	// Wall clock alone would order this write ten seconds before the one it follows
+	slow.store.Set("k", testState{N: 2})
+	if !fast.store.Merge(slow.entry("k")) {
+		t.Fatal("Expected the causally later write to win on the fast node")
+	}
+	if v, _ := fast.store.Get("k"); v != (testState{N: 2}) {
+		t.Errorf("fast has %v", v)
+	}
+}
+
+func TestMergeConvergesInAnyOrder(t *testing.T) {
+	nodes := newSkewedNodes(t, 3*time.Second, 0, -3*time.Second)
+
+	// Concurrent writes with no communication between them
+	var writes []Entry
+	for i, n := range nodes {
+		n.store.Set("k", testState{N: i})
+		writes = append(writes, n.entry("k"))
+	}
+	// Deliver every write to every node, each in a different order
+	for i, n := range nodes {
+		for j := range writes {
+			n.store.Merge(writes[(i+j)%len(writes)])
+		}
+	}
+
+	want := nodes[0].entry("k")
+	for i, n := range nodes {
+		if got := n.entry("k"); got.Value != want.Value || got.Version != want.Version {
+			t.Errorf("n%d has %v@%v, n0 has %v@%v", i, got.Value, got.Version, want.Value, want.Version)
+		}
+	}
+	// The fastest clock issued the highest version
+	if want.Value != (testState{N: 0}) {
+		t.Errorf("Expected the write with the highest HLC to win, got %v", want.Value)
+	}
+}
+
+func TestMergeRejectsStaleEntries(t *testing.T) {
+	nodes := newSkewedNodes(t, 0)
+	s := nodes[0].store
+	s.Set("k", testState{N: 1})
+	old := nodes[0].entry("k")
+	s.Set("k", testState{N: 2})
+	if s.Merge(old) {
+		t.Error("Expected an older version to be ignored")
+	}
+	if v, _ := s.Get("k"); v != (testState{N: 2}) {
+		t.Errorf("k = %v", v)
+	}
+}
+
+func TestVersionsSurviveWALReplayAndCompaction(t *testing.T) {
+	dir := t.TempDir()
+	open := func(compactBytes int64) *WALStore {
+		h := clock.NewHLC("n0", clock.NewFakeClock(time.Unix(1700000000, 0)), 0)
+		s, err := OpenWALStore(WALOptions{Dir: dir, TTL: time.Hour, Sync: SyncAlways,
+			CompactBytes: compactBytes, Clock: h, HLC: h})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	version := func(s *WALStore, key string) (v clock.Timestamp) {
+		s.Dump(func(e Entry) bool {
+			if e.Key == key {
+				v = e.Version
+			}
+			return true
+		})
+		return v
+	}
+
+	s := open(0)
+	s.Set("a", testState{N: 1})
+	s.Update("b", func(old interface{}, exists bool) interface{} { return testState{N: 2} })
+	want := map[string]clock.Timestamp{"a": version(s, "a"), "b": version(s, "b")}
+	if want["a"].IsZero() || !want["a"].Before(want["b"]) {
+		t.Fatalf("Unexpected versions %v", want)
+	}
+	s.Close()
+
+	s = open(0)
+	for k, v := range want {
+		if got := version(s, k); got != v {
+			t.Errorf("%s replayed with version %v, want %v", k, got, v)
+		}
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = open(0)
+	defer s.Close()
+	for k, v := range want {
+		if got := version(s, k); got != v {
+			t.Errorf("%s restored from snapshot with version %v, want %v", k, got, v)
+		}
+	}
+	// The reopened clock resumes after everything it has stored
+	s.Set("c", testState{N: 3})
+	if c := version(s, "c"); !want["b"].Before(c) {
+		t.Errorf("New write %v ordered before stored %v", c, want["b"])
+	}
+}
+
+func TestTombstoneKeepsOlderCopiesOut(t *testing.T) {
+	nodes := newSkewedNodes(t, 0, 0)
+	owner, replica := nodes[0], nodes[1]
+	owner.store.Set("k", testState{N: 1})
+	copied := owner.entry("k")
+	replica.store.Merge(copied)
+
+	tomb, existed := owner.store.Tombstone("k")
+	if !existed || !tomb.Deleted || !copied.Version.Before(tomb.Version) {
+		t.Fatalf("Tombstone = %+v, %v", tomb, existed)
+	}
+	// A copy still in flight from before the delete
+	if owner.store.Merge(copied) {
+		t.Error("Expected a copy older than the tombstone to be refused")
+	}
+	if !replica.store.Merge(tomb) {
+		t.Fatal("Expected the replica to take the tombstone")
+	}
+	if _, ok := replica.store.Get("k"); ok {
+		t.Error("Expected the tombstone to delete the replica's copy")
+	}
+	if replica.store.Merge(copied) {
+		t.Error("Expected the replica to refuse the older copy too")
+	}
+
+	// Writes after the delete are unaffected
+	replica.phys.Advance(time.Second)
+	replica.store.Set("k", testState{N: 2})
+	if !owner.store.Merge(replica.entry("k")) {
+		t.Error("Expected a write newer than the tombstone to apply")
+	}
+
+	// The tombstone goes once the TTL has passed
+	owner.store.Tombstone("k")
+	owner.phys.Advance(2 * time.Hour)
+	owner.store.cleanup()
+	if n := len(owner.store.tombstones); n != 0 {
+		t.Errorf("Expected tombstones to expire, %d left", n)
+	}
+}
+
+func TestTombstoneSurvivesWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *WALStore {
+		h := clock.NewHLC("n0", clock.NewFakeClock(time.Unix(1700000000, 0)), 0)
+		s, err := OpenWALStore(WALOptions{Dir: dir, TTL: time.Hour, Sync: SyncAlways, Clock: h, HLC: h})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	s := open()
+	s.Set("k", testState{N: 1})
+	old, _ := s.Export("k")
+	if _, existed := s.Tombstone("k"); !existed {
+		t.Error("Expected Tombstone to report the key existed")
+	}
+	s.Close()
+
+	s = open()
+	defer s.Close()
+	if _, ok := s.Get("k"); ok {
+		t.Error("Expected the key to stay deleted")
+	}
+	if s.Merge(old) {
+		t.Error("Expected the replayed tombstone to refuse the older copy")
+	}
+}
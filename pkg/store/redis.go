@@ -0,0 +1,263 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"RateLimiterService/pkg/resp"
+)
+
+// RedisStore implements Store on a Redis-compatible server, so several limiter
+// instances share one keyspace. Values are stored as JSON with their codec
+// name and expire after ttl of inactivity.
+//
+// Store methods have no error return; the last failure is kept for Err, and
+// Ping reports whether the server is reachable. Limiters admit requests they
+// can't record unless SetFailOpen(false) is called.
+type RedisStore struct {
+	client   *resp.Client
+	prefix   string
+	ttl      time.Duration
+	failOpen bool
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+type redisEnvelope struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// NewRedisStore namespaces all keys under prefix
+func NewRedisStore(client *resp.Client, prefix string, ttl time.Duration) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix, ttl: ttl, failOpen: true}
+}
+
+// SetFailOpen sets whether limiters admit requests while the server can't be
+// reached (the default) or deny them. Call it before the store is used.
+func (s *RedisStore) SetFailOpen(on bool) {
+	s.failOpen = on
+}
+
+// FailOpen implements FailOpener
+func (s *RedisStore) FailOpen() bool {
+	return s.failOpen
+}
+
+// Client returns the underlying connection pool, for limiters that run
+// server-side scripts
+func (s *RedisStore) Client() *resp.Client {
+	return s.client
+}
+
+// Prefix returns the namespace prepended to every key
+func (s *RedisStore) Prefix() string {
+	return s.prefix
+}
+
+// TTL returns how long an untouched entry is kept
+func (s *RedisStore) TTL() time.Duration {
+	return s.ttl
+}
+
+func (s *RedisStore) Get(key string) (interface{}, bool) {
+	raw, err := resp.String(s.client.Do("GET", s.prefix+key))
+	if err == resp.ErrNil {
+		return nil, false
+	}
+	if err != nil {
+		s.fail(err)
+		return nil, false
+	}
+	val, err := decodeEnvelope(raw)
+	if err != nil {
+		s.fail(err)
+		return nil, false
+	}
+	if s.ttl > 0 {
+		// Keep parity with InMemoryStore, where reads refresh the TTL
+		s.client.Do("PEXPIRE", s.prefix+key, s.ttl.Milliseconds())
+	}
+	return val, true
+}
+
+func (s *RedisStore) Set(key string, value interface{}) {
+	data, err := encodeEnvelope(value)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	args := []interface{}{"SET", s.prefix + key, data}
+	if s.ttl > 0 {
+		args = append(args, "PX", s.ttl.Milliseconds())
+	}
+	if _, err := s.client.Do(args...); err != nil {
+		s.fail(err)
+	}
+}
+
+// redisUpdateAttempts bounds optimistic retries when other writers keep winning
+const redisUpdateAttempts = 32
+
+// ErrUpdateConflict is kept by RedisStore when Update gives up after repeated conflicts
+var ErrUpdateConflict = errors.New("store: too many concurrent updates")
+
+// Update is an optimistic WATCH/MULTI/EXEC transaction, retried when another
+// client writes the key between our read and our write
+func (s *RedisStore) Update(key string, fn func(old interface{}, exists bool) interface{}) {
+	s.UpdateErr(key, fn)
+}
+
+// UpdateErr is Update returning why nothing was committed: a server error, or
+// ErrUpdateConflict once other writers have won redisUpdateAttempts times
+func (s *RedisStore) UpdateErr(key string, fn func(old interface{}, exists bool) interface{}) error {
+	full := s.prefix + key
+	for attempt := 0; attempt < redisUpdateAttempts; attempt++ {
+		var committed bool
+		err := s.client.WithConn(func(cn *resp.Conn) error {
+			if _, err := cn.Do("WATCH", full); err != nil {
+				return err
+			}
+			var old interface{}
+			raw, err := resp.String(cn.Do("GET", full))
+			exists := err == nil
+			if err != nil && err != resp.ErrNil {
+				cn.Do("UNWATCH")
+				return err
+			}
+			if exists {
+				if old, err = decodeEnvelope(raw); err != nil {
+					cn.Do("UNWATCH")
+					return err
+				}
+			}
+			data, err := encodeEnvelope(fn(old, exists))
+			if err != nil {
+				cn.Do("UNWATCH")
+				return err
+			}
+			if _, err := cn.Do("MULTI"); err != nil {
+				return err
+			}
+			args := []interface{}{"SET", full, data}
+			if s.ttl > 0 {
+				args = append(args, "PX", s.ttl.Milliseconds())
+			}
+			if _, err := cn.Do(args...); err != nil {
+				cn.Do("DISCARD")
+				return err
+			}
+			reply, err := cn.Do("EXEC")
+			if err != nil {
+				return err
+			}
+			committed = reply != nil
+			return nil
+		})
+		if err != nil {
+			s.fail(err)
+			return err
+		}
+		if committed {
+			return nil
+		}
+		// Jittered exponential backoff so contending writers stop colliding in lockstep
+		ceiling := int64(100*time.Microsecond) << min(attempt, 6)
+		time.Sleep(time.Duration(rand.Int63n(ceiling)))
+	}
+	s.fail(ErrUpdateConflict)
+	return ErrUpdateConflict
+}
+
+func (s *RedisStore) Delete(key string) bool {
+	n, err := resp.Int64(s.client.Do("DEL", s.prefix+key))
+	if err != nil {
+		s.fail(err)
+		return false
+	}
+	return n > 0
+}
+
+// Range scans keys under the prefix. Keys written by server-side limiter
+// scripts are not codec-encoded and are passed to fn with a nil value.
+func (s *RedisStore) Range(fn func(key string, value interface{}) bool) {
+	pattern := escapeGlob(s.prefix) + "*"
+	cursor := "0"
+	for {
+		reply, err := s.client.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 100)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return
+		}
+		cursor, _ = page[0].(string)
+		keys, _ := page[1].([]interface{})
+		for _, k := range keys {
+			full, _ := k.(string)
+			var val interface{}
+			if raw, err := resp.String(s.client.Do("GET", full)); err == nil {
+				val, _ = decodeEnvelope(raw)
+			}
+			if !fn(strings.TrimPrefix(full, s.prefix), val) {
+				return
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+// Ping checks that the server is reachable
+func (s *RedisStore) Ping() error {
+	_, err := s.client.Do("PING")
+	return err
+}
+
+// Err returns the most recent error from a Store method
+func (s *RedisStore) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+func (s *RedisStore) fail(err error) {
+	s.mu.Lock()
+	s.lastErr = err
+	s.mu.Unlock()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+func encodeEnvelope(v interface{}) (string, error) {
+	typ, data, err := EncodeValue(v)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(redisEnvelope{Type: typ, Value: data})
+	return string(b), err
+}
+
+func decodeEnvelope(raw string) (interface{}, error) {
+	var env redisEnvelope
+	if err := json.Unmarshal([]byte(raw), &env); err != nil {
+		return nil, err
+	}
+	return DecodeValue(env.Type, env.Value)
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+func escapeGlob(s string) string {
+	return globEscaper.Replace(s)
+}
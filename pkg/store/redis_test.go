@@ -0,0 +1,80 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/resp"
+	"RateLimiterService/pkg/resp/resptest"
+)
+
+func TestRedisStore(t *testing.T) {
+	srv, err := resptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	s := NewRedisStore(resp.NewClient(srv.Addr(), resp.Options{}), "rl:", time.Hour)
+	defer s.Close()
+
+	if err := s.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	s.Set("a", testState{N: 1})
+	s.Set("b", testState{N: 2})
+	if v, ok := s.Get("a"); !ok || v != (testState{N: 1}) {
+		t.Errorf("Get(a) = %v, %v", v, ok)
+	}
+
+	seen := map[string]interface{}{}
+	s.Range(func(key string, value interface{}) bool {
+		seen[key] = value
+		return true
+	})
+	if len(seen) != 2 || seen["b"] != (testState{N: 2}) {
+		t.Errorf("Range saw %v", seen)
+	}
+
+	if !s.Delete("a") || s.Delete("a") {
+		t.Error("Expected Delete to report presence once")
+	}
+	if _, ok := s.Get("a"); ok {
+		t.Error("Expected a to be gone")
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestRedisStoreUpdateErrReportsConflict(t *testing.T) {
+	srv, err := resptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	s := NewRedisStore(resp.NewClient(srv.Addr(), resp.Options{}), "rl:", time.Hour)
+	defer s.Close()
+	other := NewRedisStore(resp.NewClient(srv.Addr(), resp.Options{}), "rl:", time.Hour)
+	defer other.Close()
+
+	// Another writer lands between every read and write
+	calls := 0
+	err = s.UpdateErr("k", func(old interface{}, exists bool) interface{} {
+		calls++
+		other.Set("k", testState{N: -1})
+		return testState{N: calls}
+	})
+	if err != ErrUpdateConflict {
+		t.Fatalf("UpdateErr = %v, want ErrUpdateConflict", err)
+	}
+	if calls != redisUpdateAttempts {
+		t.Errorf("fn ran %d times, want %d", calls, redisUpdateAttempts)
+	}
+	if v, _ := s.Get("k"); v != (testState{N: -1}) {
+		t.Errorf("k = %v; want the other writer's value", v)
+	}
+
+	if err := s.UpdateErr("k", func(interface{}, bool) interface{} { return testState{N: 1} }); err != nil {
+		t.Errorf("Expected uncontended update to commit, got %v", err)
+	}
+}
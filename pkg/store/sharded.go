@@ -0,0 +1,160 @@
+package store
+
+import (
+	"errors"
+	"hash/fnv"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+// ShardedStore spreads keys over independently locked InMemoryStores so that
+// requests for different keys rarely contend. Each shard runs its own TTL
+// cleanup and evicts within its own share of maxKeys, so eviction can start
+// before the store as a whole is full, but the store never exceeds it.
+type ShardedStore struct {
+	shards []*InMemoryStore
+}
+
+// NewShardedStore creates n shards. maxKeys, if set, is split between them
+// as for NewShardedStoreWithOptions.
+func NewShardedStore(n int, ttl time.Duration, maxKeys int) *ShardedStore {
+	return NewShardedStoreWithOptions(n, Options{TTL: ttl, MaxKeys: maxKeys})
+}
+
+// NewShardedStoreWithOptions creates n shards configured by opts, with
+// opts.MaxKeys and opts.MaxBytes split between them rounding down, so the
+// shards' shares never add up to more. With fewer than n keys allowed, only
+// opts.MaxKeys shards are created, each holding one. Eviction order is per
+// shard.
+func NewShardedStoreWithOptions(n int, opts Options) *ShardedStore {
+	if n < 1 {
+		n = 1
+	}
+	if opts.MaxKeys > 0 && opts.Eviction != EvictTTL {
+		n = min(n, opts.MaxKeys)
+		opts.MaxKeys /= n
+	}
+	if opts.MaxBytes > 0 && opts.Eviction != EvictTTL {
+		// A shard's lone entry may still exceed its share, as in InMemoryStore
+		opts.MaxBytes = max(opts.MaxBytes/int64(n), 1)
+	}
+	s := &ShardedStore{shards: make([]*InMemoryStore, n)}
+	for i := range s.shards {
+		s.shards[i] = NewInMemoryStoreWithOptions(opts)
+	}
+	return s
+}
+
+func (s *ShardedStore) shard(key string) *InMemoryStore {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *ShardedStore) Get(key string) (interface{}, bool) {
+	return s.shard(key).Get(key)
+}
+
+func (s *ShardedStore) Set(key string, value interface{}) {
+	s.shard(key).Set(key, value)
+}
+
+func (s *ShardedStore) Update(key string, fn func(old interface{}, exists bool) interface{}) {
+	s.shard(key).Update(key, fn)
+}
+
+func (s *ShardedStore) Delete(key string) bool {
+	return s.shard(key).Delete(key)
+}
+
+// Range visits shards one at a time, so only one shard is locked at once
+func (s *ShardedStore) Range(fn func(key string, value interface{}) bool) {
+	for _, sh := range s.shards {
+		stopped := false
+		sh.Range(func(key string, value interface{}) bool {
+			if !fn(key, value) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+func (s *ShardedStore) Dump(fn func(Entry) bool) {
+	for _, sh := range s.shards {
+		stopped := false
+		sh.Dump(func(e Entry) bool {
+			if !fn(e) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+func (s *ShardedStore) Export(key string) (Entry, bool) {
+	return s.shard(key).Export(key)
+}
+
+func (s *ShardedStore) Load(e Entry) {
+	s.shard(e.Key).Load(e)
+}
+
+func (s *ShardedStore) Merge(e Entry) bool {
+	return s.shard(e.Key).Merge(e)
+}
+
+func (s *ShardedStore) MergeWith(e Entry, combine func(local, remote interface{}) interface{}) bool {
+	return s.shard(e.Key).MergeWith(e, combine)
+}
+
+func (s *ShardedStore) Tombstone(key string) (Entry, bool) {
+	return s.shard(key).Tombstone(key)
+}
+
+func (s *ShardedStore) Clock() clock.Clock {
+	return s.shards[0].Clock()
+}
+
+func (s *ShardedStore) TTL() time.Duration {
+	return s.shards[0].TTL()
+}
+
+// Stats sums the counters of all shards
+func (s *ShardedStore) Stats() Stats {
+	var total Stats
+	for _, sh := range s.shards {
+		st := sh.Stats()
+		total.Keys += st.Keys
+		total.Bytes += st.Bytes
+		total.Evictions += st.Evictions
+		total.Expirations += st.Expirations
+	}
+	return total
+}
+
+// Alive fails if any shard's cleanup goroutine is stuck
+func (s *ShardedStore) Alive() error {
+	var errs []error
+	for _, sh := range s.shards {
+		if err := sh.Alive(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *ShardedStore) Close() {
+	for _, sh := range s.shards {
+		sh.Close()
+	}
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShardedStore(t *testing.T) {
+	s := NewShardedStore(8, time.Hour, 0)
+	defer s.Close()
+
+	for i := 0; i < 100; i++ {
+		s.Set(strconv.Itoa(i), i)
+	}
+	if v, ok := s.Get("42"); !ok || v != 42 {
+		t.Fatalf("Get(42) = %v, %v", v, ok)
+	}
+	s.Update("42", func(old interface{}, exists bool) interface{} { return old.(int) + 1 })
+	if v, _ := s.Get("42"); v != 43 {
+		t.Errorf("Expected 43 after Update, got %v", v)
+	}
+	if !s.Delete("42") || s.Delete("42") {
+		t.Error("Expected Delete to report removal exactly once")
+	}
+
+	n := 0
+	s.Range(func(string, interface{}) bool { n++; return true })
+	if n != 99 || s.Stats().Keys != 99 {
+		t.Errorf("Expected 99 keys, ranged %d, stats %d", n, s.Stats().Keys)
+	}
+	if err := s.Alive(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShardedStoreSplitsMaxKeys(t *testing.T) {
+	s := NewShardedStore(4, time.Hour, 40)
+	defer s.Close()
+	for i := 0; i < 1000; i++ {
+		s.Set(strconv.Itoa(i), i)
+	}
+	if keys := s.Stats().Keys; keys > 40 {
+		t.Errorf("Expected at most 40 keys, got %d", keys)
+	}
+}
+
+func TestShardedStoreNeverExceedsMaxKeys(t *testing.T) {
+	// Rounding each shard's share up would allow 64 and 128 keys
+	for _, maxKeys := range []int{10, 100} {
+		s := NewShardedStore(64, time.Hour, maxKeys)
+		for i := 0; i < 1000; i++ {
+			s.Set(strconv.Itoa(i), i)
+		}
+		if keys := s.Stats().Keys; keys > maxKeys {
+			t.Errorf("MaxKeys %d: got %d keys", maxKeys, keys)
+		}
+		s.Close()
+	}
+}
+
+func increment(old interface{}, exists bool) interface{} {
+	if !exists {
+		return 1
+	}
+	return old.(int) + 1
+}
+
+func benchmarkParallelKeys(b *testing.B, s Store) {
+	var next atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		key := "key-" + strconv.FormatInt(next.Add(1), 10)
+		for pb.Next() {
+			s.Update(key, increment)
+		}
+	})
+}
+
+func benchmarkHotKey(b *testing.B, s Store) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Update("hot", increment)
+		}
+	})
+}
+
+func BenchmarkInMemoryStoreParallelKeys(b *testing.B) {
+	s := NewInMemoryStore(time.Hour)
+	defer s.Close()
+	benchmarkParallelKeys(b, s)
+}
+
+func BenchmarkShardedStoreParallelKeys(b *testing.B) {
+	s := NewShardedStore(64, time.Hour, 0)
+	defer s.Close()
+	benchmarkParallelKeys(b, s)
+}
+
+func BenchmarkInMemoryStoreHotKey(b *testing.B) {
+	s := NewInMemoryStore(time.Hour)
+	defer s.Close()
+	benchmarkHotKey(b, s)
+}
+
+func BenchmarkShardedStoreHotKey(b *testing.B) {
+	s := NewShardedStore(64, time.Hour, 0)
+	defer s.Close()
+	benchmarkHotKey(b, s)
+}
@@ -0,0 +1,35 @@
+package store
+
+import "unsafe"
+
+// Sizer is implemented by values that can estimate their own memory
+// footprint. Values that don't are charged defaultValueSize.
+type Sizer interface {
+	// Size returns the approximate number of bytes the value holds,
+	// including anything it points to
+	Size() int
+}
+
+const (
+	// entryOverhead approximates the per-key cost of the map slot, the item
+	// and its eviction list element
+	entryOverhead = 64 + int(unsafe.Sizeof(item{})) + 48
+	// defaultValueSize is charged for values that don't implement Sizer
+	defaultValueSize = 64
+)
+
+// sizeOf estimates the bytes an entry for key holding value occupies
+func sizeOf(key string, value interface{}) int64 {
+	n := entryOverhead + len(key)
+	switch v := value.(type) {
+	case Sizer:
+		n += v.Size()
+	case string:
+		n += int(unsafe.Sizeof(v)) + len(v)
+	case []byte:
+		n += int(unsafe.Sizeof(v)) + cap(v)
+	default:
+		n += defaultValueSize
+	}
+	return int64(n)
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+// SnapshotVersion is the format version written by WriteSnapshot
+const SnapshotVersion = 1
+
+// Entry is one key with its value, the time it was last touched and the
+// version of its last write (zero unless the store stamps writes from an HLC)
+type Entry struct {
+	Key        string
+	Value      interface{}
+	LastAccess time.Time
+	Version    clock.Timestamp
+	// Deleted marks a tombstone: the key was deleted at Version, and has no
+	// Value. Only Tombstone and Merge deal in them.
+	Deleted bool
+}
+
+// Snapshottable is implemented by stores whose contents can be dumped and
+// reloaded with their access times intact
+type Snapshottable interface {
+	// Dump calls fn with a copy of each entry until fn returns false
+	Dump(fn func(Entry) bool)
+	// Load inserts e unless the key already holds newer, live state
+	Load(e Entry)
+	// TTL is how long an untouched entry is kept
+	TTL() time.Duration
+}
+
+// RestoreStats describes what a restore did
+type RestoreStats struct {
+	Loaded   int
+	Expired  int           // entries whose TTL ran out while we were down
+	Downtime time.Duration // time between the snapshot and the restore
+}
+
+type snapshotHeader struct {
+	Version int       `json:"version"`
+	TakenAt time.Time `json:"taken_at"`
+}
+
+type snapshotEntry struct {
+	Key        string           `json:"key"`
+	Type       string           `json:"type"`
+	LastAccess time.Time        `json:"last_access"`
+	Version    *clock.Timestamp `json:"version,omitempty"`
+	Value      json.RawMessage  `json:"value"`
+}
+
+// WriteSnapshot writes s as newline-delimited JSON: a header line followed by
+// one line per entry. Values must have a registered Codec.
+func WriteSnapshot(w io.Writer, s Snapshottable, now time.Time) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(snapshotHeader{Version: SnapshotVersion, TakenAt: now}); err != nil {
+		return 0, err
+	}
+	n := 0
+	var err error
+	s.Dump(func(e Entry) bool {
+		var typ string
+		var data []byte
+		typ, data, err = EncodeValue(e.Value)
+		if err != nil {
+			err = fmt.Errorf("key %q: %w", e.Key, err)
+			return false
+		}
+		se := snapshotEntry{Key: e.Key, Type: typ, LastAccess: e.LastAccess, Value: data}
+		if !e.Version.IsZero() {
+			se.Version = &e.Version
+		}
+		err = enc.Encode(se)
+		n++
+		return err == nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// ReadSnapshot loads a snapshot into s. Access times are kept as recorded, so
+// entries whose TTL elapsed during the downtime are dropped and the rest
+// expire on their original schedule. Limiter states carry absolute times and
+// so account for the downtime on their next use (e.g. buckets refill).
+func ReadSnapshot(r io.Reader, s Snapshottable, now time.Time) (RestoreStats, error) {
+	var stats RestoreStats
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var hdr snapshotHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return stats, fmt.Errorf("snapshot header: %w", err)
+	}
+	if hdr.Version != SnapshotVersion {
+		return stats, fmt.Errorf("snapshot version %d not supported", hdr.Version)
+	}
+	if now.After(hdr.TakenAt) {
+		stats.Downtime = now.Sub(hdr.TakenAt)
+	}
+
+	ttl := s.TTL()
+	for {
+		var se snapshotEntry
+		if err := dec.Decode(&se); err == io.EOF {
+			return stats, nil
+		} else if err != nil {
+			return stats, fmt.Errorf("snapshot entry %d: %w", stats.Loaded+stats.Expired, err)
+		}
+		lastAccess := se.LastAccess
+		if lastAccess.After(now) {
+			// Written by a clock ahead of ours; don't let it outlive its TTL
+			lastAccess = now
+		}
+		if ttl > 0 && now.Sub(lastAccess) > ttl {
+			stats.Expired++
+			continue
+		}
+		val, err := DecodeValue(se.Type, se.Value)
+		if err != nil {
+			return stats, fmt.Errorf("key %q: %w", se.Key, err)
+		}
+		e := Entry{Key: se.Key, Value: val, LastAccess: lastAccess}
+		if se.Version != nil {
+			e.Version = *se.Version
+		}
+		s.Load(e)
+		stats.Loaded++
+	}
+}
+
+// SaveSnapshotFile atomically replaces path with a snapshot of s
+func SaveSnapshotFile(path string, s Snapshottable) (int, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	n, err := WriteSnapshot(tmp, s, nowFor(s))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return n, err
+	}
+	return n, syncDir(filepath.Dir(path))
+}
+
+// LoadSnapshotFile restores s from path. A missing file is not an error.
+func LoadSnapshotFile(path string, s Snapshottable) (RestoreStats, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return RestoreStats{}, nil
+	}
+	if err != nil {
+		return RestoreStats{}, err
+	}
+	defer f.Close()
+	return ReadSnapshot(f, s, nowFor(s))
+}
+
+// nowFor reads the store's own clock if it has one, so expiry during
+// downtime is judged on the same clock as the access times
+func nowFor(s Snapshottable) time.Time {
+	if c, ok := s.(interface{ Clock() clock.Clock }); ok {
+		return c.Clock().Now()
+	}
+	return time.Now()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testState struct {
+	N int
+}
+
+func init() {
+	RegisterCodec("test", testState{}, JSONCodec[testState]{})
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	src := NewInMemoryStore(time.Hour)
+	defer src.Close()
+	src.Set("a", testState{N: 1})
+	src.Set("b", testState{N: 2})
+
+	path := filepath.Join(t.TempDir(), "state.snap")
+	n, err := SaveSnapshotFile(path, src)
+	if err != nil || n != 2 {
+		t.Fatalf("SaveSnapshotFile = %d, %v", n, err)
+	}
+
+	dst := NewInMemoryStore(time.Hour)
+	defer dst.Close()
+	dst.Set("b", testState{N: 99}) // written after startup, must win
+	stats, err := LoadSnapshotFile(path, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Loaded != 2 || stats.Expired != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if v, _ := dst.Get("a"); v != (testState{N: 1}) {
+		t.Errorf("a = %v", v)
+	}
+	if v, _ := dst.Get("b"); v != (testState{N: 99}) {
+		t.Errorf("b = %v, expected live state to be kept", v)
+	}
+}
+
+func TestSnapshotDropsEntriesExpiredDuringDowntime(t *testing.T) {
+	src := NewInMemoryStore(time.Hour)
+	defer src.Close()
+	taken := time.Now()
+	src.Load(Entry{Key: "stale", Value: testState{N: 1}, LastAccess: taken.Add(-50 * time.Minute)})
+	src.Load(Entry{Key: "fresh", Value: testState{N: 2}, LastAccess: taken})
+
+	var buf bytes.Buffer
+	if _, err := WriteSnapshot(&buf, src, taken); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewInMemoryStore(time.Hour)
+	defer dst.Close()
+	stats, err := ReadSnapshot(&buf, dst, taken.Add(20*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Loaded != 1 || stats.Expired != 1 || stats.Downtime != 20*time.Minute {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if _, ok := dst.Get("stale"); ok {
+		t.Error("Expected stale entry to be dropped")
+	}
+}
+
+func TestSnapshotRejectsUnknownVersion(t *testing.T) {
+	dst := NewInMemoryStore(time.Hour)
+	defer dst.Close()
+	_, err := ReadSnapshot(strings.NewReader(`{"version":99}`+"\n"), dst, time.Now())
+	if err == nil {
+		t.Error("Expected error for unknown version")
+	}
+}
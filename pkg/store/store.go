@@ -1,28 +1,163 @@
 package store
 
 import (
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"RateLimiterService/pkg/clock"
 )
 
 // Store interface for key-value storage
 // Edge cases handled:
-// - Concurrent requests: RWMutex ensures thread safety.
-// - Clock drift: Uses time.Now(), which is monotonic in Go; for distributed, sync clocks.
-// - Memory growth: TTL cleanup and optional maxKeys with LRU eviction.
+// - Concurrent requests: RWMutex ensures thread safety; ShardedStore spreads keys over many locks.
+// - Clock drift: Uses the injected clock.Clock (the monotonic system clock by default); for distributed, sync clocks.
+// - Memory growth: TTL cleanup and optional maxKeys with O(1) LRU or LFU eviction.
 type Store interface {
 	Get(key string) (interface{}, bool)
 	Set(key string, value interface{})
+	// Update atomically replaces key's value with fn(old, exists). No other
+	// write to key can land between the read and the write. fn must not call
+	// back into the store and may run more than once (e.g. on remote stores
+	// that retry on conflict), so it should only compute the new value.
+	Update(key string, fn func(old interface{}, exists bool) (new interface{}))
+	// Delete removes key and reports whether it was present.
+	Delete(key string) bool
+	// Range calls fn for each key until fn returns false. Iteration order is
+	// unspecified and fn must not call back into the store.
+	Range(fn func(key string, value interface{}) bool)
+}
+
+// Stats is a point-in-time view of a store's size and housekeeping counters
+type Stats struct {
+	Keys        int
+	Bytes       int64  // estimated size of all entries; 0 if the store doesn't track it
+	Evictions   uint64 // keys dropped to stay under maxKeys or maxBytes
+	Expirations uint64 // keys dropped by TTL cleanup
+	// CompactionFailures counts WAL compactions that failed, leaving the
+	// log to grow; 0 for stores without a log
+	CompactionFailures uint64
+}
+
+// StatsReporter is implemented by stores that can report Stats
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// Pinger is implemented by stores backed by a remote server
+type Pinger interface {
+	Ping() error
+}
+
+// LivenessChecker is implemented by stores with background work that can wedge
+type LivenessChecker interface {
+	Alive() error
+}
+
+// FallibleUpdater is implemented by stores whose Update can fail to commit,
+// such as remote ones. UpdateErr is Update reporting whether fn's result was
+// written: after an error fn may have run, but nothing was stored.
+type FallibleUpdater interface {
+	UpdateErr(key string, fn func(old interface{}, exists bool) interface{}) error
+}
+
+// FailOpener is implemented by stores that can fail to record a decision,
+// such as remote ones. FailOpen says what limiters do with the request then:
+// admit it, so an outage doesn't take down every caller, or deny it, so
+// limits hold. Limiters on stores without it deny.
+type FailOpener interface {
+	FailOpen() bool
+}
+
+// TryUpdate is s.UpdateErr where s implements it, and s.Update, which
+// always commits, elsewhere
+func TryUpdate(s Store, key string, fn func(old interface{}, exists bool) interface{}) error {
+	if f, ok := s.(FallibleUpdater); ok {
+		return f.UpdateErr(key, fn)
+	}
+	s.Update(key, fn)
+	return nil
+}
+
+// Merger is implemented by stores that can fold in versioned entries written
+// on other nodes
+type Merger interface {
+	Merge(e Entry) bool
+}
+
+// CombiningMerger is implemented by versioned stores that can merge an entry
+// into the key's current value rather than keep whichever is newer
+type CombiningMerger interface {
+	MergeWith(e Entry, combine func(local, remote interface{}) interface{}) bool
+}
+
+// Tombstoner is implemented by versioned stores that remember deletes.
+// Tombstone deletes key like Delete, and returns a tombstone stamped after
+// every write the store has seen; merged elsewhere, it deletes the key there
+// too. Until the TTL passes, Merge refuses entries older than the tombstone,
+// so a copy written before the delete can't bring the key back.
+type Tombstoner interface {
+	Tombstone(key string) (tomb Entry, existed bool)
+}
+
+// Exporter is implemented by stores that can copy out one entry with its
+// version, for sending to another node
+type Exporter interface {
+	Export(key string) (Entry, bool)
 }
 
+// Peek reads key without counting as an access where s can export entries,
+// so the read neither extends its TTL nor saves it from eviction, and is
+// s.Get elsewhere
+func Peek(s Store, key string) (interface{}, bool) {
+	if ex, ok := s.(Exporter); ok {
+		e, ok := ex.Export(key)
+		return e.Value, ok
+	}
+	return s.Get(key)
+}
+
+// ErrClosed is returned by health checks once a store has been closed
+var ErrClosed = errors.New("store: closed")
+
 // InMemoryStore implements Store using a map with cleanup
 type InMemoryStore struct {
 	mu          sync.RWMutex
-	data        map[string]interface{}
-	lastAccess  map[string]time.Time
+	items       map[string]*item
+	order       evictor       // nil under EvictTTL
 	ttl         time.Duration // time to live for entries
 	maxKeys     int           // optional max number of keys to prevent unbounded growth
-	cleanupDone chan struct{} // to stop the cleanup goroutine
+	maxBytes    int64         // optional budget for the estimated size of all entries
+	bytes       int64         // estimated size of all entries, guarded by mu
+	onEvict     func(Entry, EvictReason)
+	clock       clock.Clock
+	hlc         *clock.HLC       // stamps writes with versions; nil leaves them zero
+	tombstones  map[string]Entry // deleted keys by tombstone, while the TTL runs
+	cleanupDone chan struct{}    // to stop the cleanup goroutine
+	closeOnce   sync.Once
+	closed      atomic.Bool
+	heartbeat   atomic.Int64 // unix nanos of the last cleanup pass
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+}
+
+// Options configures an InMemoryStore
+type Options struct {
+	TTL      time.Duration
+	MaxKeys  int            // 0 means unbounded
+	MaxBytes int64          // budget for estimated entry sizes (see Sizer); 0 means unbounded
+	Eviction EvictionPolicy // which key to drop at a bound; EvictTTL ignores both bounds
+	// OnEvict, if set, is called for every entry removed by eviction or TTL
+	// cleanup. It runs with the store locked and must not call back into it.
+	OnEvict func(e Entry, reason EvictReason)
+	// Clock drives access times, TTL expiry and the cleanup interval;
+	// nil means the system clock
+	Clock clock.Clock
+	// HLC, if set, stamps every write with a version for Merge. Pass the
+	// same HLC as Clock to keep access times monotonic across nodes.
+	HLC *clock.HLC
 }
 
 func NewInMemoryStore(ttl time.Duration) *InMemoryStore {
@@ -30,63 +165,222 @@ func NewInMemoryStore(ttl time.Duration) *InMemoryStore {
 }
 
 func NewInMemoryStoreWithMaxKeys(ttl time.Duration, maxKeys int) *InMemoryStore {
+	return NewInMemoryStoreWithOptions(Options{TTL: ttl, MaxKeys: maxKeys})
+}
+
+func NewInMemoryStoreWithOptions(opts Options) *InMemoryStore {
 	s := &InMemoryStore{
-		data:        make(map[string]interface{}),
-		lastAccess:  make(map[string]time.Time),
-		ttl:         ttl,
-		maxKeys:     maxKeys,
+		items:       make(map[string]*item),
+		order:       newEvictor(opts.Eviction),
+		ttl:         opts.TTL,
+		maxKeys:     opts.MaxKeys,
+		maxBytes:    opts.MaxBytes,
+		onEvict:     opts.OnEvict,
+		clock:       opts.Clock,
+		hlc:         opts.HLC,
 		cleanupDone: make(chan struct{}),
 	}
+	if s.clock == nil {
+		s.clock = clock.RealClock{}
+	}
+	if s.order == nil {
+		s.maxKeys, s.maxBytes = 0, 0
+	}
+	s.heartbeat.Store(s.clock.Now().UnixNano())
 	go s.cleanupRoutine()
 	return s
 }
 
 func (s *InMemoryStore) Get(key string) (interface{}, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	val, ok := s.data[key]
-	if ok {
-		s.lastAccess[key] = time.Now() // update access time
+	// Write lock: a read refreshes the access time and the eviction order
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.items[key]
+	if !ok {
+		return nil, false
 	}
-	return val, ok
+	s.touchLocked(it, s.clock.Now())
+	return it.value, true
 }
 
 func (s *InMemoryStore) Set(key string, value interface{}) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	now := time.Now()
-	// If maxKeys is set and exceeded, evict oldest key
-	if s.maxKeys > 0 && len(s.data) >= s.maxKeys && s.data[key] == nil {
-		s.evictOldest()
-	}
-	s.data[key] = value
-	s.lastAccess[key] = now
-}
-
-func (s *InMemoryStore) evictOldest() {
-	var oldestKey string
-	var oldestTime time.Time
-	first := true
-	for key, t := range s.lastAccess {
-		if first || t.Before(oldestTime) {
-			oldestKey = key
-			oldestTime = t
-			first = false
+	s.setLocked(Entry{Key: key, Value: value, LastAccess: s.clock.Now(), Version: s.stamp()})
+}
+
+func (s *InMemoryStore) Update(key string, fn func(old interface{}, exists bool) interface{}) {
+	s.update(key, fn)
+}
+
+// update is Update returning the version it stamped, for the WAL
+func (s *InMemoryStore) update(key string, fn func(old interface{}, exists bool) interface{}) clock.Timestamp {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var old interface{}
+	it, exists := s.items[key]
+	if exists {
+		old = it.value
+	}
+	e := Entry{Key: key, Value: fn(old, exists), LastAccess: s.clock.Now(), Version: s.stamp()}
+	s.setLocked(e)
+	return e.Version
+}
+
+// put overwrites key with a replayed value, access time and version
+func (s *InMemoryStore) put(e Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observeLocked(e.Version)
+	s.setLocked(e)
+}
+
+// stamp returns a version for a local write, or zero without an HLC
+func (s *InMemoryStore) stamp() clock.Timestamp {
+	if s.hlc == nil {
+		return clock.Timestamp{}
+	}
+	return s.hlc.Tick()
+}
+
+// observeLocked moves the HLC past a version written elsewhere or earlier,
+// so later local writes order after it
+func (s *InMemoryStore) observeLocked(v clock.Timestamp) error {
+	if s.hlc == nil || v.IsZero() || !s.hlc.Last().Before(v) {
+		return nil
+	}
+	_, err := s.hlc.Update(v)
+	return err
+}
+
+func (s *InMemoryStore) setLocked(e Entry) {
+	if it, ok := s.items[e.Key]; ok {
+		size := sizeOf(e.Key, e.Value)
+		s.bytes += size - it.size
+		it.value, it.size, it.version = e.Value, size, e.Version
+		s.touchLocked(it, e.LastAccess)
+		s.enforceBudgetLocked(it)
+		return
+	}
+	s.insertLocked(&item{key: e.Key, value: e.Value, lastAccess: e.LastAccess, version: e.Version})
+}
+
+func (s *InMemoryStore) insertLocked(it *item) {
+	// Any tombstone is older: a key is only written again after a newer version
+	delete(s.tombstones, it.key)
+	// At capacity, make room by evicting according to policy
+	if s.maxKeys > 0 && len(s.items) >= s.maxKeys {
+		s.evictLocked(s.order.victim())
+	}
+	it.size = sizeOf(it.key, it.value)
+	s.items[it.key] = it
+	s.bytes += it.size
+	if s.order != nil {
+		s.order.add(it)
+	}
+	s.enforceBudgetLocked(it)
+}
+
+// enforceBudgetLocked evicts until the store fits in maxBytes, sparing keep,
+// the entry just written. A lone entry larger than the budget is kept.
+func (s *InMemoryStore) enforceBudgetLocked(keep *item) {
+	for s.maxBytes > 0 && s.bytes > s.maxBytes {
+		victim := s.order.victim()
+		if victim == keep {
+			victim = s.order.next(keep)
+		}
+		if victim == nil {
+			return
+		}
+		s.evictLocked(victim)
+	}
+}
+
+func (s *InMemoryStore) touchLocked(it *item, at time.Time) {
+	it.lastAccess = at
+	if s.order != nil {
+		s.order.touch(it)
+	}
+}
+
+func (s *InMemoryStore) removeLocked(it *item) {
+	delete(s.items, it.key)
+	s.bytes -= it.size
+	if s.order != nil {
+		s.order.remove(it)
+	}
+}
+
+// Delete removes key without a tombstone, so an older copy merged later
+// restores it
+func (s *InMemoryStore) Delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.items[key]
+	if ok {
+		s.removeLocked(it)
+	}
+	return ok
+}
+
+// Tombstone implements Tombstoner. Without an HLC the tombstone has a zero
+// version and isn't kept, as there is nothing to order merges against.
+func (s *InMemoryStore) Tombstone(key string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tomb := Entry{Key: key, LastAccess: s.clock.Now(), Version: s.stamp(), Deleted: true}
+	it, ok := s.items[key]
+	if ok {
+		s.removeLocked(it)
+	}
+	s.buryLocked(tomb)
+	return tomb, ok
+}
+
+func (s *InMemoryStore) buryLocked(tomb Entry) {
+	if tomb.Version.IsZero() {
+		return
+	}
+	if s.tombstones == nil {
+		s.tombstones = make(map[string]Entry)
+	}
+	s.tombstones[tomb.Key] = tomb
+}
+
+func (s *InMemoryStore) Range(fn func(key string, value interface{}) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for key, it := range s.items {
+		if !fn(key, it.value) {
+			return
 		}
 	}
-	if oldestKey != "" {
-		delete(s.data, oldestKey)
-		delete(s.lastAccess, oldestKey)
+}
+
+// evictLocked drops a victim chosen by the eviction order
+func (s *InMemoryStore) evictLocked(it *item) {
+	if it == nil {
+		return
+	}
+	s.removeLocked(it)
+	s.evictions.Add(1)
+	s.notifyLocked(it, EvictCapacity)
+}
+
+func (s *InMemoryStore) notifyLocked(it *item, reason EvictReason) {
+	if s.onEvict != nil {
+		s.onEvict(Entry{Key: it.key, Value: it.value, LastAccess: it.lastAccess, Version: it.version}, reason)
 	}
 }
 
 func (s *InMemoryStore) cleanupRoutine() {
-	ticker := time.NewTicker(s.ttl / 4) // cleanup every ttl/4 for more responsiveness
+	ticker := s.clock.NewTicker(s.ttl / 4) // cleanup every ttl/4 for more responsiveness
 	defer ticker.Stop()
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			s.cleanup()
+			s.heartbeat.Store(s.clock.Now().UnixNano())
 		case <-s.cleanupDone:
 			return
 		}
@@ -96,16 +390,160 @@ func (s *InMemoryStore) cleanupRoutine() {
 func (s *InMemoryStore) cleanup() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	now := time.Now()
-	for key, accessTime := range s.lastAccess {
-		if now.Sub(accessTime) > s.ttl {
-			delete(s.data, key)
-			delete(s.lastAccess, key)
+	now := s.clock.Now()
+	for _, it := range s.items {
+		if now.Sub(it.lastAccess) > s.ttl {
+			s.removeLocked(it)
+			s.expirations.Add(1)
+			s.notifyLocked(it, EvictExpired)
+		}
+	}
+	// By now any copy written before the delete has expired too
+	for key, tomb := range s.tombstones {
+		if now.Sub(tomb.LastAccess) > s.ttl {
+			delete(s.tombstones, key)
+		}
+	}
+}
+
+// Dump calls fn with each entry and its last access time. Entries are copied
+// under the lock, so fn may call back into the store.
+func (s *InMemoryStore) Dump(fn func(Entry) bool) {
+	s.mu.RLock()
+	entries := make([]Entry, 0, len(s.items))
+	for key, it := range s.items {
+		entries = append(entries, Entry{Key: key, Value: it.value, LastAccess: it.lastAccess, Version: it.version})
+	}
+	s.mu.RUnlock()
+	for _, e := range entries {
+		if !fn(e) {
+			return
+		}
+	}
+}
+
+// Export returns a copy of key's entry without counting it as an access
+func (s *InMemoryStore) Export(key string) (Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	it, ok := s.items[key]
+	if !ok {
+		return Entry{}, false
+	}
+	return Entry{Key: key, Value: it.value, LastAccess: it.lastAccess, Version: it.version}, true
+}
+
+// Load inserts a restored entry, keeping its access time. Keys that already
+// hold state are left alone: anything written since startup is newer.
+func (s *InMemoryStore) Load(e Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.items[e.Key]; exists {
+		return
+	}
+	s.observeLocked(e.Version)
+	s.insertLocked(&item{key: e.Key, value: e.Value, lastAccess: e.LastAccess, version: e.Version})
+}
+
+// Merge applies an entry replicated from another node if its version is
+// newer than what the key holds, or the tombstone it left, and reports
+// whether it did. A newer tombstone deletes the key. With every node
+// stamping writes from an HLC, each converges on the same value whatever
+// order entries arrive in. Entries from a clock further ahead than the HLC's
+// max offset are refused.
+func (s *InMemoryStore) Merge(e Entry) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mergeLocked(e)
+}
+
+func (s *InMemoryStore) mergeLocked(e Entry) bool {
+	it, exists := s.items[e.Key]
+	if exists && !it.version.Before(e.Version) {
+		return false
+	}
+	if tomb, buried := s.tombstones[e.Key]; buried && !tomb.Version.Before(e.Version) {
+		return false
+	}
+	if err := s.observeLocked(e.Version); err != nil {
+		return false
+	}
+	if e.Deleted {
+		if exists {
+			s.removeLocked(it)
 		}
+		s.buryLocked(e)
+		return true
+	}
+	s.setLocked(e)
+	return true
+}
+
+// MergeWith implements CombiningMerger. If the key holds a value, it becomes
+// combine(value, e.Value), stamped as a local write after both; otherwise e
+// is merged as by Merge.
+func (s *InMemoryStore) MergeWith(e Entry, combine func(local, remote interface{}) interface{}) bool {
+	_, ok := s.mergeWith(e, combine)
+	return ok
+}
+
+// mergeWith is MergeWith returning the entry it stored, for the WAL
+func (s *InMemoryStore) mergeWith(e Entry, combine func(local, remote interface{}) interface{}) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, exists := s.items[e.Key]
+	if !exists || e.Deleted {
+		return e, s.mergeLocked(e)
 	}
+	if err := s.observeLocked(e.Version); err != nil {
+		return Entry{}, false
+	}
+	merged := Entry{Key: e.Key, Value: combine(it.value, e.Value), LastAccess: s.clock.Now(), Version: s.stamp()}
+	s.setLocked(merged)
+	return merged, true
+}
+
+// Clock returns the clock driving access times and expiry
+func (s *InMemoryStore) Clock() clock.Clock {
+	return s.clock
+}
+
+// TTL returns how long an untouched entry is kept
+func (s *InMemoryStore) TTL() time.Duration {
+	return s.ttl
 }
 
-// Close stops the cleanup goroutine (call when done)
+// Stats reports the current key count and size, and cumulative eviction/expiry counts
+func (s *InMemoryStore) Stats() Stats {
+	s.mu.RLock()
+	keys, bytes := len(s.items), s.bytes
+	s.mu.RUnlock()
+	return Stats{
+		Keys:        keys,
+		Bytes:       bytes,
+		Evictions:   s.evictions.Load(),
+		Expirations: s.expirations.Load(),
+	}
+}
+
+// Alive reports an error if the cleanup goroutine has missed several ticks,
+// which means it is stuck (e.g. blocked on the store lock) or has exited.
+func (s *InMemoryStore) Alive() error {
+	if s.closed.Load() {
+		return ErrClosed
+	}
+	interval := s.ttl / 4
+	last := time.Unix(0, s.heartbeat.Load())
+	if since := s.clock.Since(last); since > 3*interval {
+		return fmt.Errorf("store: cleanup last ran %s ago, expected every %s", since.Round(time.Second), interval)
+	}
+	return nil
+}
+
+// Close stops the cleanup goroutine (call when done). It is safe to call more than once.
 func (s *InMemoryStore) Close() {
-	close(s.cleanupDone)
-}
\ No newline at end of file
+	s.closeOnce.Do(func() {
+		s.closed.Store(true)
+		close(s.cleanupDone)
+	})
+}
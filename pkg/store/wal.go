@@ -0,0 +1,526 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+// SyncPolicy controls when the write-ahead log is fsynced
+type SyncPolicy int
+
+const (
+	SyncInterval SyncPolicy = iota // fsync every SyncEvery; a crash loses at most that much
+	SyncAlways                     // fsync before every write returns
+	SyncNever                      // leave flushing to the OS
+)
+
+// ParseSyncPolicy parses "always", "interval" or "never"
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "interval", "":
+		return SyncInterval, nil
+	case "always":
+		return SyncAlways, nil
+	case "never":
+		return SyncNever, nil
+	}
+	return 0, fmt.Errorf("store: unknown sync policy %q", s)
+}
+
+// WALOptions configures a WALStore
+type WALOptions struct {
+	Dir          string        // holds the snapshot and log files
+	TTL          time.Duration // as for InMemoryStore
+	MaxKeys      int           // as for InMemoryStore
+	MaxBytes     int64
+	Eviction     EvictionPolicy
+	OnEvict      func(e Entry, reason EvictReason)
+	Clock        clock.Clock // as for InMemoryStore; also timestamps log records
+	HLC          *clock.HLC  // as for InMemoryStore; versions are logged
+	Sync         SyncPolicy
+	SyncEvery    time.Duration // for SyncInterval; defaults to 1s
+	CompactBytes int64         // compact once the log exceeds this size; 0 disables
+	CompactEvery time.Duration // compact on this interval regardless of size; 0 disables
+}
+
+const (
+	walSnapshotFile = "snapshot"
+	walLogFile      = "wal.log"
+	walHeaderSize   = 8 // uint32 length + uint32 CRC-32C of the payload
+
+	// walMaxRecord bounds a record's payload. No single entry comes near it,
+	// so a longer length read back is corruption, not a record to allocate.
+	walMaxRecord = 16 << 20
+
+	// walCompactBackoff spaces out size-triggered compactions after one
+	// fails, rather than retrying a full snapshot on every write
+	walCompactBackoff = 10 * time.Second
+)
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+type walRecord struct {
+	Op      string           `json:"op"` // "set" or "del"
+	Key     string           `json:"key"`
+	Type    string           `json:"type,omitempty"`
+	Value   json.RawMessage  `json:"value,omitempty"`
+	Time    time.Time        `json:"ts"`
+	Version *clock.Timestamp `json:"version,omitempty"`
+}
+
+func versionPtr(v clock.Timestamp) *clock.Timestamp {
+	if v.IsZero() {
+		return nil
+	}
+	return &v
+}
+
+// WALStore is an InMemoryStore whose mutations are appended to a write-ahead
+// log as they are applied, under one lock, so the log holds them in order.
+// The log is periodically folded into a snapshot and truncated; on open the
+// snapshot is loaded and the log replayed on top.
+//
+// Values must have a registered Codec. Access-time updates from Get, TTL
+// expiry and eviction are not logged: replayed entries keep the time of their
+// last write and expire from there.
+type WALStore struct {
+	mem  *InMemoryStore
+	opts WALOptions
+
+	mu      sync.Mutex // orders log appends with the in-memory mutations they describe
+	log     *os.File
+	w       *bufio.Writer
+	size    int64
+	dirty   bool  // written since the last fsync
+	lastErr error // sticky write error; the log can't be trusted after one
+
+	compactFailures uint64    // failed compactions, reported by Stats
+	compactRetry    time.Time // no size-triggered compaction before this
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenWALStore opens or creates a WAL store in opts.Dir, replaying any state
+// left by a previous process
+func OpenWALStore(opts WALOptions) (*WALStore, error) {
+	if opts.SyncEvery <= 0 {
+		opts.SyncEvery = time.Second
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &WALStore{
+		mem: NewInMemoryStoreWithOptions(Options{
+			TTL:      opts.TTL,
+			MaxKeys:  opts.MaxKeys,
+			MaxBytes: opts.MaxBytes,
+			Eviction: opts.Eviction,
+			OnEvict:  opts.OnEvict,
+			Clock:    opts.Clock,
+			HLC:      opts.HLC,
+		}),
+		opts: opts,
+		done: make(chan struct{}),
+	}
+	if _, err := LoadSnapshotFile(filepath.Join(opts.Dir, walSnapshotFile), s.mem); err != nil {
+		s.mem.Close()
+		return nil, fmt.Errorf("wal snapshot: %w", err)
+	}
+	if err := s.replay(); err != nil {
+		s.mem.Close()
+		return nil, fmt.Errorf("wal replay: %w", err)
+	}
+	go s.background()
+	return s, nil
+}
+
+// replay applies the log on top of the snapshot. A torn or corrupt tail, as
+// left by a crash mid-append, is truncated away.
+func (s *WALStore) replay() error {
+	path := filepath.Join(s.opts.Dir, walLogFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	now := s.opts.Clock.Now()
+	var good int64
+	for {
+		rec, n, err := readWALRecord(r)
+		if err != nil {
+			// io.EOF is a clean end; after anything else the rest is unreliable
+			break
+		}
+		if err := s.apply(rec, now); err != nil {
+			f.Close()
+			return err
+		}
+		good += n
+	}
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	s.log = f
+	s.w = bufio.NewWriter(f)
+	s.size = good
+	return nil
+}
+
+func (s *WALStore) apply(rec walRecord, now time.Time) error {
+	switch rec.Op {
+	case "set":
+		if s.opts.TTL > 0 && now.Sub(rec.Time) > s.opts.TTL {
+			s.mem.Delete(rec.Key)
+			return nil
+		}
+		val, err := DecodeValue(rec.Type, rec.Value)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", rec.Key, err)
+		}
+		e := Entry{Key: rec.Key, Value: val, LastAccess: rec.Time}
+		if rec.Version != nil {
+			e.Version = *rec.Version
+		}
+		s.mem.put(e)
+	case "del":
+		if rec.Version != nil {
+			// A tombstone: re-bury it unless the TTL has run out
+			if s.opts.TTL <= 0 || now.Sub(rec.Time) <= s.opts.TTL {
+				s.mem.Merge(Entry{Key: rec.Key, LastAccess: rec.Time, Version: *rec.Version, Deleted: true})
+			}
+		}
+		s.mem.Delete(rec.Key)
+	default:
+		return fmt.Errorf("unknown op %q", rec.Op)
+	}
+	return nil
+}
+
+func readWALRecord(r *bufio.Reader) (walRecord, int64, error) {
+	var rec walRecord
+	var hdr [walHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return rec, 0, err // io.EOF only on a clean end
+	}
+	length := binary.LittleEndian.Uint32(hdr[0:4])
+	sum := binary.LittleEndian.Uint32(hdr[4:8])
+	if length > walMaxRecord {
+		return rec, 0, fmt.Errorf("record length %d exceeds %d", length, walMaxRecord)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return rec, 0, io.ErrUnexpectedEOF
+	}
+	if crc32.Checksum(payload, walCRCTable) != sum {
+		return rec, 0, errors.New("checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, 0, err
+	}
+	return rec, int64(walHeaderSize + length), nil
+}
+
+// appendLocked writes rec to the log and honours the sync policy
+func (s *WALStore) appendLocked(rec walRecord) error {
+	if s.lastErr != nil {
+		return s.lastErr
+	}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if len(payload) > walMaxRecord {
+		// Replay would discard it and everything after it
+		s.lastErr = fmt.Errorf("key %q: record of %d bytes exceeds %d", rec.Key, len(payload), walMaxRecord)
+		return s.lastErr
+	}
+	var hdr [walHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(hdr[4:8], crc32.Checksum(payload, walCRCTable))
+	if _, err := s.w.Write(hdr[:]); err != nil {
+		s.lastErr = err
+		return err
+	}
+	if _, err := s.w.Write(payload); err != nil {
+		s.lastErr = err
+		return err
+	}
+	s.size += int64(walHeaderSize + len(payload))
+	s.dirty = true
+
+	switch s.opts.Sync {
+	case SyncAlways:
+		err = s.syncLocked()
+	case SyncNever:
+		err = s.w.Flush()
+	}
+	if err != nil {
+		s.lastErr = err
+	}
+	return err
+}
+
+// maybeCompactLocked compacts once the log outgrows CompactBytes. It runs after
+// the in-memory apply so the snapshot includes the write just logged. After a
+// failure it waits walCompactBackoff before trying again.
+func (s *WALStore) maybeCompactLocked() {
+	if s.opts.CompactBytes <= 0 || s.size <= s.opts.CompactBytes || s.lastErr != nil {
+		return
+	}
+	if s.opts.Clock.Now().Before(s.compactRetry) {
+		return
+	}
+	if err := s.compactLocked(); err != nil {
+		s.compactRetry = s.opts.Clock.Now().Add(walCompactBackoff)
+	}
+}
+
+func (s *WALStore) syncLocked() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if !s.dirty {
+		return nil
+	}
+	s.dirty = false
+	return s.log.Sync()
+}
+
+// compactLocked folds the log into a fresh snapshot and truncates it. If we
+// crash between the two steps, replaying the old log over the new snapshot
+// reapplies writes that are already reflected in it, which is harmless.
+func (s *WALStore) compactLocked() error {
+	if err := s.syncLocked(); err != nil {
+		s.lastErr = err
+		return err
+	}
+	// A failed snapshot leaves the old one and the log intact, so writes stay
+	// durable and only the log's growth suffers; it's counted, not fatal
+	if _, err := SaveSnapshotFile(filepath.Join(s.opts.Dir, walSnapshotFile), s.mem); err != nil {
+		s.compactFailures++
+		return err
+	}
+	if err := s.log.Truncate(0); err != nil {
+		s.lastErr = err
+		return err
+	}
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		s.lastErr = err
+		return err
+	}
+	s.w.Reset(s.log)
+	s.size = 0
+	return s.log.Sync()
+}
+
+// Compact snapshots the current state and truncates the log
+func (s *WALStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compactLocked()
+}
+
+func (s *WALStore) background() {
+	syncTick := s.opts.Clock.NewTicker(s.opts.SyncEvery)
+	defer syncTick.Stop()
+	var compactC <-chan time.Time
+	if s.opts.CompactEvery > 0 {
+		compactTick := s.opts.Clock.NewTicker(s.opts.CompactEvery)
+		defer compactTick.Stop()
+		compactC = compactTick.C()
+	}
+	for {
+		select {
+		case <-syncTick.C():
+			if s.opts.Sync == SyncInterval {
+				s.mu.Lock()
+				if err := s.syncLocked(); err != nil && s.lastErr == nil {
+					s.lastErr = err
+				}
+				s.mu.Unlock()
+			}
+		case <-compactC:
+			s.Compact()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *WALStore) Get(key string) (interface{}, bool) {
+	return s.mem.Get(key)
+}
+
+// Set logs and applies the write. Store.Set has no error return, so a failed
+// append is kept and surfaced by Err and Alive; the value is still applied
+// in memory so the limiter keeps working.
+func (s *WALStore) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := Entry{Key: key, Value: value, LastAccess: s.opts.Clock.Now(), Version: s.mem.stamp()}
+	s.appendSetLocked(e)
+	s.mem.put(e)
+	s.maybeCompactLocked()
+}
+
+func (s *WALStore) appendSetLocked(e Entry) {
+	typ, data, err := EncodeValue(e.Value)
+	if err != nil {
+		s.lastErr = err
+		return
+	}
+	s.appendLocked(walRecord{Op: "set", Key: e.Key, Type: typ, Value: data, Time: e.LastAccess, Version: versionPtr(e.Version)})
+}
+
+// Update applies fn under the store lock and logs the result like Set
+func (s *WALStore) Update(key string, fn func(old interface{}, exists bool) interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.opts.Clock.Now()
+	var value interface{}
+	version := s.mem.update(key, func(old interface{}, exists bool) interface{} {
+		value = fn(old, exists)
+		return value
+	})
+	s.appendSetLocked(Entry{Key: key, Value: value, LastAccess: now, Version: version})
+	s.maybeCompactLocked()
+}
+
+// Merge applies and logs a replicated entry if it is newer than ours
+func (s *WALStore) Merge(e Entry) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.mem.Merge(e) {
+		return false
+	}
+	s.appendMergedLocked(e)
+	s.maybeCompactLocked()
+	return true
+}
+
+// appendMergedLocked logs an entry merged from elsewhere, tombstones included
+func (s *WALStore) appendMergedLocked(e Entry) {
+	if e.Deleted {
+		s.appendLocked(walRecord{Op: "del", Key: e.Key, Time: e.LastAccess, Version: versionPtr(e.Version)})
+		return
+	}
+	s.appendSetLocked(e)
+}
+
+func (s *WALStore) Delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appendLocked(walRecord{Op: "del", Key: key, Time: s.opts.Clock.Now()})
+	ok := s.mem.Delete(key)
+	s.maybeCompactLocked()
+	return ok
+}
+
+// MergeWith logs whatever the merge stored
+func (s *WALStore) MergeWith(e Entry, combine func(local, remote interface{}) interface{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	merged, ok := s.mem.mergeWith(e, combine)
+	if !ok {
+		return false
+	}
+	s.appendMergedLocked(merged)
+	s.maybeCompactLocked()
+	return true
+}
+
+// Tombstone logs the delete with its version, so the tombstone survives a
+// restart until compaction
+func (s *WALStore) Tombstone(key string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tomb, ok := s.mem.Tombstone(key)
+	s.appendLocked(walRecord{Op: "del", Key: key, Time: tomb.LastAccess, Version: versionPtr(tomb.Version)})
+	s.maybeCompactLocked()
+	return tomb, ok
+}
+
+func (s *WALStore) Range(fn func(key string, value interface{}) bool) {
+	s.mem.Range(fn)
+}
+
+func (s *WALStore) Dump(fn func(Entry) bool) {
+	s.mem.Dump(fn)
+}
+
+func (s *WALStore) Export(key string) (Entry, bool) {
+	return s.mem.Export(key)
+}
+
+func (s *WALStore) Load(e Entry) {
+	s.mem.Load(e)
+}
+
+func (s *WALStore) Clock() clock.Clock {
+	return s.mem.Clock()
+}
+
+func (s *WALStore) TTL() time.Duration {
+	return s.mem.TTL()
+}
+
+func (s *WALStore) Stats() Stats {
+	st := s.mem.Stats()
+	s.mu.Lock()
+	st.CompactionFailures = s.compactFailures
+	s.mu.Unlock()
+	return st
+}
+
+// Err returns the first log write error, after which writes are no longer durable
+func (s *WALStore) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// Alive fails if the cleanup goroutine is stuck or the log can no longer be
+// written. Failed compactions are only counted in Stats: the log still holds
+// every write, and a restart wouldn't free the disk they need.
+func (s *WALStore) Alive() error {
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("store: wal: %w", err)
+	}
+	return s.mem.Alive()
+}
+
+// Close flushes and syncs the log and stops background work
+func (s *WALStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		err = s.syncLocked()
+		if cerr := s.log.Close(); err == nil {
+			err = cerr
+		}
+		s.mem.Close()
+	})
+	return err
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"RateLimiterService/pkg/clock"
+)
+
+func openTestWAL(t *testing.T, dir string, compactBytes int64) *WALStore {
+	t.Helper()
+	s, err := OpenWALStore(WALOptions{Dir: dir, TTL: time.Hour, Sync: SyncAlways, CompactBytes: compactBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestWALStoreReplaysAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestWAL(t, dir, 0)
+	s.Set("a", testState{N: 1})
+	s.Set("b", testState{N: 2})
+	s.Set("a", testState{N: 3})
+	s.Delete("b")
+	s.Update("c", func(old interface{}, exists bool) interface{} {
+		return testState{N: 5}
+	})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestWAL(t, dir, 0)
+	defer s.Close()
+	if v, ok := s.Get("a"); !ok || v != (testState{N: 3}) {
+		t.Errorf("a = %v, %v; want latest write", v, ok)
+	}
+	if _, ok := s.Get("b"); ok {
+		t.Error("Expected deleted key to stay deleted")
+	}
+	if v, _ := s.Get("c"); v != (testState{N: 5}) {
+		t.Errorf("c = %v; want value written by Update", v)
+	}
+}
+
+func TestWALStoreCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestWAL(t, dir, 200)
+	for i := 0; i < 20; i++ {
+		s.Set("counter", testState{N: i})
+	}
+	s.Set("other", testState{N: 7})
+	if s.size > 200 {
+		t.Errorf("Expected log to be compacted, size %d", s.size)
+	}
+	if _, err := os.Stat(filepath.Join(dir, walSnapshotFile)); err != nil {
+		t.Fatalf("Expected snapshot after compaction: %v", err)
+	}
+	s.Close()
+
+	s = openTestWAL(t, dir, 200)
+	defer s.Close()
+	if v, _ := s.Get("counter"); v != (testState{N: 19}) {
+		t.Errorf("counter = %v", v)
+	}
+	if v, _ := s.Get("other"); v != (testState{N: 7}) {
+		t.Errorf("other = %v", v)
+	}
+}
+
+func TestWALStoreTruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestWAL(t, dir, 0)
+	s.Set("a", testState{N: 1})
+	s.Close()
+
+	// Simulate a crash halfway through appending a record
+	logPath := filepath.Join(dir, walLogFile)
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0x40, 0, 0, 0, 1, 2, 3, 4, '{', '"'})
+	f.Close()
+
+	s = openTestWAL(t, dir, 0)
+	if v, _ := s.Get("a"); v != (testState{N: 1}) {
+		t.Errorf("a = %v", v)
+	}
+	s.Set("b", testState{N: 2})
+	s.Close()
+
+	s = openTestWAL(t, dir, 0)
+	defer s.Close()
+	if v, _ := s.Get("b"); v != (testState{N: 2}) {
+		t.Errorf("Expected write after truncated tail to survive, b = %v", v)
+	}
+}
+
+func TestWALStoreRejectsOversizedRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestWAL(t, dir, 0)
+	s.Set("a", testState{N: 1})
+	s.Close()
+
+	// A corrupt header claiming a 4GB record must not be allocated
+	f, err := os.OpenFile(filepath.Join(dir, walLogFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hdr [walHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], 0xffffffff)
+	f.Write(hdr[:])
+	f.Close()
+
+	s = openTestWAL(t, dir, 0)
+	defer s.Close()
+	if v, _ := s.Get("a"); v != (testState{N: 1}) {
+		t.Errorf("a = %v", v)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, walLogFile)); info.Size() != s.size {
+		t.Errorf("Expected corrupt tail truncated, file %d bytes, log %d", info.Size(), s.size)
+	}
+}
+
+func TestWALStoreBacksOffFailedCompaction(t *testing.T) {
+	dir := t.TempDir()
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	s, err := OpenWALStore(WALOptions{Dir: dir, TTL: time.Hour, Clock: c, Sync: SyncAlways, CompactBytes: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// A non-empty directory where the snapshot goes makes the rename fail
+	blocker := filepath.Join(dir, walSnapshotFile)
+	if err := os.MkdirAll(filepath.Join(blocker, "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		s.Set("counter", testState{N: i})
+	}
+	if s.size <= 200 {
+		t.Fatalf("Expected compaction to fail, size %d", s.size)
+	}
+	if n := s.Stats().CompactionFailures; n != 1 {
+		t.Errorf("Expected one failed compaction counted, got %d", n)
+	}
+	if err := s.Alive(); err != nil {
+		t.Errorf("Expected a failed compaction not to fail Alive, got %v", err)
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Expected writes to stay durable, got %v", err)
+	}
+
+	// Fixed, but writes don't retry until the backoff passes
+	os.RemoveAll(blocker)
+	s.Set("counter", testState{N: 20})
+	if s.size <= 200 {
+		t.Error("Expected no compaction during the backoff")
+	}
+	c.Advance(walCompactBackoff)
+	s.Set("counter", testState{N: 21})
+	if s.size > 200 {
+		t.Errorf("Expected compaction after the backoff, size %d", s.size)
+	}
+	if n := s.Stats().CompactionFailures; n != 1 {
+		t.Errorf("Expected the count to stay at 1, got %d", n)
+	}
+}
@@ -1,17 +1,19 @@
-package ratelimiter
+package ratelimiter_test
 
 import (
 	"testing"
 	"time"
 
 	"RateLimiterService/pkg/clock"
+	"RateLimiterService/pkg/ratelimiter"
 	"RateLimiterService/pkg/store"
 )
 
 func TestTokenBucket(t *testing.T) {
-	c := clock.RealClock{}
-	s := store.NewInMemoryStore(1 * time.Hour)
-	tb := NewTokenBucket(5, 1, c, s)
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	s := store.NewInMemoryStoreWithOptions(store.Options{TTL: 1 * time.Hour, Clock: c})
+	defer s.Close()
+	tb := ratelimiter.NewTokenBucket(5, 1, c, s)
 	key := "test"
 
 	// Should allow 5 requests immediately
@@ -29,19 +31,20 @@ func TestTokenBucket(t *testing.T) {
 	}
 
 	// Wait for refill
-	time.Sleep(6 * time.Second)
+	c.Advance(6 * time.Second)
 
 	// Should allow again
-	allowed, _ := tb.Allow(key)
+	allowed, _ = tb.Allow(key)
 	if !allowed {
 		t.Error("Expected allow after refill")
 	}
 }
 
 func TestSlidingWindow(t *testing.T) {
-	c := clock.RealClock{}
-	s := store.NewInMemoryStore(1 * time.Hour)
-	sw := NewSlidingWindow(10*time.Second, 3, c, s)
+	c := clock.NewFakeClock(time.Unix(1700000000, 0))
+	s := store.NewInMemoryStoreWithOptions(store.Options{TTL: 1 * time.Hour, Clock: c})
+	defer s.Close()
+	sw := ratelimiter.NewSlidingWindow(10*time.Second, 3, c, s)
 	key := "test"
 
 	// Allow 3 requests
@@ -59,11 +62,11 @@ func TestSlidingWindow(t *testing.T) {
 	}
 
 	// Wait for window to slide
-	time.Sleep(11 * time.Second)
+	c.Advance(11 * time.Second)
 
 	// Should allow again
-	allowed, _ := sw.Allow(key)
+	allowed, _ = sw.Allow(key)
 	if !allowed {
 		t.Error("Expected allow after window slides")
 	}
-}
\ No newline at end of file
+}